@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// Set by -allow-plaintext-auth and -allow-inline-secrets. The first lets
+// credentials go to endpoints without TLS, the second lets secrets be
+// written inline in the config.
+var (
+	allowPlaintextAuth bool
+	allowInlineSecrets bool
+)
+
+// secretSource says where to read a secret from. Exactly one field should be
+// set; File and Env keep the secret itself out of flags and config files.
+// Value, an inline secret, needs -allow-inline-secrets.
+type secretSource struct {
+	Value string `json:"value,omitempty"`
+	File  string `json:"file,omitempty"`
+	Env   string `json:"env,omitempty"`
+}
+
+// resolve returns the secret. File secrets are re-read on every call so a
+// rotated token is picked up without a restart.
+func (s secretSource) resolve() (string, error) {
+	switch {
+	case s.File != "":
+		data, err := os.ReadFile(s.File)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file %s: %w", s.File, err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	case s.Env != "":
+		value, ok := os.LookupEnv(s.Env)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", s.Env)
+		}
+		return value, nil
+	case s.Value != "":
+		return s.Value, nil
+	default:
+		return "", errors.New("secret has no value, file or env")
+	}
+}
+
+// String describes where the secret comes from without revealing it.
+func (s secretSource) String() string {
+	switch {
+	case s.File != "":
+		return "file:" + s.File
+	case s.Env != "":
+		return "env:" + s.Env
+	default:
+		return redacted
+	}
+}
+
+// MarshalJSON keeps secrets out of any JSON the config is printed as.
+func (s secretSource) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// authConfig is the metadata sent with every RPC to an endpoint.
+type authConfig struct {
+	Headers map[string]secretSource
+	Token   *secretSource
+	// plaintext allows the metadata to be sent without TLS
+	plaintext bool
+	// inline allows secrets given inline
+	inline bool
+}
+
+// GetRequestMetadata implements credentials.PerRPCCredentials.
+func (a *authConfig) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	md := make(map[string]string, len(a.Headers)+1)
+	for name, source := range a.Headers {
+		value, err := source.resolve()
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %w", name, err)
+		}
+		md[strings.ToLower(name)] = value
+	}
+	if a.Token != nil {
+		token, err := a.Token.resolve()
+		if err != nil {
+			return nil, fmt.Errorf("token: %w", err)
+		}
+		md["authorization"] = "Bearer " + token
+	}
+	return md, nil
+}
+
+// RequireTransportSecurity implements credentials.PerRPCCredentials.
+// Credentials only go over plain text with -allow-plaintext-auth.
+func (a *authConfig) RequireTransportSecurity() bool {
+	return !a.plaintext
+}
+
+// validate checks that every secret can be resolved, and that none is
+// inline unless allowed.
+func (a *authConfig) validate() error {
+	if !a.inline {
+		for name, source := range a.Headers {
+			if source.Value != "" {
+				return fmt.Errorf("header %s: inline secret values need -allow-inline-secrets; use file or env", name)
+			}
+		}
+		if a.Token != nil && a.Token.Value != "" {
+			return errors.New("token: inline secret values need -allow-inline-secrets; use file or env")
+		}
+	}
+	_, err := a.GetRequestMetadata(context.Background())
+	return err
+}
+
+// redactAddress hides credentials that may be embedded in an address, such
+// as user info or query parameters carrying API keys.
+func redactAddress(addr string) string {
+	scheme := ""
+	rest := addr
+	if i := strings.Index(addr, "://"); i != -1 {
+		scheme, rest = addr[:i+3], addr[i+3:]
+	}
+	if i := strings.LastIndex(rest, "@"); i != -1 {
+		rest = redacted + "@" + rest[i+1:]
+	}
+	if i := strings.Index(rest, "?"); i != -1 {
+		query, err := url.ParseQuery(rest[i+1:])
+		if err != nil {
+			return scheme + rest[:i] + "?" + redacted
+		}
+		keys := make([]string, 0, len(query))
+		for key := range query {
+			keys = append(keys, key+"="+redacted)
+		}
+		sort.Strings(keys)
+		rest = rest[:i] + "?" + strings.Join(keys, "&")
+	}
+	return scheme + rest
+}
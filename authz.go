@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	signaltypes "celestia-upgrade-monitor/celestia/signal/v1"
+
+	authztypes "cosmossdk.io/api/cosmos/authz/v1beta1"
+	abcitypes "cosmossdk.io/api/cosmos/base/abci/v1beta1"
+	"github.com/cosmos/cosmos-sdk/types/bech32"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+const (
+	msgExecURL              = "/cosmos.authz.v1beta1.MsgExec"
+	genericAuthorizationURL = "/cosmos.authz.v1beta1.GenericAuthorization"
+)
+
+var errNoGrant = errors.New("no grant")
+
+// validatorAccount returns the account address of a validator operator,
+// which is what grants authz permissions on its behalf.
+func validatorAccount(valoper string) (string, error) {
+	addr, err := valoperAccount(valoper)
+	if err != nil {
+		return "", err
+	}
+	return bech32.ConvertAndEncode(accountPrefix, addr)
+}
+
+// signalGrant looks up a GenericAuthorization from granter to grantee for
+// MsgSignalVersion that is still valid at now, and returns when it
+// expires. The expiry is zero for a grant that never expires, which is
+// preferred over one that does; otherwise the latest expiry wins.
+func signalGrant(ctx context.Context, b backend, granter, grantee string, now time.Time) (time.Time, error) {
+	grants, err := b.Grants(ctx, granter, grantee, msgSignalVersionURL)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var (
+		expiry time.Time
+		found  bool
+	)
+	for _, grant := range grants {
+		var auth authztypes.GenericAuthorization
+		if grant.GetAuthorization().GetTypeUrl() != genericAuthorizationURL ||
+			grant.GetAuthorization().UnmarshalTo(&auth) != nil ||
+			auth.GetMsg() != msgSignalVersionURL {
+			continue
+		}
+		if grant.GetExpiration() == nil {
+			return time.Time{}, nil
+		}
+		exp := grant.GetExpiration().AsTime()
+		if exp.After(now) && exp.After(expiry) {
+			expiry, found = exp, true
+		}
+	}
+	if !found {
+		return time.Time{}, fmt.Errorf("%w from %s to %s for %s", errNoGrant, granter, grantee, msgSignalVersionURL)
+	}
+	return expiry, nil
+}
+
+// authzSignalVersion signals for valoper with s's key as an authz grantee,
+// wrapping MsgSignalVersion in MsgExec once a valid grant is found.
+func authzSignalVersion(ctx context.Context, s *txSigner, version uint64, valoper string) (signalResult, error) {
+	version, err := prepareSignal(ctx, s, version)
+	if err != nil {
+		return signalResult{}, err
+	}
+	granter, err := validatorAccount(valoper)
+	if err != nil {
+		return signalResult{}, err
+	}
+	key, err := s.key()
+	if err != nil {
+		return signalResult{}, err
+	}
+	grantee := key.bech32(accountPrefix)
+
+	b := &grpcBackend{conn: s.conn}
+	expiry, err := signalGrant(ctx, b, granter, grantee, time.Now())
+	if err != nil {
+		return signalResult{}, fmt.Errorf("grant check failed: %w", err)
+	}
+	if expiry.IsZero() {
+		log.Printf("%s may signal for %s; the grant does not expire", grantee, valoper)
+	} else {
+		log.Printf("%s may signal for %s until %s", grantee, valoper, expiry.Format(time.RFC3339))
+	}
+
+	msg, err := newAny(&signaltypes.MsgSignalVersion{ValidatorAddress: valoper, Version: version})
+	if err != nil {
+		return signalResult{}, err
+	}
+	return trackSignal(ctx, b, version, valoper, func() (*abcitypes.TxResponse, error) {
+		log.Printf("Signalling for version %d as %s through authz grantee %s on %s", version, valoper, grantee, s.chainID)
+		return s.submit(ctx, &authztypes.MsgExec{Grantee: grantee, Msgs: []*anypb.Any{msg}})
+	})
+}
+
+// grantWatch is a validator whose signal grant to a grantee is monitored.
+type grantWatch struct {
+	Validator string
+	Granter   string
+	Grantee   string
+}
+
+// parseGrantWatches parses a comma-separated list of valoper=grantee pairs.
+func parseGrantWatches(s string) ([]grantWatch, error) {
+	var watches []grantWatch
+	for _, pair := range strings.Split(s, ",") {
+		valoper, grantee, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || grantee == "" {
+			return nil, fmt.Errorf("invalid grant %q, expected valoper=grantee", pair)
+		}
+		granter, err := validatorAccount(valoper)
+		if err != nil {
+			return nil, err
+		}
+		watches = append(watches, grantWatch{Validator: valoper, Granter: granter, Grantee: grantee})
+	}
+	return watches, nil
+}
+
+// grantWatcher periodically checks signal grants and warns when one is
+// missing or expires within warnBefore, so it can be renewed well before
+// the next upgrade needs it.
+type grantWatcher struct {
+	watches    []grantWatch
+	warnBefore time.Duration
+
+	mu sync.Mutex
+	// expiring holds the last reported state of each watch
+	expiring map[grantWatch]bool
+}
+
+func newGrantWatcher(watches []grantWatch, warnBefore time.Duration) *grantWatcher {
+	return &grantWatcher{watches: watches, warnBefore: warnBefore, expiring: map[grantWatch]bool{}}
+}
+
+// run checks the grants now and then every interval.
+func (g *grantWatcher) run(interval time.Duration) {
+	for {
+		g.check(time.Now())
+		time.Sleep(interval)
+	}
+}
+
+func (g *grantWatcher) check(now time.Time) {
+	for _, w := range g.watches {
+		var (
+			expiry  time.Time
+			missing bool
+		)
+		err := queryEndpoints(func(b backend) (string, error) {
+			var err error
+			expiry, err = signalGrant(context.Background(), b, w.Granter, w.Grantee, now)
+			if errors.Is(err, errNoGrant) {
+				// Not the endpoint's fault
+				missing = true
+				return "", nil
+			}
+			return "", err
+		})
+		if err != nil {
+			log.Printf("Failed to check the signal grant from %s to %s: %v", w.Validator, w.Grantee, err)
+			continue
+		}
+		g.update(w, expiry, missing, now)
+	}
+}
+
+// update sets the grant metrics and emits an event when a grant starts
+// needing attention.
+func (g *grantWatcher) update(w grantWatch, expiry time.Time, missing bool, now time.Time) {
+	var message string
+	switch {
+	case missing:
+		authzGrantExpiry.DeleteLabelValues(w.Validator, w.Grantee)
+		message = fmt.Sprintf("%s has no valid grant to signal for %s", w.Grantee, w.Validator)
+	case expiry.IsZero():
+		authzGrantExpiry.WithLabelValues(w.Validator, w.Grantee).Set(0)
+	default:
+		authzGrantExpiry.WithLabelValues(w.Validator, w.Grantee).Set(float64(expiry.Unix()))
+		if left := expiry.Sub(now); left < g.warnBefore {
+			message = fmt.Sprintf("grant for %s to signal for %s expires in %s, at %s", w.Grantee, w.Validator, left.Round(time.Hour), expiry.Format(time.RFC3339))
+		}
+	}
+	expiring := message != ""
+	if expiring {
+		authzGrantExpiring.WithLabelValues(w.Validator, w.Grantee).Set(1)
+	} else {
+		authzGrantExpiring.WithLabelValues(w.Validator, w.Grantee).Set(0)
+	}
+
+	g.mu.Lock()
+	was := g.expiring[w]
+	g.expiring[w] = expiring
+	g.mu.Unlock()
+	if !expiring || was {
+		return
+	}
+	fields := map[string]string{"validator": w.Validator, "grantee": w.Grantee}
+	if !missing && !expiry.IsZero() {
+		fields["expiration"] = expiry.Format(time.RFC3339)
+	}
+	emitEvent(Event{
+		Kind:     "authz_grant_expiring",
+		Severity: severityWarning,
+		Message:  message,
+		Fields:   fields,
+	})
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	signaltypes "celestia-upgrade-monitor/celestia/signal/v1"
+)
+
+// tryUpgradeRetry is how long to wait before submitting MsgTryUpgrade for
+// a version again, whether the last attempt failed or was included
+// without scheduling the upgrade.
+const tryUpgradeRetry = 10 * time.Minute
+
+// tryUpgrader submits MsgTryUpgrade once the tally for the target version
+// reaches the threshold and no upgrade is scheduled yet.
+type tryUpgrader struct {
+	signer *txSigner
+
+	mu       sync.Mutex
+	inFlight bool
+	// attempts holds when MsgTryUpgrade was last submitted for a version
+	attempts map[uint64]time.Time
+}
+
+// autoTry is set when -try-upgrade-from is given, nil otherwise.
+var autoTry *tryUpgrader
+
+func newTryUpgrader(signer *txSigner) *tryUpgrader {
+	return &tryUpgrader{signer: signer, attempts: map[uint64]time.Time{}}
+}
+
+// tryUpgradeDue reports whether a poll result calls for a MsgTryUpgrade.
+func tryUpgradeDue(data UpgradeData) bool {
+	tally := data.TallyData
+	return data.UpgradeData.Upgrade.UpgradeHeight == 0 &&
+		tally.Version > data.AppVersion &&
+		tally.ThresholdPower > 0 &&
+		tally.VotingPower >= tally.ThresholdPower
+}
+
+// check starts a submission in the background if one is due and none is
+// already running or was made recently for the same version.
+func (t *tryUpgrader) check(data UpgradeData) {
+	if !tryUpgradeDue(data) {
+		return
+	}
+	version := data.TallyData.Version
+	t.mu.Lock()
+	if t.inFlight || time.Since(t.attempts[version]) < tryUpgradeRetry {
+		t.mu.Unlock()
+		return
+	}
+	t.inFlight = true
+	t.attempts[version] = time.Now()
+	t.mu.Unlock()
+
+	go func() {
+		defer func() {
+			t.mu.Lock()
+			t.inFlight = false
+			t.mu.Unlock()
+		}()
+		t.submit(context.Background(), data)
+	}()
+}
+
+// submit sends MsgTryUpgrade and reports the outcome as events.
+func (t *tryUpgrader) submit(ctx context.Context, data UpgradeData) {
+	version := data.TallyData.Version
+
+	// Someone else may have sent it since the poll
+	latest, err := queryUpgrade(0)
+	if err == nil && !tryUpgradeDue(latest) {
+		log.Printf("Not sending MsgTryUpgrade: no longer due at height %d", latest.Height)
+		return
+	}
+
+	key, err := t.signer.key()
+	if err != nil {
+		t.failed(data.Height, version, err)
+		return
+	}
+	signer := key.bech32(accountPrefix)
+	if t.signer.chainID == "" {
+		t.signer.chainID = data.ChainID
+	}
+	log.Printf("Tally for version %d reached %d of %d (threshold %d); sending MsgTryUpgrade from %s", version, data.TallyData.VotingPower, data.TallyData.TotalVotingPower, data.TallyData.ThresholdPower, signer)
+
+	resp, err := t.signer.submit(ctx, &signaltypes.MsgTryUpgrade{Signer: signer})
+	if errors.Is(err, errFeeTooHigh) {
+		tryUpgradeSubmissions.WithLabelValues("skipped").Inc()
+		emitEvent(Event{
+			Kind:     "try_upgrade_skipped",
+			Severity: severityWarning,
+			Message:  fmt.Sprintf("MsgTryUpgrade for version %d not sent: %v", version, err),
+			Height:   data.Height,
+			Fields:   map[string]string{"version": strconv.FormatUint(version, 10), "signer": signer},
+		})
+		return
+	}
+	if err != nil {
+		t.failed(data.Height, version, err)
+		return
+	}
+	tryUpgradeSubmissions.WithLabelValues("submitted").Inc()
+
+	fields := map[string]string{
+		"version": strconv.FormatUint(version, 10),
+		"signer":  signer,
+		"tx_hash": resp.GetTxhash(),
+	}
+	after, err := queryUpgrade(resp.GetHeight())
+	if err != nil || after.UpgradeData.Upgrade.UpgradeHeight == 0 {
+		emitEvent(Event{
+			Kind:     "try_upgrade_not_scheduled",
+			Severity: severityWarning,
+			Message:  fmt.Sprintf("MsgTryUpgrade %s was included at height %d but no upgrade is scheduled", resp.GetTxhash(), resp.GetHeight()),
+			Height:   resp.GetHeight(),
+			Fields:   fields,
+		})
+		return
+	}
+	fields["upgrade_height"] = strconv.FormatInt(after.UpgradeData.Upgrade.UpgradeHeight, 10)
+	emitEvent(Event{
+		Kind:    "try_upgrade_submitted",
+		Message: fmt.Sprintf("MsgTryUpgrade %s scheduled the upgrade to version %d at height %d", resp.GetTxhash(), after.UpgradeData.Upgrade.AppVersion, after.UpgradeData.Upgrade.UpgradeHeight),
+		Height:  resp.GetHeight(),
+		Fields:  fields,
+	})
+	requestPoll()
+}
+
+func (t *tryUpgrader) failed(height int64, version uint64, err error) {
+	tryUpgradeSubmissions.WithLabelValues("failed").Inc()
+	emitEvent(Event{
+		Kind:     "try_upgrade_failed",
+		Severity: severityWarning,
+		Message:  fmt.Sprintf("failed to send MsgTryUpgrade for version %d: %v", version, err),
+		Height:   height,
+		Fields:   map[string]string{"version": strconv.FormatUint(version, 10)},
+	})
+}
+
+// setupTryUpgrade prepares the signer for automatic MsgTryUpgrade. It
+// broadcasts through the first gRPC endpoint and loads the key now, so a
+// file keyring asks for its passphrase at startup.
+func setupTryUpgrade(from, backend, dir, gasPrices, maxFee string) (*tryUpgrader, error) {
+	var address *endpointAddress
+	for _, e := range endpoints {
+		if e.address.transport == transportGRPC {
+			address = &e.address
+			break
+		}
+	}
+	if address == nil {
+		return nil, errors.New("transactions can only be broadcast over gRPC, but no gRPC endpoint is configured")
+	}
+	price, err := parseGasPrice(gasPrices)
+	if err != nil {
+		return nil, err
+	}
+	feeCap, denom, err := parseCoin(maxFee)
+	if err != nil {
+		return nil, err
+	}
+	if denom != price.denom {
+		return nil, fmt.Errorf("-max-fee is in %s but -gas-prices is in %s", denom, price.denom)
+	}
+	kr, err := openKeyring(backend, dir)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := grpcClient(*address)
+	if err != nil {
+		return nil, err
+	}
+	signer := &txSigner{
+		conn:          conn,
+		keyring:       kr,
+		from:          from,
+		gasPrice:      price,
+		gasAdjustment: 1.3,
+		maxFee:        feeCap,
+		timeout:       time.Minute,
+	}
+	key, err := signer.key()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	log.Printf("Will send MsgTryUpgrade from %s through %s once the threshold is reached", key.bech32(accountPrefix), *address)
+	return newTryUpgrader(signer), nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	signaltypes "celestia-upgrade-monitor/celestia/signal/v1"
+
+	authztypes "cosmossdk.io/api/cosmos/authz/v1beta1"
+	cmtservice "cosmossdk.io/api/cosmos/base/tendermint/v1beta1"
+	stakingtypes "cosmossdk.io/api/cosmos/staking/v1beta1"
+	txtypes "cosmossdk.io/api/cosmos/tx/v1beta1"
+	cmtversion "cosmossdk.io/api/tendermint/version"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// backend is a transport the monitor queries the chain through. Heights of
+// 0 mean the latest height; queries that take a height also return the
+// height the node reports having served, or 0 if it doesn't say.
+type backend interface {
+	Block(ctx context.Context, height int64) (blockInfo, error)
+	GetUpgrade(ctx context.Context, height int64) (*signaltypes.QueryGetUpgradeResponse, int64, error)
+	VersionTally(ctx context.Context, version uint64, height int64) (*signaltypes.QueryVersionTallyResponse, int64, error)
+	Validator(ctx context.Context, valoper string) (*stakingtypes.Validator, error)
+	Grants(ctx context.Context, granter, grantee, msgTypeURL string) ([]*authztypes.Grant, error)
+	// TxsEvent searches for up to limit transactions matching an event
+	// query, newest first
+	TxsEvent(ctx context.Context, query string, limit uint64) (*txtypes.GetTxsEventResponse, error)
+	NodeInfo(ctx context.Context) (*cmtservice.GetNodeInfoResponse, error)
+	Syncing(ctx context.Context) (bool, error)
+	Close() error
+}
+
+// blockInfo is the part of a block header needed to tie a query to a block.
+type blockInfo struct {
+	ChainID    string
+	Height     int64
+	AppVersion uint64
+	Time       time.Time
+}
+
+// newBackend connects to an endpoint using its transport, recording its
+// calls if -record is set.
+func newBackend(address endpointAddress) (backend, error) {
+	var b backend
+	var err error
+	switch address.transport {
+	case transportREST:
+		b, err = newRESTBackend(address)
+	case transportComet:
+		b, err = newCometBackend(address)
+	case transportReplay:
+		b, err = newReplayBackend(address.addr)
+	default:
+		var conn *grpc.ClientConn
+		conn, err = grpcClient(address)
+		b = &grpcBackend{conn: conn}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if recorder != nil {
+		b = recorder.wrap(b, address)
+	}
+	return b, nil
+}
+
+// grpcBackend queries a node's gRPC server.
+type grpcBackend struct {
+	conn *grpc.ClientConn
+}
+
+// blockHeaderFields is implemented by both the CometBFT and SDK headers.
+type blockHeaderFields interface {
+	GetChainId() string
+	GetHeight() int64
+	GetVersion() *cmtversion.Consensus
+	GetTime() *timestamppb.Timestamp
+}
+
+func headerToBlockInfo(header blockHeaderFields) blockInfo {
+	return blockInfo{
+		ChainID:    header.GetChainId(),
+		Height:     header.GetHeight(),
+		AppVersion: header.GetVersion().GetApp(),
+		Time:       header.GetTime().AsTime(),
+	}
+}
+
+func (b *grpcBackend) Block(ctx context.Context, height int64) (blockInfo, error) {
+	client := cmtservice.NewServiceClient(b.conn)
+	if height > 0 {
+		resp, err := client.GetBlockByHeight(ctx, &cmtservice.GetBlockByHeightRequest{Height: height})
+		if err != nil {
+			return blockInfo{}, fmt.Errorf("failed to get block %d: %w", height, err)
+		}
+		if resp.SdkBlock != nil {
+			return headerToBlockInfo(resp.SdkBlock.GetHeader()), nil
+		}
+		return headerToBlockInfo(resp.Block.GetHeader()), nil
+	}
+	resp, err := client.GetLatestBlock(ctx, &cmtservice.GetLatestBlockRequest{})
+	if err != nil {
+		return blockInfo{}, fmt.Errorf("failed to get latest block: %w", err)
+	}
+	if resp.SdkBlock != nil {
+		return headerToBlockInfo(resp.SdkBlock.GetHeader()), nil
+	}
+	return headerToBlockInfo(resp.Block.GetHeader()), nil
+}
+
+func (b *grpcBackend) GetUpgrade(ctx context.Context, height int64) (*signaltypes.QueryGetUpgradeResponse, int64, error) {
+	var header metadata.MD
+	resp, err := signaltypes.NewQueryClient(b.conn).GetUpgrade(withHeight(ctx, height), &signaltypes.QueryGetUpgradeRequest{}, grpc.Header(&header))
+	if err != nil {
+		return nil, 0, err
+	}
+	served, _ := heightFromHeader(header)
+	return resp, served, nil
+}
+
+func (b *grpcBackend) VersionTally(ctx context.Context, version uint64, height int64) (*signaltypes.QueryVersionTallyResponse, int64, error) {
+	var header metadata.MD
+	resp, err := signaltypes.NewQueryClient(b.conn).VersionTally(withHeight(ctx, height), &signaltypes.QueryVersionTallyRequest{Version: version}, grpc.Header(&header))
+	if err != nil {
+		return nil, 0, err
+	}
+	served, _ := heightFromHeader(header)
+	return resp, served, nil
+}
+
+func (b *grpcBackend) Validator(ctx context.Context, valoper string) (*stakingtypes.Validator, error) {
+	resp, err := stakingtypes.NewQueryClient(b.conn).Validator(ctx, &stakingtypes.QueryValidatorRequest{ValidatorAddr: valoper})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Validator, nil
+}
+
+func (b *grpcBackend) Grants(ctx context.Context, granter, grantee, msgTypeURL string) ([]*authztypes.Grant, error) {
+	resp, err := authztypes.NewQueryClient(b.conn).Grants(ctx, &authztypes.QueryGrantsRequest{Granter: granter, Grantee: grantee, MsgTypeUrl: msgTypeURL})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Grants, nil
+}
+
+func (b *grpcBackend) TxsEvent(ctx context.Context, query string, limit uint64) (*txtypes.GetTxsEventResponse, error) {
+	return txtypes.NewServiceClient(b.conn).GetTxsEvent(ctx, txsEventRequest(query, limit))
+}
+
+func (b *grpcBackend) NodeInfo(ctx context.Context) (*cmtservice.GetNodeInfoResponse, error) {
+	return cmtservice.NewServiceClient(b.conn).GetNodeInfo(ctx, &cmtservice.GetNodeInfoRequest{})
+}
+
+func (b *grpcBackend) Syncing(ctx context.Context) (bool, error) {
+	resp, err := cmtservice.NewServiceClient(b.conn).GetSyncing(ctx, &cmtservice.GetSyncingRequest{})
+	if err != nil {
+		return false, err
+	}
+	return resp.Syncing, nil
+}
+
+func txsEventRequest(query string, limit uint64) *txtypes.GetTxsEventRequest {
+	return &txtypes.GetTxsEventRequest{Query: query, OrderBy: txtypes.OrderBy_ORDER_BY_DESC, Page: 1, Limit: limit}
+}
+
+func (b *grpcBackend) Close() error {
+	return b.conn.Close()
+}
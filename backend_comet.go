@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	signaltypes "celestia-upgrade-monitor/celestia/signal/v1"
+
+	authztypes "cosmossdk.io/api/cosmos/authz/v1beta1"
+	abcitypes "cosmossdk.io/api/cosmos/base/abci/v1beta1"
+	cmtservice "cosmossdk.io/api/cosmos/base/tendermint/v1beta1"
+	stakingtypes "cosmossdk.io/api/cosmos/staking/v1beta1"
+	txtypes "cosmossdk.io/api/cosmos/tx/v1beta1"
+	"cosmossdk.io/api/tendermint/p2p"
+	cmtjson "github.com/cometbft/cometbft/libs/json"
+	coretypes "github.com/cometbft/cometbft/rpc/core/types"
+	cmttypes "github.com/cometbft/cometbft/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// cometBackend runs queries as ABCI queries over a node's CometBFT
+// JSON-RPC, for nodes that only expose port 26657.
+type cometBackend struct {
+	baseURL string
+	display string
+	client  *http.Client
+	auth    *authConfig
+}
+
+// abciQueryResponse is the response of the abci_query RPC.
+type abciQueryResponse struct {
+	Code      uint32    `json:"code"`
+	Log       string    `json:"log"`
+	Codespace string    `json:"codespace"`
+	Key       []byte    `json:"key"`
+	Value     []byte    `json:"value"`
+	ProofOps  *proofOps `json:"proofOps"`
+	Height    int64     `json:"height,string"`
+}
+
+// proofOps is the Merkle proof returned by abci_query with prove=true,
+// innermost store first.
+type proofOps struct {
+	Ops []proofOp `json:"ops"`
+}
+
+type proofOp struct {
+	Type string `json:"type"`
+	Key  []byte `json:"key"`
+	Data []byte `json:"data"`
+}
+
+func newCometBackend(address endpointAddress) (*cometBackend, error) {
+	client, err := newHTTPClient(address)
+	if err != nil {
+		return nil, err
+	}
+	return &cometBackend{
+		baseURL: address.addr,
+		display: address.String(),
+		client:  client,
+		auth:    address.auth,
+	}, nil
+}
+
+// call makes a JSON-RPC call and decodes its result into out. label names
+// the call in metrics.
+func (b *cometBackend) call(ctx context.Context, label, method string, params map[string]any, out any) error {
+	start := time.Now()
+	err := b.do(ctx, method, params, out)
+	rpcDuration.WithLabelValues(b.display, label).Observe(time.Since(start).Seconds())
+	if err != nil {
+		rpcErrors.WithLabelValues(label, status.Code(err).String()).Inc()
+	}
+	return err
+}
+
+func (b *cometBackend) do(ctx context.Context, method string, params map[string]any, out any) error {
+	body, err := json.Marshal(rpcRequest{JSONRPC: "2.0", ID: "0", Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.baseURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	httpResp, respBody, err := sendHTTP(b.client, b.auth, req)
+	if err != nil {
+		return err
+	}
+	var resp rpcResponse
+	if err := json.Unmarshal(respBody, &resp); err != nil {
+		if httpResp.StatusCode != http.StatusOK {
+			return restError(httpResp.StatusCode, respBody)
+		}
+		return status.Errorf(codes.Internal, "failed to decode %s response: %v", method, err)
+	}
+	if resp.Error != nil {
+		return cometRPCError(resp.Error)
+	}
+	if err := json.Unmarshal(resp.Result, out); err != nil {
+		return status.Errorf(codes.Internal, "failed to decode %s result: %v", method, err)
+	}
+	return nil
+}
+
+// cometRPCError turns a JSON-RPC error into a gRPC status error.
+func cometRPCError(e *rpcError) error {
+	code := codes.Unknown
+	switch {
+	case e.Code == -32601:
+		code = codes.Unimplemented
+	case e.Code == -32602:
+		code = codes.InvalidArgument
+	case strings.Contains(e.Data, "height"):
+		// Heights in the future or pruned away
+		code = codes.InvalidArgument
+	}
+	return status.Error(code, e.Error())
+}
+
+// abciError turns a failed ABCI query into a gRPC status error, undoing the
+// mapping the SDK applies to gRPC errors from query handlers.
+func abciError(resp *abciQueryResponse) error {
+	code := codes.Unknown
+	if resp.Codespace == "sdk" {
+		switch resp.Code {
+		case 4:
+			code = codes.Unauthenticated
+		case 18:
+			code = codes.InvalidArgument
+		case 22:
+			code = codes.NotFound
+		}
+	}
+	return status.Error(code, resp.Log)
+}
+
+// abciQuery runs an ABCI query at height (0 for latest). With prove set the
+// node attaches a Merkle proof, which it only does for store queries.
+func (b *cometBackend) abciQuery(ctx context.Context, path string, data []byte, height int64, prove bool) (*abciQueryResponse, error) {
+	params := map[string]any{
+		"path":  path,
+		"data":  hex.EncodeToString(data),
+		"prove": prove,
+	}
+	if height > 0 {
+		params["height"] = strconv.FormatInt(height, 10)
+	}
+	var result struct {
+		Response abciQueryResponse `json:"response"`
+	}
+	if err := b.call(ctx, path, "abci_query", params, &result); err != nil {
+		return nil, err
+	}
+	if result.Response.Code != 0 {
+		return nil, abciError(&result.Response)
+	}
+	return &result.Response, nil
+}
+
+// validators returns the validator set CometBFT uses at height, in the
+// set's canonical order.
+func (b *cometBackend) validators(ctx context.Context, height int64) ([]*cmttypes.Validator, error) {
+	var vals []*cmttypes.Validator
+	for page := 1; ; page++ {
+		params := map[string]any{
+			"height":   strconv.FormatInt(height, 10),
+			"page":     strconv.Itoa(page),
+			"per_page": "100",
+		}
+		// Public keys are amino JSON, which only CometBFT's decoder reads
+		var raw json.RawMessage
+		if err := b.call(ctx, "validators", "validators", params, &raw); err != nil {
+			return nil, fmt.Errorf("failed to get validators: %w", err)
+		}
+		var result coretypes.ResultValidators
+		if err := cmtjson.Unmarshal(raw, &result); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to decode validators: %v", err)
+		}
+		vals = append(vals, result.Validators...)
+		if len(result.Validators) == 0 || len(vals) >= result.Total {
+			return vals, nil
+		}
+	}
+}
+
+// queryGRPC runs a gRPC query method through abci_query and returns the
+// height it was served at.
+func (b *cometBackend) queryGRPC(ctx context.Context, method string, height int64, req, out proto.Message) (int64, error) {
+	data, err := proto.Marshal(req)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := b.abciQuery(ctx, method, data, height, false)
+	if err != nil {
+		return 0, err
+	}
+	if err := proto.Unmarshal(resp.Value, out); err != nil {
+		return 0, status.Errorf(codes.Internal, "failed to decode %s response: %v", method, err)
+	}
+	return resp.Height, nil
+}
+
+func (b *cometBackend) Block(ctx context.Context, height int64) (blockInfo, error) {
+	// blockchain returns just the headers, unlike block which would also
+	// send the block data
+	params := map[string]any{}
+	if height > 0 {
+		params["minHeight"] = strconv.FormatInt(height, 10)
+		params["maxHeight"] = strconv.FormatInt(height, 10)
+	}
+	var result struct {
+		BlockMetas []struct {
+			Header blockHeader `json:"header"`
+		} `json:"block_metas"`
+	}
+	if err := b.call(ctx, "blockchain", "blockchain", params, &result); err != nil {
+		return blockInfo{}, fmt.Errorf("failed to get block: %w", err)
+	}
+	if len(result.BlockMetas) == 0 {
+		return blockInfo{}, status.Error(codes.NotFound, "no block returned")
+	}
+	// Newest first
+	header := result.BlockMetas[0].Header
+	return blockInfo{
+		ChainID:    header.ChainID,
+		Height:     header.Height,
+		AppVersion: header.Version.App,
+		Time:       header.Time,
+	}, nil
+}
+
+func (b *cometBackend) GetUpgrade(ctx context.Context, height int64) (*signaltypes.QueryGetUpgradeResponse, int64, error) {
+	var resp signaltypes.QueryGetUpgradeResponse
+	served, err := b.queryGRPC(ctx, signaltypes.Query_GetUpgrade_FullMethodName, height, &signaltypes.QueryGetUpgradeRequest{}, &resp)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &resp, served, nil
+}
+
+func (b *cometBackend) VersionTally(ctx context.Context, version uint64, height int64) (*signaltypes.QueryVersionTallyResponse, int64, error) {
+	var resp signaltypes.QueryVersionTallyResponse
+	served, err := b.queryGRPC(ctx, signaltypes.Query_VersionTally_FullMethodName, height, &signaltypes.QueryVersionTallyRequest{Version: version}, &resp)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &resp, served, nil
+}
+
+func (b *cometBackend) Validator(ctx context.Context, valoper string) (*stakingtypes.Validator, error) {
+	var resp stakingtypes.QueryValidatorResponse
+	if _, err := b.queryGRPC(ctx, stakingtypes.Query_Validator_FullMethodName, 0, &stakingtypes.QueryValidatorRequest{ValidatorAddr: valoper}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Validator, nil
+}
+
+func (b *cometBackend) Grants(ctx context.Context, granter, grantee, msgTypeURL string) ([]*authztypes.Grant, error) {
+	var resp authztypes.QueryGrantsResponse
+	req := &authztypes.QueryGrantsRequest{Granter: granter, Grantee: grantee, MsgTypeUrl: msgTypeURL}
+	if _, err := b.queryGRPC(ctx, authztypes.Query_Grants_FullMethodName, 0, req, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Grants, nil
+}
+
+// TxsEvent uses tx_search, which returns raw transactions; only their
+// bodies are decoded.
+func (b *cometBackend) TxsEvent(ctx context.Context, query string, limit uint64) (*txtypes.GetTxsEventResponse, error) {
+	params := map[string]any{
+		"query":    query,
+		"page":     "1",
+		"per_page": strconv.FormatUint(limit, 10),
+		"order_by": "desc",
+	}
+	var result struct {
+		Txs []struct {
+			Hash   string `json:"hash"`
+			Height int64  `json:"height,string"`
+			Tx     []byte `json:"tx"`
+		} `json:"txs"`
+	}
+	if err := b.call(ctx, "tx_search", "tx_search", params, &result); err != nil {
+		return nil, fmt.Errorf("failed to search txs: %w", err)
+	}
+	var resp txtypes.GetTxsEventResponse
+	for _, t := range result.Txs {
+		var raw txtypes.TxRaw
+		var body txtypes.TxBody
+		if err := proto.Unmarshal(t.Tx, &raw); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to decode tx %s: %v", t.Hash, err)
+		}
+		if err := proto.Unmarshal(raw.BodyBytes, &body); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to decode tx %s: %v", t.Hash, err)
+		}
+		resp.Txs = append(resp.Txs, &txtypes.Tx{Body: &body})
+		resp.TxResponses = append(resp.TxResponses, &abcitypes.TxResponse{Height: t.Height, Txhash: t.Hash})
+	}
+	return &resp, nil
+}
+
+// NodeInfo combines status, for the CometBFT node info, with abci_info for
+// the application version. The RPC has no git commit or SDK version.
+func (b *cometBackend) NodeInfo(ctx context.Context) (*cmtservice.GetNodeInfoResponse, error) {
+	var st struct {
+		NodeInfo struct {
+			ID      string `json:"id"`
+			Network string `json:"network"`
+			Version string `json:"version"`
+			Moniker string `json:"moniker"`
+		} `json:"node_info"`
+	}
+	if err := b.call(ctx, "status", "status", map[string]any{}, &st); err != nil {
+		return nil, fmt.Errorf("failed to get status: %w", err)
+	}
+	var info struct {
+		Response struct {
+			Data    string `json:"data"`
+			Version string `json:"version"`
+		} `json:"response"`
+	}
+	if err := b.call(ctx, "abci_info", "abci_info", map[string]any{}, &info); err != nil {
+		return nil, fmt.Errorf("failed to get ABCI info: %w", err)
+	}
+	return &cmtservice.GetNodeInfoResponse{
+		DefaultNodeInfo: &p2p.DefaultNodeInfo{
+			DefaultNodeId: st.NodeInfo.ID,
+			Network:       st.NodeInfo.Network,
+			Version:       st.NodeInfo.Version,
+			Moniker:       st.NodeInfo.Moniker,
+		},
+		ApplicationVersion: &cmtservice.VersionInfo{
+			Name:    info.Response.Data,
+			AppName: info.Response.Data,
+			Version: info.Response.Version,
+		},
+	}, nil
+}
+
+func (b *cometBackend) Syncing(ctx context.Context) (bool, error) {
+	var st struct {
+		SyncInfo struct {
+			CatchingUp bool `json:"catching_up"`
+		} `json:"sync_info"`
+	}
+	if err := b.call(ctx, "status", "status", map[string]any{}, &st); err != nil {
+		return false, fmt.Errorf("failed to get status: %w", err)
+	}
+	return st.SyncInfo.CatchingUp, nil
+}
+
+func (b *cometBackend) Close() error {
+	b.client.CloseIdleConnections()
+	return nil
+}
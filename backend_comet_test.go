@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	signaltypes "celestia-upgrade-monitor/celestia/signal/v1"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// cometStub is a CometBFT JSON-RPC server answering calls with handle.
+type cometStub struct {
+	handle func(method string, params map[string]any) (any, *rpcError)
+
+	mu    sync.Mutex
+	calls []rpcRequest
+}
+
+func (s *cometStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req rpcRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.calls = append(s.calls, req)
+	s.mu.Unlock()
+	result, rpcErr := s.handle(req.Method, req.Params)
+	resp := map[string]any{"jsonrpc": "2.0", "id": req.ID}
+	if rpcErr != nil {
+		resp["error"] = rpcErr
+	} else {
+		resp["result"] = result
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *cometStub) last() rpcRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[len(s.calls)-1]
+}
+
+// abciResult wraps an abci_query response the way the RPC returns it.
+func abciResult(resp abciQueryResponse) any {
+	return map[string]any{"response": resp}
+}
+
+func newTestCometBackend(t *testing.T, stub *cometStub) *cometBackend {
+	t.Helper()
+	srv := httptest.NewServer(stub)
+	t.Cleanup(srv.Close)
+	addr, err := parseEndpointAddress("comet+" + srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := newCometBackend(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestCometBackendQueries(t *testing.T) {
+	const latest = 100
+	stub := &cometStub{}
+	stub.handle = func(method string, params map[string]any) (any, *rpcError) {
+		switch method {
+		case "blockchain":
+			height := strconv.Itoa(latest)
+			if h, ok := params["maxHeight"].(string); ok {
+				height = h
+			}
+			header := json.RawMessage(`{"chain_id":"` + testChainID + `","height":"` + height + `","time":"2024-01-01T00:00:00Z","version":{"block":"11","app":"1"}}`)
+			return map[string]any{"block_metas": []any{map[string]any{"header": header}}}, nil
+		case "abci_query":
+		default:
+			return nil, &rpcError{Code: -32601, Message: "Method not found"}
+		}
+		height := int64(latest)
+		if h, ok := params["height"].(string); ok {
+			height, _ = strconv.ParseInt(h, 10, 64)
+		}
+		if height > latest {
+			return nil, &rpcError{Code: -32603, Message: "Internal error", Data: "height " + strconv.FormatInt(height, 10) + " must be less than or equal to the current blockchain height " + strconv.Itoa(latest)}
+		}
+		data, err := hex.DecodeString(params["data"].(string))
+		if err != nil {
+			return nil, &rpcError{Code: -32602, Message: "Invalid params", Data: err.Error()}
+		}
+		var value proto.Message
+		switch params["path"] {
+		case signaltypes.Query_GetUpgrade_FullMethodName:
+			value = &signaltypes.QueryGetUpgradeResponse{Upgrade: &signaltypes.Upgrade{AppVersion: 2, UpgradeHeight: 200}}
+		case signaltypes.Query_VersionTally_FullMethodName:
+			var req signaltypes.QueryVersionTallyRequest
+			if err := proto.Unmarshal(data, &req); err != nil {
+				return nil, &rpcError{Code: -32602, Message: "Invalid params", Data: err.Error()}
+			}
+			if req.Version != 2 {
+				return abciResult(abciQueryResponse{Code: 18, Codespace: "sdk", Log: "invalid version", Height: height}), nil
+			}
+			value = &signaltypes.QueryVersionTallyResponse{VotingPower: 50, ThresholdPower: 84, TotalVotingPower: 100}
+		default:
+			return abciResult(abciQueryResponse{Code: 6, Codespace: "sdk", Log: "unknown query path", Height: height}), nil
+		}
+		bz, _ := proto.Marshal(value)
+		return abciResult(abciQueryResponse{Value: bz, Height: height}), nil
+	}
+	b := newTestCometBackend(t, stub)
+	ctx := context.Background()
+
+	block, err := b.Block(ctx, 0)
+	if err != nil || block.Height != latest || block.ChainID != testChainID || block.AppVersion != 1 {
+		t.Errorf("got latest block %+v, %v", block, err)
+	}
+	if _, ok := stub.last().Params["maxHeight"]; ok {
+		t.Error("latest block request is pinned to a height")
+	}
+	if block, err := b.Block(ctx, 90); err != nil || block.Height != 90 || stub.last().Params["minHeight"] != "90" {
+		t.Errorf("got block %+v, %v with params %v, want height 90", block, err, stub.last().Params)
+	}
+
+	// The latest height sends no height, and the served one is read back
+	upgrade, served, err := b.GetUpgrade(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upgrade.GetUpgrade().GetAppVersion() != 2 || upgrade.GetUpgrade().GetUpgradeHeight() != 200 || served != latest {
+		t.Errorf("got upgrade %v served at %d", upgrade, served)
+	}
+	call := stub.last()
+	if _, ok := call.Params["height"]; ok || call.Params["path"] != signaltypes.Query_GetUpgrade_FullMethodName || call.Params["prove"] != false {
+		t.Errorf("got params %v for the latest upgrade", call.Params)
+	}
+	tally, served, err := b.VersionTally(ctx, 2, 90)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tally.GetVotingPower() != 50 || tally.GetTotalVotingPower() != 100 || served != 90 || stub.last().Params["height"] != "90" {
+		t.Errorf("got tally %v served at %d with params %v", tally, served, stub.last().Params)
+	}
+
+	// Errors map to the codes the gRPC transport would return
+	if _, _, err := b.VersionTally(ctx, 3, 0); status.Code(err) != codes.InvalidArgument || !isRequestError(err) {
+		t.Errorf("got %v for a failed ABCI query, want InvalidArgument", err)
+	}
+	if _, _, err := b.GetUpgrade(ctx, 1000); status.Code(err) != codes.InvalidArgument || !isRequestError(err) {
+		t.Errorf("got %v for a future height, want InvalidArgument", err)
+	}
+	if _, err := b.Validator(ctx, "celestiavaloper1val"); status.Code(err) != codes.Unknown || isRequestError(err) {
+		t.Errorf("got %v for an unknown ABCI error, want Unknown", err)
+	}
+	if _, err := b.Syncing(ctx); status.Code(err) != codes.Unimplemented {
+		t.Errorf("got %v for a missing method, want Unimplemented", err)
+	}
+}
+
+func TestCometBackendHTTPError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "<html>bad gateway</html>", http.StatusBadGateway)
+	}))
+	t.Cleanup(srv.Close)
+	b, err := newCometBackend(endpointAddress{transport: transportComet, addr: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := b.GetUpgrade(context.Background(), 0); status.Code(err) != codes.Unavailable {
+		t.Errorf("got %v for a proxy error, want Unavailable", err)
+	}
+}
+
+func TestCometErrors(t *testing.T) {
+	rpcTests := []struct {
+		err  rpcError
+		want codes.Code
+	}{
+		{rpcError{Code: -32601, Message: "Method not found"}, codes.Unimplemented},
+		{rpcError{Code: -32602, Message: "Invalid params", Data: "error converting json params"}, codes.InvalidArgument},
+		{rpcError{Code: -32603, Message: "Internal error", Data: "height 1 is not available, lowest height is 5000"}, codes.InvalidArgument},
+		{rpcError{Code: -32603, Message: "Internal error", Data: "node is shutting down"}, codes.Unknown},
+	}
+	for _, tt := range rpcTests {
+		if got := status.Code(cometRPCError(&tt.err)); got != tt.want {
+			t.Errorf("%+v: got %s, want %s", tt.err, got, tt.want)
+		}
+	}
+
+	abciTests := []struct {
+		codespace string
+		code      uint32
+		want      codes.Code
+	}{
+		{"sdk", 4, codes.Unauthenticated},
+		{"sdk", 18, codes.InvalidArgument},
+		{"sdk", 22, codes.NotFound},
+		{"sdk", 1, codes.Unknown},
+		// Codes outside the SDK's codespace mean something else
+		{"signal", 22, codes.Unknown},
+	}
+	for _, tt := range abciTests {
+		err := abciError(&abciQueryResponse{Codespace: tt.codespace, Code: tt.code, Log: "failed"})
+		if got := status.Code(err); got != tt.want {
+			t.Errorf("%s/%d: got %s, want %s", tt.codespace, tt.code, got, tt.want)
+		}
+	}
+}
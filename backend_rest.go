@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	signaltypes "celestia-upgrade-monitor/celestia/signal/v1"
+
+	authztypes "cosmossdk.io/api/cosmos/authz/v1beta1"
+	cmtservice "cosmossdk.io/api/cosmos/base/tendermint/v1beta1"
+	stakingtypes "cosmossdk.io/api/cosmos/staking/v1beta1"
+	txtypes "cosmossdk.io/api/cosmos/tx/v1beta1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	// Register the key types found in validator responses so their Any
+	// fields can be decoded
+	_ "cosmossdk.io/api/cosmos/crypto/ed25519"
+	_ "cosmossdk.io/api/cosmos/crypto/secp256k1"
+)
+
+// REST responses set the served height under the gRPC gateway prefix.
+const gatewayHeightHeader = "Grpc-Metadata-X-Cosmos-Block-Height"
+
+// restBackend queries a node's REST (LCD) API using the HTTP bindings of
+// the signal protos and the standard Cosmos SDK routes.
+type restBackend struct {
+	baseURL string
+	display string
+	client  *http.Client
+	auth    *authConfig
+}
+
+func newRESTBackend(address endpointAddress) (*restBackend, error) {
+	client, err := newHTTPClient(address)
+	if err != nil {
+		return nil, err
+	}
+	return &restBackend{
+		baseURL: address.addr,
+		display: address.String(),
+		client:  client,
+		auth:    address.auth,
+	}, nil
+}
+
+// newHTTPClient builds the HTTP client for an endpoint reached over HTTP,
+// applying its TLS settings.
+func newHTTPClient(address endpointAddress) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if address.tls != nil {
+		u, err := url.Parse(address.addr)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig, err := clientTLSConfig(*address.tls, u.Hostname())
+		if err != nil {
+			return nil, fmt.Errorf("invalid TLS settings for %s: %w", address, err)
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// sendHTTP adds the endpoint's auth headers to req, sends it and reads the
+// response body. Transport failures are returned as Unavailable.
+func sendHTTP(client *http.Client, auth *authConfig, req *http.Request) (*http.Response, []byte, error) {
+	if auth != nil {
+		md, err := auth.GetRequestMetadata(req.Context())
+		if err != nil {
+			return nil, nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		for name, value := range md {
+			req.Header.Set(name, value)
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// Strip the URL from the error as it may carry credentials
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return nil, nil, status.Error(codes.Unavailable, err.Error())
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return resp, body, nil
+}
+
+// get fetches path at height and decodes the JSON response into out. method
+// is the equivalent gRPC method, used to label metrics.
+func (b *restBackend) get(ctx context.Context, method, path string, height int64, out proto.Message) (int64, error) {
+	start := time.Now()
+	served, err := b.do(ctx, path, height, out)
+	rpcDuration.WithLabelValues(b.display, method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		rpcErrors.WithLabelValues(method, status.Code(err).String()).Inc()
+	}
+	return served, err
+}
+
+func (b *restBackend) do(ctx context.Context, path string, height int64, out proto.Message) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.baseURL+path, nil)
+	if err != nil {
+		return 0, err
+	}
+	if height > 0 {
+		req.Header.Set(blockHeightHeader, strconv.FormatInt(height, 10))
+	}
+
+	resp, body, err := sendHTTP(b.client, b.auth, req)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, restError(resp.StatusCode, body)
+	}
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(body, out); err != nil {
+		return 0, status.Errorf(codes.Internal, "failed to decode %s response: %v", path, err)
+	}
+
+	served, _ := strconv.ParseInt(resp.Header.Get(gatewayHeightHeader), 10, 64)
+	if served == 0 {
+		served, _ = strconv.ParseInt(resp.Header.Get(blockHeightHeader), 10, 64)
+	}
+	return served, nil
+}
+
+// restError turns a gateway error response into a gRPC status error so
+// callers can treat both transports alike.
+func restError(statusCode int, body []byte) error {
+	var gatewayErr struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &gatewayErr); err == nil && gatewayErr.Message != "" {
+		return status.Error(codes.Code(gatewayErr.Code), gatewayErr.Message)
+	}
+	code := codes.Unknown
+	switch statusCode {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		code = codes.Unimplemented
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	case http.StatusGatewayTimeout:
+		code = codes.DeadlineExceeded
+	case http.StatusInternalServerError:
+		code = codes.Internal
+	}
+	return status.Errorf(code, "HTTP %d", statusCode)
+}
+
+func (b *restBackend) Block(ctx context.Context, height int64) (blockInfo, error) {
+	path := "/cosmos/base/tendermint/v1beta1/blocks/latest"
+	method := "/cosmos.base.tendermint.v1beta1.Service/GetLatestBlock"
+	if height > 0 {
+		path = "/cosmos/base/tendermint/v1beta1/blocks/" + strconv.FormatInt(height, 10)
+		method = "/cosmos.base.tendermint.v1beta1.Service/GetBlockByHeight"
+	}
+	// Both routes return the same shape
+	var resp cmtservice.GetLatestBlockResponse
+	if _, err := b.get(ctx, method, path, 0, &resp); err != nil {
+		return blockInfo{}, fmt.Errorf("failed to get block: %w", err)
+	}
+	if resp.SdkBlock != nil {
+		return headerToBlockInfo(resp.SdkBlock.GetHeader()), nil
+	}
+	return headerToBlockInfo(resp.Block.GetHeader()), nil
+}
+
+func (b *restBackend) GetUpgrade(ctx context.Context, height int64) (*signaltypes.QueryGetUpgradeResponse, int64, error) {
+	var resp signaltypes.QueryGetUpgradeResponse
+	served, err := b.get(ctx, "/celestia.signal.v1.Query/GetUpgrade", "/signal/v1/upgrade", height, &resp)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &resp, served, nil
+}
+
+func (b *restBackend) VersionTally(ctx context.Context, version uint64, height int64) (*signaltypes.QueryVersionTallyResponse, int64, error) {
+	var resp signaltypes.QueryVersionTallyResponse
+	path := "/signal/v1/tally/" + strconv.FormatUint(version, 10)
+	served, err := b.get(ctx, "/celestia.signal.v1.Query/VersionTally", path, height, &resp)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &resp, served, nil
+}
+
+func (b *restBackend) Validator(ctx context.Context, valoper string) (*stakingtypes.Validator, error) {
+	var resp stakingtypes.QueryValidatorResponse
+	path := "/cosmos/staking/v1beta1/validators/" + url.PathEscape(valoper)
+	if _, err := b.get(ctx, "/cosmos.staking.v1beta1.Query/Validator", path, 0, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Validator, nil
+}
+
+func (b *restBackend) Grants(ctx context.Context, granter, grantee, msgTypeURL string) ([]*authztypes.Grant, error) {
+	var resp authztypes.QueryGrantsResponse
+	query := url.Values{"granter": {granter}, "grantee": {grantee}, "msg_type_url": {msgTypeURL}}
+	if _, err := b.get(ctx, authztypes.Query_Grants_FullMethodName, "/cosmos/authz/v1beta1/grants?"+query.Encode(), 0, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Grants, nil
+}
+
+func (b *restBackend) TxsEvent(ctx context.Context, query string, limit uint64) (*txtypes.GetTxsEventResponse, error) {
+	var resp txtypes.GetTxsEventResponse
+	params := url.Values{
+		"query":    {query},
+		"order_by": {txtypes.OrderBy_ORDER_BY_DESC.String()},
+		"page":     {"1"},
+		"limit":    {strconv.FormatUint(limit, 10)},
+	}
+	if _, err := b.get(ctx, txtypes.Service_GetTxsEvent_FullMethodName, "/cosmos/tx/v1beta1/txs?"+params.Encode(), 0, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (b *restBackend) NodeInfo(ctx context.Context) (*cmtservice.GetNodeInfoResponse, error) {
+	var resp cmtservice.GetNodeInfoResponse
+	if _, err := b.get(ctx, cmtservice.Service_GetNodeInfo_FullMethodName, "/cosmos/base/tendermint/v1beta1/node_info", 0, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (b *restBackend) Syncing(ctx context.Context) (bool, error) {
+	var resp cmtservice.GetSyncingResponse
+	if _, err := b.get(ctx, cmtservice.Service_GetSyncing_FullMethodName, "/cosmos/base/tendermint/v1beta1/syncing", 0, &resp); err != nil {
+		return false, err
+	}
+	return resp.Syncing, nil
+}
+
+func (b *restBackend) Close() error {
+	b.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+)
+
+// backfiller walks an archive node with height-pinned queries, sampling
+// more densely wherever the signalling state changes.
+type backfiller struct {
+	backend    backend
+	minGap     int64
+	tolerance  float64
+	maxQueries int
+	queries    int
+	samples    []historySample
+}
+
+// sample queries the signalling state at height.
+func (b *backfiller) sample(height int64) (historySample, error) {
+	if b.queries >= b.maxQueries {
+		return historySample{}, fmt.Errorf("query budget of %d exhausted", b.maxQueries)
+	}
+	b.queries++
+	data, err := getUpgrade(b.backend, height)
+	if err != nil {
+		return historySample{}, fmt.Errorf("failed to query height %d (is the node an archive node?): %w", height, err)
+	}
+	s := sampleFromUpgrade(data)
+	b.samples = append(b.samples, s)
+	if b.queries%100 == 0 {
+		log.Printf("Backfill progress: %d queries, now at height %d", b.queries, height)
+	}
+	return s, nil
+}
+
+// refine binary searches between two samples until every change point is
+// located to within minGap blocks. A single change costs at most
+// ceil(log2((hi-lo)/minGap)) queries. Only changes visible at the ends of
+// an interval are found: power that moves and moves back between two
+// samples goes unseen.
+func (b *backfiller) refine(lo, hi historySample) error {
+	if hi.Height-lo.Height <= b.minGap || !changed(lo, hi, b.tolerance) {
+		return nil
+	}
+	mid, err := b.sample(lo.Height + (hi.Height-lo.Height)/2)
+	if err != nil {
+		return err
+	}
+	if err := b.refine(lo, mid); err != nil {
+		return err
+	}
+	return b.refine(mid, hi)
+}
+
+// run samples [from, to] on a coarse grid of step blocks, each height once,
+// and refines every interval in which the state changed.
+func (b *backfiller) run(from, to, step int64) error {
+	prev, err := b.sample(from)
+	if err != nil {
+		return err
+	}
+	for height := from; height < to; {
+		height = min(height+step, to)
+		next, err := b.sample(height)
+		if err != nil {
+			return err
+		}
+		if err := b.refine(prev, next); err != nil {
+			return err
+		}
+		prev = next
+	}
+	return nil
+}
+
+// runBackfill implements the backfill subcommand.
+func runBackfill(args []string) {
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	addr := fs.String("grpc-addr", "", "Address of an archive node (e.g., https://host:443 for gRPC or rest+https://host for REST)")
+	from := fs.Int64("from", 1, "First height to sample")
+	to := fs.Int64("to", 0, "Last height to sample (0 = latest)")
+	step := fs.Int64("step", 10000, "Coarse sampling interval in blocks before refining around changes")
+	minGap := fs.Int64("min-gap", 1, "Stop refining once a change is located to within this many blocks")
+	tolerance := fs.Float64("tolerance", 0.001, "Ignore signalled power moves smaller than this fraction of total power")
+	maxQueries := fs.Int("max-queries", 5000, "Maximum number of height-pinned queries to make")
+	historyFile := fs.String("history-file", "history.jsonl", "History file to add the samples to")
+	targetVersion := fs.Uint64("target-version", 0, "App version to tally signals for (0 = app version at each height + 1)")
+	fs.Parse(args)
+
+	if *addr == "" {
+		log.Fatal("backfill: -grpc-addr must be provided")
+	}
+	if *step < 1 || *minGap < 1 {
+		log.Fatal("backfill: -step and -min-gap must be at least 1")
+	}
+	parsedAddr, err := parseEndpointAddress(*addr)
+	if err != nil {
+		log.Fatalf("Invalid address: %v", err)
+	}
+	TargetVersion = *targetVersion
+
+	store, err := openHistory(*historyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	archive, err := newBackend(parsedAddr)
+	if err != nil {
+		log.Fatalf("Failed to connect to %s: %v", parsedAddr, err)
+	}
+	defer archive.Close()
+
+	b := &backfiller{
+		backend:    archive,
+		minGap:     *minGap,
+		tolerance:  *tolerance,
+		maxQueries: *maxQueries,
+	}
+	if *to == 0 {
+		latest, err := getUpgrade(archive, 0)
+		if err != nil {
+			log.Fatalf("Failed to get latest height: %v", err)
+		}
+		*to = latest.Height
+	}
+	if *from > *to {
+		log.Fatalf("backfill: -from %d is after -to %d", *from, *to)
+	}
+
+	log.Printf("Backfilling heights %d to %d", *from, *to)
+	runErr := b.run(*from, *to, *step)
+	// Keep whatever was sampled, even if the walk stopped early
+	if err := store.add(b.samples...); err != nil {
+		log.Fatal(err)
+	}
+	if runErr != nil {
+		log.Fatalf("Backfill stopped after %d samples: %v", len(b.samples), runErr)
+	}
+	log.Printf("Backfill complete: %d samples written to %s", len(b.samples), *historyFile)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	signaltypes "celestia-upgrade-monitor/celestia/signal/v1"
+)
+
+// archiveStub serves a signalling history in which power for version 2
+// steps up at the given heights, 10 of 100 at a time.
+type archiveStub struct {
+	backend
+	steps []int64
+}
+
+func (a archiveStub) Block(_ context.Context, height int64) (blockInfo, error) {
+	return blockInfo{Height: height, AppVersion: 1}, nil
+}
+
+func (a archiveStub) GetUpgrade(_ context.Context, height int64) (*signaltypes.QueryGetUpgradeResponse, int64, error) {
+	return &signaltypes.QueryGetUpgradeResponse{}, height, nil
+}
+
+func (a archiveStub) VersionTally(_ context.Context, _ uint64, height int64) (*signaltypes.QueryVersionTallyResponse, int64, error) {
+	resp := &signaltypes.QueryVersionTallyResponse{TotalVotingPower: 100, ThresholdPower: 84}
+	for _, step := range a.steps {
+		if height >= step {
+			resp.VotingPower += 10
+		}
+	}
+	return resp, height, nil
+}
+
+func TestBackfill(t *testing.T) {
+	b := &backfiller{backend: archiveStub{steps: []int64{1234, 5678}}, minGap: 1, maxQueries: 1000}
+	if err := b.run(1, 10001, 1000); err != nil {
+		t.Fatal(err)
+	}
+	seen := map[int64]bool{}
+	for _, s := range b.samples {
+		if seen[s.Height] {
+			t.Errorf("height %d sampled twice", s.Height)
+		}
+		seen[s.Height] = true
+	}
+	for _, h := range []int64{1233, 1234, 5677, 5678, 10001} {
+		if !seen[h] {
+			t.Errorf("height %d wasn't sampled", h)
+		}
+	}
+	// 11 grid samples, and at most ceil(log2(1000)) = 10 per change
+	if b.queries > 11+2*10 {
+		t.Errorf("took %d queries, want at most 31", b.queries)
+	}
+
+	b = &backfiller{backend: archiveStub{}, minGap: 1, maxQueries: 10}
+	if err := b.run(500, 500, 1000); err != nil || len(b.samples) != 1 {
+		t.Errorf("a single height took %d samples (%v), want 1", len(b.samples), err)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// backgroundCheck runs a check against the latest poll result in its own
+// goroutine, at most once per interval, so a slow check never holds up
+// polling. Results handed over while the check runs are skipped.
+type backgroundCheck struct {
+	name     string
+	interval time.Duration
+	run      func(data UpgradeData)
+
+	mu      sync.Mutex
+	running bool
+	lastRun time.Time
+	// runs tracks the running check
+	runs sync.WaitGroup
+}
+
+// backgroundChecks are handed every successful poll result.
+var backgroundChecks []*backgroundCheck
+
+func addBackgroundCheck(name string, interval time.Duration, run func(data UpgradeData)) {
+	backgroundChecks = append(backgroundChecks, &backgroundCheck{name: name, interval: interval, run: run})
+}
+
+// offer starts the check on data unless it is already running or ran less
+// than interval ago. It reports whether the check was started.
+func (c *backgroundCheck) offer(data UpgradeData) bool {
+	c.mu.Lock()
+	start := !c.running && time.Since(c.lastRun) >= c.interval
+	if start {
+		c.running = true
+		c.lastRun = time.Now()
+		c.runs.Add(1)
+	}
+	c.mu.Unlock()
+	if !start {
+		return false
+	}
+	go func() {
+		defer c.runs.Done()
+		started := time.Now()
+		c.run(data)
+		if took := time.Since(started); c.interval > 0 && took > c.interval {
+			log.Printf("The %s check took %s, longer than its %s interval", c.name, took.Round(time.Second), c.interval)
+		}
+		c.mu.Lock()
+		c.running = false
+		c.mu.Unlock()
+	}()
+	return true
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackgroundCheck(t *testing.T) {
+	release := make(chan struct{})
+	var checked []int64
+	c := &backgroundCheck{name: "test", run: func(data UpgradeData) {
+		<-release
+		checked = append(checked, data.Height)
+	}}
+
+	// A slow check doesn't block the poll handing results over, and results
+	// handed over while it runs are skipped
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if !c.offer(UpgradeData{Height: 100}) {
+			t.Error("first result wasn't checked")
+		}
+		if c.offer(UpgradeData{Height: 101}) {
+			t.Error("result checked while the check was running")
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("offer blocked on the running check")
+	}
+	close(release)
+	c.runs.Wait()
+	if !c.offer(UpgradeData{Height: 102}) {
+		t.Error("result not checked after the check finished")
+	}
+	c.runs.Wait()
+
+	c.interval = time.Hour
+	if c.offer(UpgradeData{Height: 103}) {
+		t.Error("result checked before the interval passed")
+	}
+	if len(checked) != 2 || checked[0] != 100 || checked[1] != 102 {
+		t.Errorf("checked heights %v, want 100 and 102", checked)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// blockHeader is the subset of a CometBFT block header the monitor uses.
+type blockHeader struct {
+	ChainID string    `json:"chain_id"`
+	Height  int64     `json:"height,string"`
+	Time    time.Time `json:"time"`
+	Version struct {
+		Block uint64 `json:"block,string"`
+		App   uint64 `json:"app,string"`
+	} `json:"version"`
+}
+
+// blockWatcher follows NewBlock events to track the chain height in real
+// time, spot the upgrade height and app version switch, and detect halts.
+type blockWatcher struct {
+	mu         sync.Mutex
+	connected  bool
+	height     int64
+	appVersion uint64
+	lastBlock  time.Time
+	// connectedAt is when the websocket last connected
+	connectedAt    time.Time
+	avgBlockTime   time.Duration
+	upgradeHeight  int64
+	upgradeReached bool
+	halted         bool
+
+	// haltBlocks is how many average block times may pass without a new
+	// block before the chain is considered halted.
+	haltBlocks float64
+}
+
+// Set when -rpc-addr is provided, nil otherwise
+var watcher *blockWatcher
+
+func newBlockWatcher(blockTime time.Duration, haltBlocks float64) *blockWatcher {
+	return &blockWatcher{
+		avgBlockTime: blockTime,
+		haltBlocks:   haltBlocks,
+	}
+}
+
+// watch subscribes to NewBlock events on sub and checks for halts until ctx
+// is cancelled.
+func (w *blockWatcher) watch(ctx context.Context, sub *cometSubscriber) {
+	sub.subscribe("newblock", "tm.event='NewBlock'", func(result rpcEventResult) {
+		var value struct {
+			Block struct {
+				Header blockHeader `json:"header"`
+			} `json:"block"`
+		}
+		if err := json.Unmarshal(result.Data.Value, &value); err != nil {
+			log.Printf("Failed to decode NewBlock event: %v", err)
+			return
+		}
+		w.onNewBlock(value.Block.Header, time.Now())
+	})
+	prev := sub.onConnect
+	sub.onConnect = func(connected bool) {
+		w.setConnected(connected, time.Now())
+		if prev != nil {
+			prev(connected)
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				w.checkHalt(now)
+			}
+		}
+	}()
+}
+
+// setConnected records the websocket connecting or dropping. Blocks missed
+// while it was down don't count towards a halt: the halt timer restarts
+// when it reconnects.
+func (w *blockWatcher) setConnected(connected bool, now time.Time) {
+	w.mu.Lock()
+	w.connected = connected
+	if connected {
+		w.connectedAt = now
+	}
+	w.mu.Unlock()
+	if connected {
+		websocketConnected.Set(1)
+	} else {
+		websocketConnected.Set(0)
+	}
+}
+
+func (w *blockWatcher) onNewBlock(header blockHeader, now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if header.Height <= w.height {
+		return
+	}
+	if !w.lastBlock.IsZero() && w.lastBlock.After(w.connectedAt) && header.Height == w.height+1 {
+		// Exponential moving average of the observed block time, leaving
+		// out gaps that span a reconnect
+		interval := now.Sub(w.lastBlock)
+		w.avgBlockTime = (w.avgBlockTime*9 + interval) / 10
+	}
+	if w.halted {
+		emitEvent(Event{
+			Kind:    "chain_resumed",
+			Message: fmt.Sprintf("chain resumed at height %d", header.Height),
+			Height:  header.Height,
+		})
+		w.halted = false
+		chainHalted.Set(0)
+	}
+	if w.appVersion != 0 && header.Version.App != w.appVersion {
+		emitEvent(Event{
+			Kind:    "app_version_switched",
+			Message: fmt.Sprintf("app version switched from %d to %d", w.appVersion, header.Version.App),
+			Height:  header.Height,
+		})
+		requestPoll()
+	}
+	w.height = header.Height
+	w.appVersion = header.Version.App
+	w.lastBlock = now
+	chainHeight.Set(float64(header.Height))
+	chainAppVersion.Set(float64(header.Version.App))
+
+	if w.upgradeHeight > 0 && header.Height >= w.upgradeHeight && !w.upgradeReached {
+		emitEvent(Event{
+			Kind:    "upgrade_height_reached",
+			Message: fmt.Sprintf("upgrade height %d reached", w.upgradeHeight),
+			Height:  header.Height,
+		})
+		w.upgradeReached = true
+		requestPoll()
+	}
+}
+
+func (w *blockWatcher) checkHalt(now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.connected || w.halted || w.lastBlock.IsZero() {
+		return
+	}
+	limit := time.Duration(w.haltBlocks * float64(w.avgBlockTime))
+	from := w.lastBlock
+	if w.connectedAt.After(from) {
+		from = w.connectedAt
+	}
+	if since := now.Sub(from); since > limit {
+		emitEvent(Event{
+			Kind:     "chain_halted",
+			Severity: severityCritical,
+			Message:  fmt.Sprintf("no block since height %d for %s (limit %s)", w.height, since.Round(time.Second), limit),
+			Height:   w.height,
+		})
+		w.halted = true
+		chainHalted.Set(1)
+	}
+}
+
+// setUpgradeHeight records the scheduled upgrade height from the latest poll.
+func (w *blockWatcher) setUpgradeHeight(height int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if height != w.upgradeHeight {
+		w.upgradeHeight = height
+		w.upgradeReached = height > 0 && w.height >= height
+	}
+}
+
+// latestHeight returns the last height seen on the websocket, and whether
+// the websocket is currently connected.
+func (w *blockWatcher) latestHeight() (int64, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.height, w.connected
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestBlockWatcherHalt(t *testing.T) {
+	w := newBlockWatcher(6*time.Second, 3)
+	w.connected = true
+	start := time.Now()
+	// Blocks every 2s pull the average down from 6s
+	for i := int64(1); i <= 60; i++ {
+		w.onNewBlock(blockHeader{Height: i}, start.Add(time.Duration(i)*2*time.Second))
+	}
+	last := start.Add(120 * time.Second)
+	if w.avgBlockTime > 2100*time.Millisecond {
+		t.Fatalf("average block time is %s, want close to 2s", w.avgBlockTime)
+	}
+	limit := time.Duration(3 * float64(w.avgBlockTime))
+
+	w.checkHalt(last.Add(limit - time.Millisecond))
+	if w.halted || testutil.ToFloat64(chainHalted) != 0 {
+		t.Fatal("halted before the limit")
+	}
+	w.checkHalt(last.Add(limit + time.Millisecond))
+	if !w.halted || testutil.ToFloat64(chainHalted) != 1 {
+		t.Fatal("not halted after the limit")
+	}
+	w.onNewBlock(blockHeader{Height: 61}, last.Add(time.Minute))
+	if w.halted || testutil.ToFloat64(chainHalted) != 0 {
+		t.Error("still halted after a new block")
+	}
+}
+
+// blockServer is a CometBFT websocket that refuses the first dials and then
+// publishes NewBlock events on request.
+type blockServer struct {
+	refuse int
+
+	mu       sync.Mutex
+	attempts []time.Time
+	conn     *websocket.Conn
+}
+
+func (s *blockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.attempts = append(s.attempts, time.Now())
+	n := len(s.attempts)
+	s.mu.Unlock()
+	if n <= s.refuse {
+		http.Error(w, "not yet", http.StatusServiceUnavailable)
+		return
+	}
+	upgrader := websocket.Upgrader{}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	for {
+		var req rpcRequest
+		if err := conn.ReadJSON(&req); err != nil {
+			return
+		}
+		s.mu.Lock()
+		conn.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": req.ID, "result": map[string]any{}})
+		s.conn = conn
+		s.mu.Unlock()
+	}
+}
+
+// block publishes a NewBlock event once a client has subscribed.
+func (s *blockServer) block(height int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return
+	}
+	header := fmt.Sprintf(`{"height":"%d","version":{"block":"11","app":"1"}}`, height)
+	s.conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","id":"newblock#event","result":{"query":"tm.event='NewBlock'","data":{"type":"tendermint/event/NewBlock","value":{"block":{"header":`+header+`}}}}}`))
+}
+
+func TestBlockWatcherReconnect(t *testing.T) {
+	savedInitial, savedMax := wsInitialBackoff, wsMaxBackoff
+	wsInitialBackoff, wsMaxBackoff = 20*time.Millisecond, 200*time.Millisecond
+	t.Cleanup(func() { wsInitialBackoff, wsMaxBackoff = savedInitial, savedMax })
+
+	node := &blockServer{refuse: 4}
+	srv := httptest.NewServer(node)
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	sub, err := newCometSubscriber(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newBlockWatcher(50*time.Millisecond, 3)
+	w.watch(ctx, sub)
+	go sub.run(ctx)
+
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if cond() {
+				return
+			}
+		}
+		t.Fatalf("timed out waiting for %s", what)
+	}
+	waitFor("the websocket to connect", func() bool {
+		_, connected := w.latestHeight()
+		return connected
+	})
+	node.mu.Lock()
+	for i, want := range []time.Duration{20, 40, 80, 160} {
+		if gap := node.attempts[i+1].Sub(node.attempts[i]); gap < want*time.Millisecond {
+			t.Errorf("retry %d came after %s, want at least %dms", i+1, gap, want)
+		}
+	}
+	node.mu.Unlock()
+	if testutil.ToFloat64(websocketConnected) != 1 {
+		t.Error("celestia_websocket_connected is not 1")
+	}
+
+	waitFor("a block", func() bool {
+		node.block(101)
+		height, _ := w.latestHeight()
+		return height == 101
+	})
+	waitFor("the halt", func() bool { return testutil.ToFloat64(chainHalted) == 1 })
+	node.block(102)
+	waitFor("the chain to resume", func() bool { return testutil.ToFloat64(chainHalted) == 0 })
+}
+
+func TestBlockWatcherOutage(t *testing.T) {
+	w := newBlockWatcher(2*time.Second, 3)
+	start := time.Now()
+	w.setConnected(true, start)
+	for i := int64(1); i <= 10; i++ {
+		w.onNewBlock(blockHeader{Height: i}, start.Add(time.Duration(i)*2*time.Second))
+	}
+	limit := time.Duration(3 * float64(w.avgBlockTime))
+
+	// The websocket is down for five minutes while the chain moves on
+	w.setConnected(false, start.Add(21*time.Second))
+	w.checkHalt(start.Add(5 * time.Minute))
+	reconnected := start.Add(5 * time.Minute)
+	w.setConnected(true, reconnected)
+	w.checkHalt(reconnected.Add(time.Second))
+	if w.halted || testutil.ToFloat64(chainHalted) != 0 {
+		t.Fatal("halted right after reconnecting")
+	}
+	w.onNewBlock(blockHeader{Height: 160}, reconnected.Add(2*time.Second))
+	w.onNewBlock(blockHeader{Height: 161}, reconnected.Add(4*time.Second))
+	if w.avgBlockTime > 2100*time.Millisecond {
+		t.Errorf("average block time is %s after the outage, want close to 2s", w.avgBlockTime)
+	}
+
+	// A chain that is down when the websocket reconnects is still caught
+	w.setConnected(false, reconnected.Add(5*time.Second))
+	w.setConnected(true, reconnected.Add(10*time.Second))
+	w.checkHalt(reconnected.Add(10*time.Second + limit + time.Millisecond))
+	if !w.halted || testutil.ToFloat64(chainHalted) != 1 {
+		t.Fatal("not halted with no block after reconnecting")
+	}
+	w.onNewBlock(blockHeader{Height: 170}, reconnected.Add(time.Minute))
+}
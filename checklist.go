@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Check outcomes
+const (
+	checkPass = "pass"
+	checkWarn = "warn"
+	checkFail = "fail"
+	checkSkip = "skip"
+)
+
+// checkResult is the outcome of one check.
+type checkResult struct {
+	Check  string `json:"check"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Pre-upgrade checklist items
+const (
+	checkDiskSpace    = "disk_space"
+	checkStagedBinary = "staged_binary"
+	checkNodeSynced   = "node_synced"
+	checkHaltHeight   = "halt_height"
+	checkNodeVersion  = "node_version"
+	checkSignalled    = "signalled"
+	checkAlerting     = "alerting"
+)
+
+var checklistItems = []string{checkDiskSpace, checkStagedBinary, checkNodeSynced, checkHaltHeight, checkNodeVersion, checkSignalled, checkAlerting}
+
+// networkChecks are the checklist items that call out to a node or the
+// webhooks. They run in the background and their results are reused until
+// the next run.
+var networkChecks = map[string]bool{checkNodeSynced: true, checkNodeVersion: true, checkAlerting: true}
+
+// parseChecklistItems parses a comma-separated list of checklist items.
+func parseChecklistItems(s string) ([]string, error) {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		known := false
+		for _, k := range checklistItems {
+			known = known || k == item
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown check %q, expected one of %s", item, strings.Join(checklistItems, ", "))
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// checklistReport is the /readiness response.
+type checklistReport struct {
+	Height        int64          `json:"height"`
+	AppVersion    uint64         `json:"target_app_version"`
+	UpgradeHeight int64          `json:"upgrade_height,omitempty"`
+	Status        string         `json:"status"`
+	Summary       map[string]int `json:"summary"`
+	Checks        []checkResult  `json:"checks"`
+}
+
+// checklist runs the pre-upgrade checklist each poll.
+type checklist struct {
+	items []string
+	// node is the node checked for sync and version, nil for the monitored
+	// endpoints
+	node        *endpoint
+	minReleases map[uint64]string
+	dataDir     string
+	// minFree is the free space below which disk_space fails; it warns
+	// below twice that
+	minFree uint64
+	appToml string
+	// networkInterval is how often the network checks run
+	networkInterval time.Duration
+
+	mu     sync.Mutex
+	report checklistReport
+	data   UpgradeData
+	// network holds the latest network check results, from networkAt
+	network    map[string]checkResult
+	networkAt  time.Time
+	refreshing bool
+	// refreshes tracks running network checks
+	refreshes sync.WaitGroup
+	// publishMu keeps reports from being published out of order
+	publishMu sync.Mutex
+}
+
+// readinessChecklist is set when -readiness-checks lists any checks, nil
+// otherwise.
+var readinessChecklist *checklist
+
+// haltHeightPattern matches the halt-height setting in app.toml.
+var haltHeightPattern = regexp.MustCompile(`(?m)^\s*halt-height\s*=\s*"?(\d+)"?`)
+
+// readHaltHeight returns the halt-height set in an app.toml, 0 if none.
+func readHaltHeight(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	m := haltHeightPattern.FindSubmatch(data)
+	if m == nil {
+		return 0, nil
+	}
+	return strconv.ParseInt(string(m[1]), 10, 64)
+}
+
+// withNode runs fn against the configured node, or the monitored endpoints
+// when there is none.
+func (c *checklist) withNode(fn func(ctx context.Context, b backend) error) error {
+	run := func(b backend) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return fn(ctx, b)
+	}
+	if c.node == nil {
+		return queryEndpoints(func(b backend) (string, error) { return "", run(b) })
+	}
+	b, err := c.node.client()
+	if err != nil {
+		return err
+	}
+	return run(b)
+}
+
+// evaluate runs one check against a poll result.
+func (c *checklist) evaluate(item string, data UpgradeData) (string, string) {
+	upgrade := data.UpgradeData.Upgrade
+	target := targetAppVersion(data)
+	switch item {
+	case checkDiskSpace:
+		if c.dataDir == "" {
+			return checkSkip, "no data directory configured"
+		}
+		free, err := diskFree(c.dataDir)
+		if err != nil {
+			return checkFail, err.Error()
+		}
+		detail := fmt.Sprintf("%.1f GiB free on %s", float64(free)/(1<<30), c.dataDir)
+		switch {
+		case free < c.minFree:
+			return checkFail, detail
+		case free < 2*c.minFree:
+			return checkWarn, detail
+		}
+		return checkPass, detail
+
+	case checkStagedBinary:
+		if cosmovisor == nil {
+			return checkSkip, "no -cosmovisor-home configured"
+		}
+		if upgrade.UpgradeHeight == 0 {
+			return checkSkip, "no upgrade scheduled"
+		}
+		report := cosmovisor.snapshot()
+		var failed []string
+		for _, r := range report.Checks {
+			if r.Check != checkCurrentLink && r.Status == checkFail {
+				failed = append(failed, r.Check+": "+r.Detail)
+			}
+		}
+		if len(failed) > 0 {
+			return checkFail, strings.Join(failed, "; ")
+		}
+		return checkPass, fmt.Sprintf("%s %s", report.Binary, report.Version)
+
+	case checkNodeSynced:
+		var syncing bool
+		err := c.withNode(func(ctx context.Context, b backend) error {
+			var err error
+			syncing, err = b.Syncing(ctx)
+			return err
+		})
+		switch {
+		case err != nil:
+			return checkFail, err.Error()
+		case syncing:
+			return checkFail, "catching up"
+		}
+		return checkPass, ""
+
+	case checkHaltHeight:
+		if c.appToml == "" {
+			return checkSkip, "no app.toml configured"
+		}
+		halt, err := readHaltHeight(c.appToml)
+		switch {
+		case err != nil:
+			return checkFail, err.Error()
+		case halt == 0:
+			return checkPass, "no halt-height set"
+		case upgrade.UpgradeHeight == 0:
+			return checkWarn, fmt.Sprintf("halt-height %d is set", halt)
+		case halt < upgrade.UpgradeHeight:
+			return checkFail, fmt.Sprintf("halt-height %d stops the node before the upgrade height %d", halt, upgrade.UpgradeHeight)
+		case halt == upgrade.UpgradeHeight:
+			return checkWarn, fmt.Sprintf("halt-height %d is the upgrade height", halt)
+		}
+		return checkPass, fmt.Sprintf("halt-height %d is after the upgrade height %d", halt, upgrade.UpgradeHeight)
+
+	case checkNodeVersion:
+		if data.AppVersion >= target {
+			return checkSkip, fmt.Sprintf("already on app version %d", data.AppVersion)
+		}
+		var version string
+		err := c.withNode(func(ctx context.Context, b backend) error {
+			info, err := b.NodeInfo(ctx)
+			version = info.GetApplicationVersion().GetVersion()
+			return err
+		})
+		if err != nil {
+			return checkFail, err.Error()
+		}
+		if ok, _, reason := checkRelease(version, target, c.minReleases); !ok {
+			return checkFail, reason
+		}
+		return checkPass, "runs " + version
+
+	case checkSignalled:
+		if selfCheck == nil {
+			return checkSkip, "no -own-validators configured"
+		}
+		if upgrade.UpgradeHeight > 0 {
+			return checkPass, "upgrade already scheduled"
+		}
+		status := checkPass
+		var missing []string
+		for _, v := range selfCheck.snapshot().Validators {
+			if v.Signalled {
+				continue
+			}
+			missing = append(missing, v.Validator)
+			if status == checkPass {
+				status = checkWarn
+			}
+			if v.Severity == severityCritical {
+				status = checkFail
+			}
+		}
+		if len(missing) > 0 {
+			return status, fmt.Sprintf("not signalled version %d: %s", target, strings.Join(missing, ", "))
+		}
+		return checkPass, ""
+
+	case checkAlerting:
+		events.mu.Lock()
+		webhooks := events.webhooks
+		events.mu.Unlock()
+		if len(webhooks) == 0 {
+			return checkSkip, "no -webhook-url configured"
+		}
+		var unreachable []string
+		for _, hook := range webhooks {
+			if err := dialWebhook(hook); err != nil {
+				unreachable = append(unreachable, err.Error())
+			}
+		}
+		switch {
+		case len(unreachable) == len(webhooks):
+			return checkFail, strings.Join(unreachable, "; ")
+		case len(unreachable) > 0:
+			return checkWarn, strings.Join(unreachable, "; ")
+		}
+		return checkPass, fmt.Sprintf("%d webhooks reachable", len(webhooks))
+	}
+	return checkSkip, ""
+}
+
+// dialWebhook checks that a webhook's host accepts connections, without
+// posting anything to it.
+func dialWebhook(hook string) error {
+	u, err := url.Parse(hook)
+	if err != nil {
+		return fmt.Errorf("invalid webhook URL: %w", err)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(u.Hostname(), port), 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("%s: %w", u.Host, err)
+	}
+	return conn.Close()
+}
+
+// update runs the checklist against a poll result and exports the results.
+// The network checks are started in the background when their results are
+// older than networkInterval; until then the last results are reported.
+func (c *checklist) update(data UpgradeData) {
+	c.mu.Lock()
+	c.data = data
+	refresh := !c.refreshing && time.Since(c.networkAt) >= c.networkInterval
+	if refresh {
+		c.refreshing = true
+		c.refreshes.Add(1)
+	}
+	c.mu.Unlock()
+	if refresh {
+		go c.refreshNetwork(data)
+	}
+	c.publish()
+}
+
+// refreshNetwork runs the network checks and publishes their results.
+func (c *checklist) refreshNetwork(data UpgradeData) {
+	defer c.refreshes.Done()
+	results := map[string]checkResult{}
+	for _, item := range c.items {
+		if networkChecks[item] {
+			status, detail := c.evaluate(item, data)
+			results[item] = checkResult{Check: item, Status: status, Detail: detail}
+		}
+	}
+	c.mu.Lock()
+	c.network = results
+	c.networkAt = time.Now()
+	c.refreshing = false
+	c.mu.Unlock()
+	c.publish()
+}
+
+// publish runs the local checks against the latest poll result, adds the
+// latest network check results and exports the report.
+func (c *checklist) publish() {
+	c.publishMu.Lock()
+	defer c.publishMu.Unlock()
+	c.mu.Lock()
+	data, network := c.data, c.network
+	c.mu.Unlock()
+
+	report := checklistReport{
+		Height:        data.Height,
+		AppVersion:    targetAppVersion(data),
+		UpgradeHeight: data.UpgradeData.Upgrade.UpgradeHeight,
+		Status:        checkPass,
+		Summary:       map[string]int{checkPass: 0, checkWarn: 0, checkFail: 0, checkSkip: 0},
+	}
+	for _, item := range c.items {
+		result, ok := network[item]
+		switch {
+		case !networkChecks[item]:
+			result.Check = item
+			result.Status, result.Detail = c.evaluate(item, data)
+		case !ok:
+			result = checkResult{Check: item, Status: checkSkip, Detail: "not checked yet"}
+		}
+		report.Checks = append(report.Checks, result)
+		report.Summary[result.Status]++
+		switch result.Status {
+		case checkPass:
+			readinessCheck.WithLabelValues(item).Set(0)
+		case checkWarn:
+			readinessCheck.WithLabelValues(item).Set(1)
+		case checkFail:
+			readinessCheck.WithLabelValues(item).Set(2)
+		default:
+			readinessCheck.DeleteLabelValues(item)
+		}
+		if result.Status == checkFail || result.Status == checkWarn && report.Status == checkPass {
+			report.Status = result.Status
+		}
+	}
+	for status, n := range report.Summary {
+		readinessSummary.WithLabelValues(status).Set(float64(n))
+	}
+
+	c.mu.Lock()
+	c.report = report
+	c.mu.Unlock()
+}
+
+// snapshot returns the latest checklist report.
+func (c *checklist) snapshot() checklistReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.report
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseChecklistItems(t *testing.T) {
+	items, err := parseChecklistItems(" disk_space, ,halt_height")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{checkDiskSpace, checkHaltHeight}; !slices.Equal(items, want) {
+		t.Errorf("got %v, want %v", items, want)
+	}
+	if _, err := parseChecklistItems("disk_space,disk"); err == nil {
+		t.Error("want an error for an unknown check")
+	}
+}
+
+func TestReadHaltHeight(t *testing.T) {
+	tests := []struct {
+		appToml string
+		want    int64
+	}{
+		{"halt-height = 150\n", 150},
+		{"minimum-gas-prices = \"0.002utia\"\n  halt-height = \"2000\"\n", 2000},
+		{"# halt-height = 150\nhalt-time = 0\n", 0},
+	}
+	path := filepath.Join(t.TempDir(), "app.toml")
+	for _, tt := range tests {
+		if err := os.WriteFile(path, []byte(tt.appToml), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readHaltHeight(path)
+		if err != nil || got != tt.want {
+			t.Errorf("%q: got %d, %v, want %d", tt.appToml, got, err, tt.want)
+		}
+	}
+	if _, err := readHaltHeight(filepath.Join(t.TempDir(), "missing.toml")); err == nil {
+		t.Error("want an error for a missing app.toml")
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsPingInterval = 20 * time.Second
+	wsReadTimeout  = time.Minute
+)
+
+// Reconnect backoff, doubling from the initial delay up to the maximum
+var (
+	wsInitialBackoff = time.Second
+	wsMaxBackoff     = 30 * time.Second
+)
+
+// eventHandler is called with every event received for a subscription.
+type eventHandler func(result rpcEventResult)
+
+// cometSubscriber keeps a set of event subscriptions open on a CometBFT RPC
+// websocket, reconnecting and resubscribing whenever the connection drops.
+type cometSubscriber struct {
+	url      string
+	queries  map[string]string
+	handlers map[string]eventHandler
+
+	// onConnect is called with true when the websocket connects and with
+	// false when it drops.
+	onConnect func(connected bool)
+}
+
+type rpcRequest struct {
+	JSONRPC string         `json:"jsonrpc"`
+	ID      string         `json:"id"`
+	Method  string         `json:"method"`
+	Params  map[string]any `json:"params"`
+}
+
+type rpcResponse struct {
+	ID     json.RawMessage `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    string `json:"data"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s %s", e.Code, e.Message, e.Data)
+}
+
+type rpcEventResult struct {
+	Query string `json:"query"`
+	Data  struct {
+		Type  string          `json:"type"`
+		Value json.RawMessage `json:"value"`
+	} `json:"data"`
+	Events map[string][]string `json:"events"`
+}
+
+// websocketURL turns a CometBFT RPC address into its websocket endpoint,
+// e.g. http://host:26657 becomes ws://host:26657/websocket.
+func websocketURL(addr string) (string, error) {
+	if !strings.Contains(addr, "://") {
+		addr = "ws://" + addr
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid RPC address %q: %w", addr, err)
+	}
+	switch u.Scheme {
+	case "http", "tcp", "ws":
+		u.Scheme = "ws"
+	case "https", "wss":
+		u.Scheme = "wss"
+	default:
+		return "", fmt.Errorf("unsupported RPC scheme %q", u.Scheme)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/websocket"
+	}
+	return u.String(), nil
+}
+
+// rpcHTTPURL turns a CometBFT RPC address into its HTTP endpoint, e.g.
+// host:26657 becomes http://host:26657.
+func rpcHTTPURL(addr string) (string, error) {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid RPC address %q: %w", addr, err)
+	}
+	switch u.Scheme {
+	case "http", "tcp", "ws":
+		u.Scheme = "http"
+	case "https", "wss":
+		u.Scheme = "https"
+	default:
+		return "", fmt.Errorf("unsupported RPC scheme %q", u.Scheme)
+	}
+	if u.Path == "/websocket" {
+		u.Path = ""
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+func newCometSubscriber(addr string) (*cometSubscriber, error) {
+	wsURL, err := websocketURL(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &cometSubscriber{
+		url:      wsURL,
+		queries:  map[string]string{},
+		handlers: map[string]eventHandler{},
+	}, nil
+}
+
+// subscribe registers a handler for an event query. It must be called
+// before run.
+func (s *cometSubscriber) subscribe(id, query string, handler eventHandler) {
+	s.queries[id] = query
+	s.handlers[id] = handler
+}
+
+// run connects and serves the subscriptions until ctx is cancelled.
+func (s *cometSubscriber) run(ctx context.Context) {
+	backoff := wsInitialBackoff
+	for ctx.Err() == nil {
+		start := time.Now()
+		err := s.serve(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > wsMaxBackoff {
+			backoff = wsInitialBackoff
+		}
+		log.Printf("CometBFT websocket %s disconnected: %v (reconnecting in %s)", s.url, err, backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, wsMaxBackoff)
+	}
+}
+
+// serve runs a single websocket session.
+func (s *cometSubscriber) serve(ctx context.Context) error {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, s.url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to dial: %w", err)
+	}
+	defer conn.Close()
+
+	// Writes go through a single goroutine-safe helper
+	var writeMu sync.Mutex
+	write := func(messageType int, data []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		return conn.WriteMessage(messageType, data)
+	}
+
+	for id, query := range s.queries {
+		req, err := json.Marshal(rpcRequest{
+			JSONRPC: "2.0",
+			ID:      id,
+			Method:  "subscribe",
+			Params:  map[string]any{"query": query},
+		})
+		if err != nil {
+			return err
+		}
+		if err := write(websocket.TextMessage, req); err != nil {
+			return fmt.Errorf("failed to subscribe to %q: %w", query, err)
+		}
+	}
+	log.Printf("Subscribed to %d CometBFT event queries on %s", len(s.queries), s.url)
+	if s.onConnect != nil {
+		s.onConnect(true)
+		defer s.onConnect(false)
+	}
+
+	// Keep the connection alive and notice dead peers
+	conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+	})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(wsPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				conn.Close()
+				return
+			case <-ticker.C:
+				if err := write(websocket.PingMessage, nil); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+
+		var resp rpcResponse
+		if err := json.Unmarshal(msg, &resp); err != nil {
+			log.Printf("Ignoring malformed websocket message: %v", err)
+			continue
+		}
+		if resp.Error != nil {
+			return resp.Error
+		}
+		var id string
+		if err := json.Unmarshal(resp.ID, &id); err != nil {
+			continue
+		}
+		handler, ok := s.handlers[strings.TrimSuffix(id, "#event")]
+		if !ok {
+			continue
+		}
+		var result rpcEventResult
+		if err := json.Unmarshal(resp.Result, &result); err != nil || result.Data.Value == nil {
+			// The first response to a subscribe request has an empty result
+			continue
+		}
+		handler(result)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+)
+
+// fileConfig is the optional JSON configuration file given with -config.
+type fileConfig struct {
+	Endpoints []endpointConfig  `json:"endpoints"`
+	Fleet     []fleetNodeConfig `json:"fleet,omitempty"`
+}
+
+// endpointConfig describes one endpoint and how to connect to it.
+type endpointConfig struct {
+	Address string                  `json:"address"`
+	TLS     *tlsConfig              `json:"tls,omitempty"`
+	Headers map[string]secretSource `json:"headers,omitempty"`
+	Token   *secretSource           `json:"token,omitempty"`
+}
+
+// tlsConfig holds per-endpoint TLS settings. Setting it enables TLS even if
+// the address has no https:// prefix.
+type tlsConfig struct {
+	CAFile             string `json:"ca_file,omitempty"`
+	CertFile           string `json:"cert_file,omitempty"`
+	KeyFile            string `json:"key_file,omitempty"`
+	ServerName         string `json:"server_name,omitempty"`
+	MinVersion         string `json:"min_version,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+}
+
+func loadConfig(path string) (fileConfig, error) {
+	var cfg fileConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read config: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// configEndpoints turns the endpoints in the config file into endpoints.
+func configEndpoints(cfg fileConfig) ([]*endpoint, error) {
+	var result []*endpoint
+	for _, ec := range cfg.Endpoints {
+		parsed, err := parseEndpointAddress(ec.Address)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", redactAddress(ec.Address), err)
+		}
+		if ec.TLS != nil {
+			if (ec.TLS.CertFile == "") != (ec.TLS.KeyFile == "") {
+				return nil, fmt.Errorf("%s: cert_file and key_file must be set together", parsed)
+			}
+			if _, err := parseTLSVersion(ec.TLS.MinVersion); err != nil {
+				return nil, fmt.Errorf("%s: %w", redactAddress(ec.Address), err)
+			}
+			parsed.useTLS = true
+			parsed.tls = ec.TLS
+		}
+		if len(ec.Headers) > 0 || ec.Token != nil {
+			parsed.auth = &authConfig{Headers: ec.Headers, Token: ec.Token, plaintext: allowPlaintextAuth, inline: allowInlineSecrets}
+			if err := parsed.auth.validate(); err != nil {
+				return nil, fmt.Errorf("%s: %w", parsed, err)
+			}
+			if !parsed.useTLS {
+				if !allowPlaintextAuth {
+					return nil, fmt.Errorf("%s: credentials would be sent without TLS; enable TLS or pass -allow-plaintext-auth", parsed)
+				}
+				log.Printf("WARNING: credentials for %s will be sent without TLS", parsed)
+			}
+		}
+		result = append(result, &endpoint{address: parsed})
+	}
+	return result, nil
+}
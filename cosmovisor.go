@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cosmovisorDaemon is the binary cosmovisor runs.
+const cosmovisorDaemon = "celestia-appd"
+
+// Cosmovisor checks
+const (
+	checkBinaryPresent = "binary_present"
+	checkBinaryVersion = "binary_version"
+	checkChecksum      = "checksum"
+	checkCurrentLink   = "current_link"
+)
+
+var cosmovisorChecks = []string{checkBinaryPresent, checkBinaryVersion, checkChecksum, checkCurrentLink}
+
+// upgradeInfo is data/upgrade-info.json, written by the node when it halts
+// for an upgrade.
+type upgradeInfo struct {
+	Name   string `json:"name"`
+	Height int64  `json:"height"`
+	Info   string `json:"info,omitempty"`
+}
+
+// cosmovisorReport is the /cosmovisor response.
+type cosmovisorReport struct {
+	Home          string        `json:"home"`
+	UpgradeName   string        `json:"upgrade_name,omitempty"`
+	UpgradeHeight int64         `json:"upgrade_height,omitempty"`
+	Binary        string        `json:"binary,omitempty"`
+	Version       string        `json:"version,omitempty"`
+	SHA256        string        `json:"sha256,omitempty"`
+	Current       string        `json:"current,omitempty"`
+	UpgradeInfo   *upgradeInfo  `json:"upgrade_info,omitempty"`
+	Checks        []checkResult `json:"checks"`
+}
+
+// binaryFacts caches what was learned about a binary, keyed by its size
+// and modification time, so it is only hashed and run once.
+type binaryFacts struct {
+	size    int64
+	modTime time.Time
+	version string
+	sha256  string
+	err     error
+}
+
+// cosmovisorChecker checks the cosmovisor layout under a daemon home
+// against the scheduled upgrade.
+type cosmovisorChecker struct {
+	home string
+	// nameFormat turns an app version into an upgrade name, e.g. v%d
+	nameFormat  string
+	manifest    map[string]string
+	minReleases map[uint64]string
+	alertBlocks int64
+
+	mu       sync.Mutex
+	report   cosmovisorReport
+	binaries map[string]binaryFacts
+	failing  map[string]string
+	// infoModTime is the modification time of the last upgrade-info.json
+	// seen, and expected the upgrade it should name
+	infoModTime time.Time
+	expected    upgradeInfo
+	lastCurrent string
+}
+
+// cosmovisor is set when -cosmovisor-home is given, nil otherwise.
+var cosmovisor *cosmovisorChecker
+
+func newCosmovisorChecker(home, nameFormat string, manifest map[string]string, minReleases map[uint64]string, alertBlocks int64) *cosmovisorChecker {
+	return &cosmovisorChecker{
+		home:        home,
+		nameFormat:  nameFormat,
+		manifest:    manifest,
+		minReleases: minReleases,
+		alertBlocks: alertBlocks,
+		binaries:    map[string]binaryFacts{},
+		failing:     map[string]string{},
+	}
+}
+
+// loadManifest reads a sha256sum style file of checksums and paths
+// relative to the cosmovisor directory, e.g.
+// "<hex>  upgrades/v4/bin/celestia-appd".
+func loadManifest(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open manifest: %w", err)
+	}
+	defer f.Close()
+	manifest := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid manifest line %q, expected a checksum and a path", line)
+		}
+		sum := strings.ToLower(strings.TrimPrefix(fields[0], "sha256:"))
+		if _, err := hex.DecodeString(sum); err != nil || len(sum) != sha256.Size*2 {
+			return nil, fmt.Errorf("invalid checksum %q in manifest", fields[0])
+		}
+		manifest[filepath.Clean(strings.TrimPrefix(fields[1], "*"))] = sum
+	}
+	return manifest, scanner.Err()
+}
+
+func (c *cosmovisorChecker) dir() string {
+	return filepath.Join(c.home, "cosmovisor")
+}
+
+func (c *cosmovisorChecker) upgradeName(appVersion uint64) string {
+	return fmt.Sprintf(c.nameFormat, appVersion)
+}
+
+// upgradeBinary returns the path of the binary for an upgrade, relative to
+// the cosmovisor directory.
+func upgradeBinary(name string) string {
+	return filepath.Join("upgrades", name, "bin", cosmovisorDaemon)
+}
+
+// current returns where the current symlink points, relative to the
+// cosmovisor directory.
+func (c *cosmovisorChecker) current() (string, error) {
+	target, err := os.Readlink(filepath.Join(c.dir(), "current"))
+	if err != nil {
+		return "", err
+	}
+	if filepath.IsAbs(target) {
+		if rel, err := filepath.Rel(c.dir(), target); err == nil {
+			target = rel
+		}
+	}
+	return filepath.Clean(target), nil
+}
+
+// inspect hashes a binary and asks it for its version, reusing the last
+// result while the file is unchanged.
+func (c *cosmovisorChecker) inspect(path string, fi os.FileInfo) binaryFacts {
+	c.mu.Lock()
+	cached, ok := c.binaries[path]
+	c.mu.Unlock()
+	if ok && cached.size == fi.Size() && cached.modTime.Equal(fi.ModTime()) {
+		return cached
+	}
+
+	facts := binaryFacts{size: fi.Size(), modTime: fi.ModTime()}
+	facts.sha256, facts.err = fileSHA256(path)
+	if facts.err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		out, err := exec.CommandContext(ctx, path, "version").CombinedOutput()
+		cancel()
+		if err != nil {
+			facts.err = fmt.Errorf("%s version failed: %w", path, err)
+		} else {
+			facts.version = strings.TrimSpace(string(out))
+		}
+	}
+	c.mu.Lock()
+	c.binaries[path] = facts
+	c.mu.Unlock()
+	return facts
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// check evaluates the layout against a poll result. With an upgrade
+// scheduled, its binary has to be staged, report the right version, match
+// the manifest, and current must not have switched before the upgrade
+// height. Without one, current should point at the running version's
+// upgrade if there is one.
+func (c *cosmovisorChecker) check(data UpgradeData) cosmovisorReport {
+	report := cosmovisorReport{Home: c.home}
+	result := func(check, status, detail string) {
+		report.Checks = append(report.Checks, checkResult{Check: check, Status: status, Detail: detail})
+	}
+	current, err := c.current()
+	report.Current = current
+	if info, err := readUpgradeInfo(filepath.Join(c.home, "data", "upgrade-info.json")); err == nil {
+		report.UpgradeInfo = info
+	}
+
+	upgrade := data.UpgradeData.Upgrade
+	if upgrade.UpgradeHeight == 0 {
+		skipped := "no upgrade scheduled"
+		result(checkBinaryPresent, checkSkip, skipped)
+		result(checkBinaryVersion, checkSkip, skipped)
+		result(checkChecksum, checkSkip, skipped)
+		running := upgradeBinary(c.upgradeName(data.AppVersion))
+		switch {
+		case err != nil:
+			result(checkCurrentLink, checkFail, err.Error())
+		case fileExists(filepath.Join(c.dir(), running)) && current != filepath.Dir(filepath.Dir(running)):
+			result(checkCurrentLink, checkFail, fmt.Sprintf("points at %s, but app version %d runs from %s", current, data.AppVersion, filepath.Dir(filepath.Dir(running))))
+		default:
+			result(checkCurrentLink, checkPass, "points at "+current)
+		}
+		return report
+	}
+
+	appVersion := uint64(upgrade.AppVersion)
+	report.UpgradeName = c.upgradeName(appVersion)
+	report.UpgradeHeight = upgrade.UpgradeHeight
+	rel := upgradeBinary(report.UpgradeName)
+	report.Binary = filepath.Join(c.dir(), rel)
+
+	fi, statErr := os.Stat(report.Binary)
+	switch {
+	case statErr != nil:
+		result(checkBinaryPresent, checkFail, statErr.Error())
+	case !fi.Mode().IsRegular() || fi.Mode().Perm()&0o111 == 0:
+		result(checkBinaryPresent, checkFail, report.Binary+" is not an executable file")
+		statErr = fmt.Errorf("not executable")
+	default:
+		result(checkBinaryPresent, checkPass, report.Binary)
+	}
+
+	if statErr != nil {
+		result(checkBinaryVersion, checkFail, "no binary staged")
+		result(checkChecksum, checkFail, "no binary staged")
+	} else {
+		facts := c.inspect(report.Binary, fi)
+		report.Version = facts.version
+		report.SHA256 = facts.sha256
+		if facts.err != nil {
+			result(checkBinaryVersion, checkFail, facts.err.Error())
+		} else if ok, _, reason := checkRelease(facts.version, appVersion, c.minReleases); !ok {
+			result(checkBinaryVersion, checkFail, reason)
+		} else {
+			result(checkBinaryVersion, checkPass, facts.version)
+		}
+
+		want, listed := c.manifest[rel]
+		switch {
+		case !listed:
+			result(checkChecksum, checkSkip, rel+" is not in the manifest")
+		case facts.sha256 != want:
+			result(checkChecksum, checkFail, fmt.Sprintf("sha256 is %s, manifest says %s", facts.sha256, want))
+		default:
+			result(checkChecksum, checkPass, facts.sha256)
+		}
+	}
+
+	staged := filepath.Dir(filepath.Dir(rel))
+	switch {
+	case err != nil:
+		result(checkCurrentLink, checkFail, err.Error())
+	case data.Height < upgrade.UpgradeHeight && current == staged:
+		result(checkCurrentLink, checkFail, fmt.Sprintf("already points at %s before the upgrade height", staged))
+	default:
+		result(checkCurrentLink, checkPass, "points at "+current)
+	}
+	return report
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func readUpgradeInfo(path string) (*upgradeInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var info upgradeInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return &info, nil
+}
+
+// update runs the checks against a poll result, sets the metrics and
+// emits events for checks that start failing, critical within alertBlocks
+// of the upgrade height.
+func (c *cosmovisorChecker) update(data UpgradeData) {
+	report := c.check(data)
+	upgrade := data.UpgradeData.Upgrade
+	severity := severityWarning
+	if upgrade.UpgradeHeight > 0 && upgrade.UpgradeHeight-data.Height <= c.alertBlocks {
+		severity = severityCritical
+	}
+
+	c.mu.Lock()
+	if upgrade.UpgradeHeight > 0 {
+		c.expected = upgradeInfo{Name: report.UpgradeName, Height: upgrade.UpgradeHeight}
+	}
+	previous := c.failing
+	c.failing = map[string]string{}
+	for _, r := range report.Checks {
+		if r.Status == checkFail {
+			c.failing[r.Check] = severity
+		}
+	}
+	c.report = report
+	switched := c.lastCurrent != "" && report.Current != "" && report.Current != c.lastCurrent
+	from := c.lastCurrent
+	if report.Current != "" {
+		c.lastCurrent = report.Current
+	}
+	c.mu.Unlock()
+
+	for _, r := range report.Checks {
+		switch r.Status {
+		case checkPass:
+			cosmovisorCheck.WithLabelValues(r.Check).Set(1)
+		case checkFail:
+			cosmovisorCheck.WithLabelValues(r.Check).Set(0)
+		default:
+			cosmovisorCheck.DeleteLabelValues(r.Check)
+		}
+		if r.Status != checkFail {
+			continue
+		}
+		if was, ok := previous[r.Check]; ok && (was == severity || was == severityCritical) {
+			continue
+		}
+		emitEvent(Event{
+			Kind:     "cosmovisor_check_failed",
+			Severity: severity,
+			Message:  fmt.Sprintf("cosmovisor %s check failed: %s", r.Check, r.Detail),
+			Height:   data.Height,
+			Fields: map[string]string{
+				"check":        r.Check,
+				"upgrade_name": report.UpgradeName,
+				"detail":       r.Detail,
+			},
+		})
+	}
+
+	if switched {
+		emitEvent(Event{
+			Kind:    "cosmovisor_switched",
+			Message: fmt.Sprintf("cosmovisor switched current from %s to %s", from, report.Current),
+			Height:  data.Height,
+			Fields:  map[string]string{"from": from, "to": report.Current},
+		})
+	}
+}
+
+// watchUpgradeInfo checks data/upgrade-info.json every interval, to catch
+// the node halting for the upgrade as soon as it happens.
+func (c *cosmovisorChecker) watchUpgradeInfo(interval time.Duration) {
+	for {
+		c.checkUpgradeInfo()
+		time.Sleep(interval)
+	}
+}
+
+// checkUpgradeInfo emits an event when upgrade-info.json is written or
+// changes, and wakes the poll loop.
+func (c *cosmovisorChecker) checkUpgradeInfo() {
+	path := filepath.Join(c.home, "data", "upgrade-info.json")
+	fi, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	seen := c.infoModTime
+	expected := c.expected
+	c.infoModTime = fi.ModTime()
+	c.mu.Unlock()
+	if !fi.ModTime().After(seen) {
+		return
+	}
+	info, err := readUpgradeInfo(path)
+	if err != nil {
+		log.Printf("Failed to read upgrade info: %v", err)
+		return
+	}
+	cosmovisorUpgradeInfoHeight.Set(float64(info.Height))
+	if seen.IsZero() && expected.Height == 0 {
+		// Left over from an earlier upgrade
+		log.Printf("Found %s for upgrade %s at height %d", path, info.Name, info.Height)
+		return
+	}
+
+	fields := map[string]string{"name": info.Name, "height": strconv.FormatInt(info.Height, 10)}
+	if expected.Height > 0 && (info.Name != expected.Name || info.Height != expected.Height) {
+		fields["expected_name"] = expected.Name
+		fields["expected_height"] = strconv.FormatInt(expected.Height, 10)
+		emitEvent(Event{
+			Kind:     "cosmovisor_upgrade_info",
+			Severity: severityWarning,
+			Message:  fmt.Sprintf("node halted for upgrade %s at height %d, but upgrade %s at height %d is scheduled", info.Name, info.Height, expected.Name, expected.Height),
+			Height:   info.Height,
+			Fields:   fields,
+		})
+	} else {
+		emitEvent(Event{
+			Kind:    "cosmovisor_upgrade_info",
+			Message: fmt.Sprintf("node halted for upgrade %s at height %d; cosmovisor should switch binaries now", info.Name, info.Height),
+			Height:  info.Height,
+			Fields:  fields,
+		})
+	}
+	requestPoll()
+}
+
+// snapshot returns the latest report.
+func (c *cosmovisorChecker) snapshot() cosmovisorReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.report
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadManifest(t *testing.T) {
+	sum := strings.Repeat("ab", 32)
+	path := filepath.Join(t.TempDir(), "manifest")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("# staged binaries\n\n" + sum + "  upgrades/v2/bin/celestia-appd\nsha256:" + strings.ToUpper(sum) + " *./upgrades/v3/bin/celestia-appd\n")
+	manifest, err := loadManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest) != 2 || manifest[upgradeBinary("v2")] != sum || manifest[upgradeBinary("v3")] != sum {
+		t.Errorf("got %v", manifest)
+	}
+
+	for _, content := range []string{
+		sum + "\n",
+		"abcd  upgrades/v2/bin/celestia-appd\n",
+		strings.Repeat("zz", 32) + "  upgrades/v2/bin/celestia-appd\n",
+	} {
+		write(content)
+		if _, err := loadManifest(path); err == nil {
+			t.Errorf("%q: want an error", content)
+		}
+	}
+}
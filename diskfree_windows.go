@@ -0,0 +1,8 @@
+package main
+
+import "errors"
+
+// diskFree isn't implemented on Windows; disk_space reports the error.
+func diskFree(path string) (uint64, error) {
+	return 0, errors.New("free disk space isn't supported on windows")
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// endpoint is a node the monitor can query, along with the health observed
+// on the calls made to it.
+type endpoint struct {
+	address endpointAddress
+
+	mu            sync.Mutex
+	backend       backend
+	healthy       bool
+	chainID       string
+	lastSuccess   time.Time
+	lastError     string
+	lastErrorTime time.Time
+}
+
+// endpointStatus is the JSON view of an endpoint's health.
+type endpointStatus struct {
+	Address       string     `json:"address"`
+	Transport     string     `json:"transport"`
+	Healthy       bool       `json:"healthy"`
+	ChainID       string     `json:"chain_id,omitempty"`
+	LastSuccess   *time.Time `json:"last_success,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+}
+
+// All configured endpoints, in order of preference
+var endpoints []*endpoint
+
+// parseEndpoints parses a comma-separated list of endpoint addresses.
+func parseEndpoints(list string) ([]*endpoint, error) {
+	var result []*endpoint
+	for _, addr := range strings.Split(list, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		parsed, err := parseEndpointAddress(addr)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", redactAddress(addr), err)
+		}
+		result = append(result, &endpoint{address: parsed})
+	}
+	if len(result) == 0 {
+		return nil, errors.New("no endpoint address given")
+	}
+	return result, nil
+}
+
+// client returns the endpoint's backend, connecting on first use.
+func (e *endpoint) client() (backend, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.backend == nil {
+		b, err := newBackend(e.address)
+		if err != nil {
+			return nil, err
+		}
+		e.backend = b
+	}
+	return e.backend, nil
+}
+
+func (e *endpoint) markSuccess(chainID string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.healthy = true
+	e.lastSuccess = time.Now()
+	if chainID != "" {
+		e.chainID = chainID
+	}
+}
+
+func (e *endpoint) markFailure(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.healthy = false
+	e.lastError = err.Error()
+	e.lastErrorTime = time.Now()
+}
+
+func (e *endpoint) status() endpointStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s := endpointStatus{
+		Address:   e.address.String(),
+		Transport: e.address.transport,
+		Healthy:   e.healthy,
+		ChainID:   e.chainID,
+		LastError: e.lastError,
+	}
+	if !e.lastSuccess.IsZero() {
+		t := e.lastSuccess
+		s.LastSuccess = &t
+	}
+	if !e.lastErrorTime.IsZero() {
+		t := e.lastErrorTime
+		s.LastErrorTime = &t
+	}
+	return s
+}
+
+func (e *endpoint) isHealthy() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.healthy
+}
+
+// queryEndpoints runs fn against each endpoint in turn, healthy ones first,
+// until one succeeds. fn returns the chain ID it saw, if any.
+func queryEndpoints(fn func(b backend) (string, error)) error {
+	ordered := make([]*endpoint, 0, len(endpoints))
+	for _, e := range endpoints {
+		if e.isHealthy() {
+			ordered = append(ordered, e)
+		}
+	}
+	for _, e := range endpoints {
+		if !e.isHealthy() {
+			ordered = append(ordered, e)
+		}
+	}
+
+	var errs []error
+	for _, e := range ordered {
+		b, err := e.client()
+		if err == nil {
+			var chainID string
+			chainID, err = fn(b)
+			if err == nil {
+				e.markSuccess(chainID)
+				return nil
+			}
+			if isRequestError(err) {
+				// The request itself was bad, e.g. a pruned height, so
+				// another endpoint won't do better
+				return err
+			}
+		}
+		e.markFailure(err)
+		log.Printf("%s endpoint %s failed: %v", e.address.transport, e.address, err)
+		errs = append(errs, fmt.Errorf("%s: %w", e.address, err))
+	}
+	return errors.Join(errs...)
+}
+
+// isRequestError reports whether err was caused by the request rather than
+// by the endpoint.
+func isRequestError(err error) bool {
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.NotFound, codes.FailedPrecondition, codes.OutOfRange:
+		return true
+	}
+	return false
+}
+
+// queryUpgrade runs getUpgrade at height against the endpoints and, in
+// verified mode, verifies the result before returning it.
+func queryUpgrade(height int64) (UpgradeData, error) {
+	resp, err := fetchUpgrade(height)
+	if err == nil && verifier != nil {
+		verifyUpgrade(&resp)
+	}
+	return resp, err
+}
+
+// fetchUpgrade runs getUpgrade at height against the endpoints.
+func fetchUpgrade(height int64) (UpgradeData, error) {
+	var resp UpgradeData
+	err := queryEndpoints(func(b backend) (string, error) {
+		var err error
+		resp, err = getUpgrade(b, height)
+		return resp.ChainID, err
+	})
+	return resp, err
+}
+
+// verifyUpgrade checks resp against the light client and records the
+// outcome in it.
+func verifyUpgrade(resp *UpgradeData) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	if err := verifier.check(ctx, *resp); err != nil {
+		log.Printf("Failed to verify response at height %d: %v", resp.Height, err)
+		resp.VerifyError = err.Error()
+	} else {
+		resp.Verified = true
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const maxEvents = 500
+
+// Event severities
+const (
+	severityInfo     = "info"
+	severityWarning  = "warning"
+	severityCritical = "critical"
+)
+
+// Event is something noteworthy that happened during an upgrade, such as a
+// validator signalling or the upgrade being scheduled.
+type Event struct {
+	Time     time.Time         `json:"time"`
+	Kind     string            `json:"kind"`
+	Severity string            `json:"severity"`
+	Message  string            `json:"message"`
+	Height   int64             `json:"height,omitempty"`
+	Fields   map[string]string `json:"fields,omitempty"`
+}
+
+// eventLog keeps the most recent events and forwards every event to the
+// configured webhooks.
+type eventLog struct {
+	mu       sync.Mutex
+	events   []Event
+	webhooks []string
+}
+
+var events = &eventLog{}
+
+// emitEvent records an event, logs it and notifies webhooks.
+func emitEvent(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if e.Severity == "" {
+		e.Severity = severityInfo
+	}
+	log.Printf("[%s] %s: %s", e.Severity, e.Kind, e.Message)
+	eventsTotal.WithLabelValues(e.Kind, e.Severity).Inc()
+
+	events.mu.Lock()
+	events.events = append(events.events, e)
+	if len(events.events) > maxEvents {
+		events.events = events.events[len(events.events)-maxEvents:]
+	}
+	webhooks := events.webhooks
+	events.mu.Unlock()
+
+	for _, url := range webhooks {
+		go func() {
+			if err := postWebhook(url, e); err != nil {
+				log.Printf("Failed to deliver event to webhook: %v", err)
+			}
+		}()
+	}
+}
+
+// recentEvents returns a copy of the recorded events, oldest first.
+func recentEvents() []Event {
+	events.mu.Lock()
+	defer events.mu.Unlock()
+	return append([]Event(nil), events.events...)
+}
+
+func postWebhook(url string, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	signaltypes "celestia-upgrade-monitor/celestia/signal/v1"
+
+	authztypes "cosmossdk.io/api/cosmos/authz/v1beta1"
+	"github.com/cosmos/cosmos-sdk/types/bech32"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// typeMsgExec is the type URL of authz's MsgExec.
+const typeMsgExec = "/cosmos.authz.v1beta1.MsgExec"
+
+// grant is a GenericAuthorization held by the fake authz module.
+type grant struct {
+	granter, grantee, msgType string
+	expiration                time.Time
+}
+
+// SetGrant adds a GenericAuthorization for msgType from granter to grantee
+// account addresses. A zero expiration never expires.
+func (s *Server) SetGrant(granter, grantee, msgType string, expiration time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.grants = append(s.grants, grant{granter: granter, grantee: grantee, msgType: msgType, expiration: expiration})
+}
+
+// ExecSignalVersion records a validator signalling for version through a
+// grantee's MsgExec and publishes the transaction.
+func (s *Server) ExecSignalVersion(grantee, address string, version uint64) error {
+	signal, err := proto.Marshal(&signaltypes.MsgSignalVersion{ValidatorAddress: address, Version: version})
+	if err != nil {
+		return err
+	}
+	s.Signal(address, version)
+	return s.publishTx(typeMsgExec, &authztypes.MsgExec{Grantee: grantee, Msgs: []*anypb.Any{{TypeUrl: typeMsgSignalVersion, Value: signal}}})
+}
+
+type authzServer struct {
+	authztypes.UnimplementedQueryServer
+	s *Server
+}
+
+// Grants returns the grants matching the request, expired or not.
+func (a authzServer) Grants(ctx context.Context, req *authztypes.QueryGrantsRequest) (*authztypes.QueryGrantsResponse, error) {
+	if _, err := a.s.begin(ctx, MethodGrants); err != nil {
+		return nil, err
+	}
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+	var resp authztypes.QueryGrantsResponse
+	for _, g := range a.s.grants {
+		if g.granter != req.GetGranter() || g.grantee != req.GetGrantee() ||
+			(req.GetMsgTypeUrl() != "" && g.msgType != req.GetMsgTypeUrl()) {
+			continue
+		}
+		auth, err := anypb.New(&authztypes.GenericAuthorization{Msg: g.msgType})
+		if err != nil {
+			return nil, err
+		}
+		auth.TypeUrl = "/cosmos.authz.v1beta1.GenericAuthorization"
+		result := &authztypes.Grant{Authorization: auth}
+		if !g.expiration.IsZero() {
+			result.Expiration = timestamppb.New(g.expiration)
+		}
+		resp.Grants = append(resp.Grants, result)
+	}
+	return &resp, nil
+}
+
+// unwrapExec returns the messages in a MsgExec after checking grantee holds
+// an unexpired grant for each from the validator that signs it.
+func (s *Server) unwrapExec(msg *anypb.Any) ([]*anypb.Any, error) {
+	var exec authztypes.MsgExec
+	if err := proto.Unmarshal(msg.GetValue(), &exec); err != nil {
+		return nil, err
+	}
+	for _, inner := range exec.GetMsgs() {
+		if inner.GetTypeUrl() != typeMsgSignalVersion {
+			return nil, fmt.Errorf("unsupported message %s in MsgExec", inner.GetTypeUrl())
+		}
+		var signal signaltypes.MsgSignalVersion
+		if err := proto.Unmarshal(inner.GetValue(), &signal); err != nil {
+			return nil, err
+		}
+		granter, err := accountOf(signal.GetValidatorAddress())
+		if err != nil {
+			return nil, err
+		}
+		if !s.granted(granter, exec.GetGrantee(), inner.GetTypeUrl()) {
+			return nil, fmt.Errorf("authorization not found for %s from %s to %s", inner.GetTypeUrl(), granter, exec.GetGrantee())
+		}
+	}
+	return exec.GetMsgs(), nil
+}
+
+// accountOf returns the account address of a validator operator address.
+func accountOf(valoper string) (string, error) {
+	_, addr, err := bech32.DecodeAndConvert(valoper)
+	if err != nil {
+		return "", err
+	}
+	return bech32.ConvertAndEncode("celestia", addr)
+}
+
+// granted reports whether a grant is valid at the current block time.
+func (s *Server) granted(granter, grantee, msgType string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.genesisTime.Add(time.Duration(s.height) * s.blockTime)
+	for _, g := range s.grants {
+		if g.granter == granter && g.grantee == grantee && g.msgType == msgType &&
+			(g.expiration.IsZero() || g.expiration.After(now)) {
+			return true
+		}
+	}
+	return false
+}
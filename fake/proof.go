@@ -0,0 +1,168 @@
+package fake
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+
+	ics23 "github.com/cosmos/ics23/go"
+)
+
+// ProofOp is one layer of a store proof, as abci_query returns it.
+type ProofOp struct {
+	Type string
+	Key  []byte
+	Data []byte
+}
+
+// State is a multistore of IAVL-hashed module stores that proves its keys
+// the way celestia-app's abci_query does: an ics23:iavl proof for the key
+// in its store and an ics23:simple proof for the store in the app hash.
+type State struct {
+	stores map[string]map[string][]byte
+}
+
+// NewState returns an empty state.
+func NewState() *State {
+	return &State{stores: map[string]map[string][]byte{}}
+}
+
+// Set sets key in store to value.
+func (s *State) Set(store string, key, value []byte) {
+	if s.stores[store] == nil {
+		s.stores[store] = map[string][]byte{}
+	}
+	s.stores[store][string(key)] = value
+}
+
+// AppHash returns the root hash of all stores.
+func (s *State) AppHash() []byte {
+	root, _ := merkleTree(s.storeRoots(), false)
+	return root
+}
+
+// Prove returns the value of key in store, nil if it is absent, with the
+// proof of its presence or absence.
+func (s *State) Prove(store string, key []byte) ([]byte, []ProofOp, error) {
+	storeRoot, proofs := merkleTree(s.stores[store], true)
+	value := s.stores[store][string(key)]
+
+	var storeProof ics23.CommitmentProof
+	if value != nil {
+		storeProof.Proof = &ics23.CommitmentProof_Exist{Exist: proofs[string(key)]}
+	} else {
+		absent := &ics23.NonExistenceProof{Key: key}
+		for _, k := range sortedKeys(s.stores[store]) {
+			if k < string(key) {
+				absent.Left = proofs[k]
+			} else if absent.Right == nil {
+				absent.Right = proofs[k]
+			}
+		}
+		storeProof.Proof = &ics23.CommitmentProof_Nonexist{Nonexist: absent}
+	}
+
+	roots := s.storeRoots()
+	roots[store] = storeRoot
+	_, rootProofs := merkleTree(roots, false)
+	rootProof := ics23.CommitmentProof{Proof: &ics23.CommitmentProof_Exist{Exist: rootProofs[store]}}
+
+	storeData, err := storeProof.Marshal()
+	if err != nil {
+		return nil, nil, err
+	}
+	rootData, err := rootProof.Marshal()
+	if err != nil {
+		return nil, nil, err
+	}
+	return value, []ProofOp{
+		{Type: "ics23:iavl", Key: key, Data: storeData},
+		{Type: "ics23:simple", Key: []byte(store), Data: rootData},
+	}, nil
+}
+
+func (s *State) storeRoots() map[string][]byte {
+	roots := map[string][]byte{}
+	for name, kv := range s.stores {
+		roots[name], _ = merkleTree(kv, true)
+	}
+	return roots
+}
+
+func sortedKeys(kv map[string][]byte) []string {
+	keys := make([]string, 0, len(kv))
+	for k := range kv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// iavlPrefix is the height, size and version an IAVL node hashes first.
+// Every node here is at version 1.
+func iavlPrefix(height, size int64) []byte {
+	b := binary.AppendVarint(nil, height)
+	b = binary.AppendVarint(b, size)
+	return binary.AppendVarint(b, 1)
+}
+
+// merkleTree hashes kv as a balanced tree, IAVL-style or as the simple
+// Merkle tree of the multistore, and returns the root and an existence
+// proof for every key.
+func merkleTree(kv map[string][]byte, iavl bool) ([]byte, map[string]*ics23.ExistenceProof) {
+	keys := sortedKeys(kv)
+	proofs := map[string]*ics23.ExistenceProof{}
+	type node struct {
+		hash         []byte
+		height, size int64
+	}
+	var build func(keys []string) node
+	build = func(keys []string) node {
+		if len(keys) == 1 {
+			leaf := &ics23.LeafOp{
+				Hash:         ics23.HashOp_SHA256,
+				PrehashKey:   ics23.HashOp_NO_HASH,
+				PrehashValue: ics23.HashOp_SHA256,
+				Length:       ics23.LengthOp_VAR_PROTO,
+				Prefix:       []byte{0},
+			}
+			if iavl {
+				leaf.Prefix = iavlPrefix(0, 1)
+			}
+			k := keys[0]
+			hash, _ := leaf.Apply([]byte(k), kv[k])
+			proofs[k] = &ics23.ExistenceProof{Key: []byte(k), Value: kv[k], Leaf: leaf}
+			return node{hash: hash, size: 1}
+		}
+		mid := len(keys) / 2
+		left, right := build(keys[:mid]), build(keys[mid:])
+		n := node{height: max(left.height, right.height) + 1, size: left.size + right.size}
+
+		var leftOp, rightOp *ics23.InnerOp
+		if iavl {
+			prefix := append(iavlPrefix(n.height, n.size), 32)
+			leftOp = &ics23.InnerOp{Hash: ics23.HashOp_SHA256, Prefix: prefix, Suffix: append([]byte{32}, right.hash...)}
+			rightOp = &ics23.InnerOp{Hash: ics23.HashOp_SHA256, Prefix: append(append(bytes.Clone(prefix), left.hash...), 32)}
+		} else {
+			leftOp = &ics23.InnerOp{Hash: ics23.HashOp_SHA256, Prefix: []byte{1}, Suffix: right.hash}
+			rightOp = &ics23.InnerOp{Hash: ics23.HashOp_SHA256, Prefix: append([]byte{1}, left.hash...)}
+		}
+		for _, k := range keys[:mid] {
+			proofs[k].Path = append(proofs[k].Path, leftOp)
+		}
+		for _, k := range keys[mid:] {
+			proofs[k].Path = append(proofs[k].Path, rightOp)
+		}
+		h := sha256.New()
+		h.Write(leftOp.Prefix)
+		h.Write(left.hash)
+		h.Write(leftOp.Suffix)
+		n.hash = h.Sum(nil)
+		return n
+	}
+	if len(keys) == 0 {
+		return nil, proofs
+	}
+	return build(keys).hash, proofs
+}
@@ -0,0 +1,495 @@
+// Package fake is an in-process stand-in for a celestia-app node. It serves
+// the signal, tendermint and staking queries the monitor uses from state a
+// test or demo can script: tallies, a scheduled upgrade, the block height,
+// injected errors and latency. It also accepts signal transactions through
+// the auth and tx services, directly or through authz grants. State holds
+// module stores and proves their keys for verified mode.
+package fake
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	signaltypes "celestia-upgrade-monitor/celestia/signal/v1"
+
+	authtypes "cosmossdk.io/api/cosmos/auth/v1beta1"
+	authztypes "cosmossdk.io/api/cosmos/authz/v1beta1"
+	abcitypes "cosmossdk.io/api/cosmos/base/abci/v1beta1"
+	nodetypes "cosmossdk.io/api/cosmos/base/node/v1beta1"
+	cmtservice "cosmossdk.io/api/cosmos/base/tendermint/v1beta1"
+	stakingtypes "cosmossdk.io/api/cosmos/staking/v1beta1"
+	txtypes "cosmossdk.io/api/cosmos/tx/v1beta1"
+	"cosmossdk.io/api/tendermint/p2p"
+	cmtversion "cosmossdk.io/api/tendermint/version"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Full method names errors and latency can be injected for.
+const (
+	MethodVersionTally     = "/celestia.signal.v1.Query/VersionTally"
+	MethodGetUpgrade       = "/celestia.signal.v1.Query/GetUpgrade"
+	MethodGetLatestBlock   = "/cosmos.base.tendermint.v1beta1.Service/GetLatestBlock"
+	MethodGetBlockByHeight = "/cosmos.base.tendermint.v1beta1.Service/GetBlockByHeight"
+	MethodValidator        = "/cosmos.staking.v1beta1.Query/Validator"
+	MethodAccount          = "/cosmos.auth.v1beta1.Query/Account"
+	MethodSimulate         = "/cosmos.tx.v1beta1.Service/Simulate"
+	MethodBroadcastTx      = "/cosmos.tx.v1beta1.Service/BroadcastTx"
+	MethodGetTx            = "/cosmos.tx.v1beta1.Service/GetTx"
+	MethodGrants           = "/cosmos.authz.v1beta1.Query/Grants"
+	MethodGetTxsEvent      = "/cosmos.tx.v1beta1.Service/GetTxsEvent"
+	MethodGetNodeInfo      = "/cosmos.base.tendermint.v1beta1.Service/GetNodeInfo"
+	MethodGetSyncing       = "/cosmos.base.tendermint.v1beta1.Service/GetSyncing"
+	MethodConfig           = "/cosmos.base.node.v1beta1.Service/Config"
+)
+
+const blockHeightHeader = "x-cosmos-block-height"
+
+// Validator is a validator known to the fake staking module.
+type Validator struct {
+	Address string
+	Moniker string
+	Power   int64
+}
+
+// Server is a scriptable fake node. Queries at past heights are answered
+// from the current state, like a node that has no history to speak of.
+type Server struct {
+	signaltypes.UnimplementedQueryServer
+
+	mu          sync.Mutex
+	chainID     string
+	height      int64
+	appVersion  uint64
+	genesisTime time.Time
+	blockTime   time.Duration
+	upgrade     *signaltypes.Upgrade
+	validators  map[string]*Validator
+	signals     map[string]uint64
+	errors      map[string]error
+	latency     time.Duration
+	accounts    map[string]*authtypes.BaseAccount
+	vesting     map[string]bool
+	txs         map[string]*abcitypes.TxResponse
+	grants      []grant
+	included    []includedTx
+	nodeVersion string
+	gitCommit   string
+	syncing     bool
+	minGasPrice string
+
+	// upgradeDelay is how many blocks after a successful TryUpgrade the
+	// upgrade happens
+	upgradeDelay int64
+
+	wsMu      sync.Mutex
+	wsClients map[*wsClient]struct{}
+}
+
+// New returns a server for chainID at height 1 running app version 1.
+func New(chainID string) *Server {
+	return &Server{
+		chainID:      chainID,
+		height:       1,
+		appVersion:   1,
+		genesisTime:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		blockTime:    6 * time.Second,
+		validators:   map[string]*Validator{},
+		signals:      map[string]uint64{},
+		errors:       map[string]error{},
+		accounts:     map[string]*authtypes.BaseAccount{},
+		vesting:      map[string]bool{},
+		txs:          map[string]*abcitypes.TxResponse{},
+		wsClients:    map[*wsClient]struct{}{},
+		upgradeDelay: 100,
+		nodeVersion:  "1.0.0",
+	}
+}
+
+// SetHeight sets the latest block height.
+func (s *Server) SetHeight(height int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.height = height
+}
+
+// AdvanceHeight produces n blocks and returns the new height. Each block is
+// published to websocket subscribers, and the app version switches once a
+// scheduled upgrade height is reached.
+func (s *Server) AdvanceHeight(n int64) int64 {
+	var height int64
+	for i := int64(0); i < n; i++ {
+		s.mu.Lock()
+		s.height++
+		height = s.height
+		if s.upgrade != nil && s.height >= s.upgrade.UpgradeHeight {
+			s.appVersion = s.upgrade.AppVersion
+			s.upgrade = nil
+			s.signals = map[string]uint64{}
+		}
+		s.mu.Unlock()
+		s.publishBlock(height)
+	}
+	return s.Height()
+}
+
+// Height returns the latest block height.
+func (s *Server) Height() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.height
+}
+
+// SetAppVersion sets the app version reported in block headers.
+func (s *Server) SetAppVersion(version uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.appVersion = version
+}
+
+// SetBlockTime sets the time between block timestamps.
+func (s *Server) SetBlockTime(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blockTime = d
+}
+
+// SetUpgradeDelay sets how many blocks after a successful TryUpgrade the
+// upgrade is scheduled.
+func (s *Server) SetUpgradeDelay(blocks int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.upgradeDelay = blocks
+}
+
+// AppVersion returns the app version currently reported in block headers.
+func (s *Server) AppVersion() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.appVersion
+}
+
+// SetVestingAccount makes address a continuous vesting account, as genesis
+// allocations often are, with the given account number and sequence.
+func (s *Server) SetVestingAccount(address string, number, sequence uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accounts[address] = &authtypes.BaseAccount{Address: address, AccountNumber: number, Sequence: sequence}
+	s.vesting[address] = true
+}
+
+// SetNodeVersion sets the celestia-app release and git commit GetNodeInfo
+// reports.
+func (s *Server) SetNodeVersion(version, gitCommit string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nodeVersion = version
+	s.gitCommit = gitCommit
+}
+
+// SetSyncing sets whether the node reports it is catching up.
+func (s *Server) SetSyncing(syncing bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.syncing = syncing
+}
+
+// SetMinGasPrice sets the minimum gas price the node's Config reports,
+// such as 0.002000000000000000utia. Empty means none.
+func (s *Server) SetMinGasPrice(price string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.minGasPrice = price
+}
+
+// SetValidator adds or updates a validator.
+func (s *Server) SetValidator(v Validator) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.validators[v.Address] = &v
+}
+
+// Signal records a validator signalling for version.
+func (s *Server) Signal(address string, version uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.signals[address] = version
+}
+
+// SetTally sets the voting power behind version directly, replacing any
+// validators. The rest of total is held by a validator that hasn't
+// signalled.
+func (s *Server) SetTally(version uint64, power, total int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.validators = map[string]*Validator{
+		"signalled": {Address: "signalled", Moniker: "signalled", Power: power},
+		"silent":    {Address: "silent", Moniker: "silent", Power: total - power},
+	}
+	s.signals = map[string]uint64{"signalled": version}
+}
+
+// ScheduleUpgrade schedules an upgrade to version at height, as a
+// successful MsgTryUpgrade would.
+func (s *Server) ScheduleUpgrade(version uint64, height int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.upgrade = &signaltypes.Upgrade{AppVersion: version, UpgradeHeight: height}
+}
+
+// ClearUpgrade removes any scheduled upgrade, e.g. once it has happened.
+func (s *Server) ClearUpgrade() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.upgrade = nil
+}
+
+// SetError makes every call to method fail with err until it is set back
+// to nil. An empty method fails every call.
+func (s *Server) SetError(method string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		delete(s.errors, method)
+		return
+	}
+	s.errors[method] = err
+}
+
+// SetLatency delays every call by d.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// Threshold returns the power needed to upgrade out of total, which is 5/6
+// rounded up as in celestia-app.
+func Threshold(total int64) int64 {
+	return (total*5 + 5) / 6
+}
+
+// begin applies any latency or injected error for method and returns the
+// height the call is served at.
+func (s *Server) begin(ctx context.Context, method string) (int64, error) {
+	s.mu.Lock()
+	latency := s.latency
+	err := s.errors[method]
+	if err == nil {
+		err = s.errors[""]
+	}
+	height := s.height
+	s.mu.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-ctx.Done():
+			return 0, status.FromContextError(ctx.Err()).Err()
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(blockHeightHeader); len(values) > 0 {
+		requested, err := strconv.ParseInt(values[0], 10, 64)
+		if err != nil {
+			return 0, status.Errorf(codes.InvalidArgument, "invalid height %q", values[0])
+		}
+		if requested > height {
+			return 0, status.Errorf(codes.InvalidArgument, "cannot query with height in the future; please provide a valid height")
+		}
+		if requested > 0 {
+			height = requested
+		}
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(blockHeightHeader, strconv.FormatInt(height, 10)))
+	return height, nil
+}
+
+// VersionTally implements signaltypes.QueryServer.
+func (s *Server) VersionTally(ctx context.Context, req *signaltypes.QueryVersionTallyRequest) (*signaltypes.QueryVersionTallyResponse, error) {
+	if _, err := s.begin(ctx, MethodVersionTally); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp := &signaltypes.QueryVersionTallyResponse{}
+	for addr, v := range s.validators {
+		resp.TotalVotingPower += uint64(v.Power)
+		if version, ok := s.signals[addr]; ok && version == req.GetVersion() {
+			resp.VotingPower += uint64(v.Power)
+		}
+	}
+	resp.ThresholdPower = uint64(Threshold(int64(resp.TotalVotingPower)))
+	return resp, nil
+}
+
+// GetUpgrade implements signaltypes.QueryServer.
+func (s *Server) GetUpgrade(ctx context.Context, _ *signaltypes.QueryGetUpgradeRequest) (*signaltypes.QueryGetUpgradeResponse, error) {
+	if _, err := s.begin(ctx, MethodGetUpgrade); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.upgrade == nil {
+		return &signaltypes.QueryGetUpgradeResponse{}, nil
+	}
+	return &signaltypes.QueryGetUpgradeResponse{Upgrade: proto.Clone(s.upgrade).(*signaltypes.Upgrade)}, nil
+}
+
+func (s *Server) header(height int64) *cmtservice.Header {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &cmtservice.Header{
+		Version: &cmtversion.Consensus{Block: 11, App: s.appVersion},
+		ChainId: s.chainID,
+		Height:  height,
+		Time:    timestamppb.New(s.genesisTime.Add(time.Duration(height) * s.blockTime)),
+	}
+}
+
+type tendermintServer struct {
+	cmtservice.UnimplementedServiceServer
+	s *Server
+}
+
+func (t tendermintServer) GetLatestBlock(ctx context.Context, _ *cmtservice.GetLatestBlockRequest) (*cmtservice.GetLatestBlockResponse, error) {
+	height, err := t.s.begin(ctx, MethodGetLatestBlock)
+	if err != nil {
+		return nil, err
+	}
+	return &cmtservice.GetLatestBlockResponse{SdkBlock: &cmtservice.Block{Header: t.s.header(height)}}, nil
+}
+
+func (t tendermintServer) GetBlockByHeight(ctx context.Context, req *cmtservice.GetBlockByHeightRequest) (*cmtservice.GetBlockByHeightResponse, error) {
+	latest, err := t.s.begin(ctx, MethodGetBlockByHeight)
+	if err != nil {
+		return nil, err
+	}
+	if req.GetHeight() > latest {
+		return nil, status.Errorf(codes.InvalidArgument, "requested block height %d is greater than the latest height %d", req.GetHeight(), latest)
+	}
+	return &cmtservice.GetBlockByHeightResponse{SdkBlock: &cmtservice.Block{Header: t.s.header(req.GetHeight())}}, nil
+}
+
+func (t tendermintServer) GetNodeInfo(ctx context.Context, _ *cmtservice.GetNodeInfoRequest) (*cmtservice.GetNodeInfoResponse, error) {
+	if _, err := t.s.begin(ctx, MethodGetNodeInfo); err != nil {
+		return nil, err
+	}
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+	return &cmtservice.GetNodeInfoResponse{
+		DefaultNodeInfo: &p2p.DefaultNodeInfo{Network: t.s.chainID, Moniker: "fake", Version: "0.38.17"},
+		ApplicationVersion: &cmtservice.VersionInfo{
+			Name:             "celestia-app",
+			AppName:          "celestia-appd",
+			Version:          t.s.nodeVersion,
+			GitCommit:        t.s.gitCommit,
+			CosmosSdkVersion: "v0.50.13",
+		},
+	}, nil
+}
+
+func (t tendermintServer) GetSyncing(ctx context.Context, _ *cmtservice.GetSyncingRequest) (*cmtservice.GetSyncingResponse, error) {
+	if _, err := t.s.begin(ctx, MethodGetSyncing); err != nil {
+		return nil, err
+	}
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+	return &cmtservice.GetSyncingResponse{Syncing: t.s.syncing}, nil
+}
+
+type nodeServer struct {
+	nodetypes.UnimplementedServiceServer
+	s *Server
+}
+
+func (n nodeServer) Config(ctx context.Context, _ *nodetypes.ConfigRequest) (*nodetypes.ConfigResponse, error) {
+	if _, err := n.s.begin(ctx, MethodConfig); err != nil {
+		return nil, err
+	}
+	n.s.mu.Lock()
+	defer n.s.mu.Unlock()
+	return &nodetypes.ConfigResponse{MinimumGasPrice: n.s.minGasPrice}, nil
+}
+
+type stakingServer struct {
+	stakingtypes.UnimplementedQueryServer
+	s *Server
+}
+
+func (st stakingServer) Validator(ctx context.Context, req *stakingtypes.QueryValidatorRequest) (*stakingtypes.QueryValidatorResponse, error) {
+	if _, err := st.s.begin(ctx, MethodValidator); err != nil {
+		return nil, err
+	}
+	st.s.mu.Lock()
+	defer st.s.mu.Unlock()
+	v, ok := st.s.validators[req.GetValidatorAddr()]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "validator %s not found", req.GetValidatorAddr())
+	}
+	return &stakingtypes.QueryValidatorResponse{Validator: &stakingtypes.Validator{
+		OperatorAddress: v.Address,
+		Description:     &stakingtypes.Description{Moniker: v.Moniker},
+		Status:          stakingtypes.BondStatus_BOND_STATUS_BONDED,
+		Tokens:          strconv.FormatInt(v.Power*1_000_000, 10),
+	}}, nil
+}
+
+// Register adds the fake services to a gRPC server.
+func (s *Server) Register(g *grpc.Server) {
+	signaltypes.RegisterQueryServer(g, s)
+	cmtservice.RegisterServiceServer(g, tendermintServer{s: s})
+	nodetypes.RegisterServiceServer(g, nodeServer{s: s})
+	stakingtypes.RegisterQueryServer(g, stakingServer{s: s})
+	authtypes.RegisterQueryServer(g, authServer{s: s})
+	txtypes.RegisterServiceServer(g, txServer{s: s})
+	authztypes.RegisterQueryServer(g, authzServer{s: s})
+}
+
+// Listen serves the fake on a local TCP address such as 127.0.0.1:0 and
+// returns the address it listens on and a function that stops it.
+func (s *Server) Listen(addr string) (string, func(), error) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	g := grpc.NewServer()
+	s.Register(g)
+	go g.Serve(lis)
+	return lis.Addr().String(), g.Stop, nil
+}
+
+// Bufconn serves the fake over an in-memory connection and returns a
+// client connection to it and a function that stops both.
+func (s *Server) Bufconn() (*grpc.ClientConn, func(), error) {
+	lis := bufconn.Listen(1 << 20)
+	g := grpc.NewServer()
+	s.Register(g)
+	go g.Serve(lis)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		g.Stop()
+		return nil, nil, err
+	}
+	return conn, func() { conn.Close(); g.Stop() }, nil
+}
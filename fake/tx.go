@@ -0,0 +1,265 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	signaltypes "celestia-upgrade-monitor/celestia/signal/v1"
+
+	authtypes "cosmossdk.io/api/cosmos/auth/v1beta1"
+	authztypes "cosmossdk.io/api/cosmos/authz/v1beta1"
+	abcitypes "cosmossdk.io/api/cosmos/base/abci/v1beta1"
+	txtypes "cosmossdk.io/api/cosmos/tx/v1beta1"
+	vestingtypes "cosmossdk.io/api/cosmos/vesting/v1beta1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// simulatedGas is the gas every simulated transaction uses.
+const simulatedGas = 80_000
+
+// Message type URLs the fake executes.
+const (
+	typeMsgSignalVersion = "/celestia.signal.v1.MsgSignalVersion"
+	typeMsgTryUpgrade    = "/celestia.signal.v1.MsgTryUpgrade"
+)
+
+type authServer struct {
+	authtypes.UnimplementedQueryServer
+	s *Server
+}
+
+// Account returns a base account for any address, numbered in the order
+// they are first seen, or the vesting account set for it.
+func (a authServer) Account(ctx context.Context, req *authtypes.QueryAccountRequest) (*authtypes.QueryAccountResponse, error) {
+	if _, err := a.s.begin(ctx, MethodAccount); err != nil {
+		return nil, err
+	}
+	a.s.mu.Lock()
+	account, ok := a.s.accounts[req.GetAddress()]
+	if !ok {
+		account = &authtypes.BaseAccount{Address: req.GetAddress(), AccountNumber: uint64(len(a.s.accounts) + 1)}
+		a.s.accounts[req.GetAddress()] = account
+	}
+	var msg proto.Message = account
+	if a.s.vesting[req.GetAddress()] {
+		msg = &vestingtypes.ContinuousVestingAccount{BaseVestingAccount: &vestingtypes.BaseVestingAccount{BaseAccount: account}}
+	}
+	packed, err := anypb.New(msg)
+	a.s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	packed.TypeUrl = "/" + string(msg.ProtoReflect().Descriptor().FullName())
+	return &authtypes.QueryAccountResponse{Account: packed}, nil
+}
+
+type txServer struct {
+	txtypes.UnimplementedServiceServer
+	s *Server
+}
+
+// Simulate reports the same gas for every transaction.
+func (t txServer) Simulate(ctx context.Context, req *txtypes.SimulateRequest) (*txtypes.SimulateResponse, error) {
+	if _, err := t.s.begin(ctx, MethodSimulate); err != nil {
+		return nil, err
+	}
+	if _, err := decodeTx(req.GetTxBytes()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &txtypes.SimulateResponse{
+		GasInfo: &abcitypes.GasInfo{GasUsed: simulatedGas},
+		Result:  &abcitypes.Result{},
+	}, nil
+}
+
+// BroadcastTx executes a transaction's signal messages straight away and
+// includes it at the current height. Signatures, fees and sequences
+// aren't checked.
+func (t txServer) BroadcastTx(ctx context.Context, req *txtypes.BroadcastTxRequest) (*txtypes.BroadcastTxResponse, error) {
+	height, err := t.s.begin(ctx, MethodBroadcastTx)
+	if err != nil {
+		return nil, err
+	}
+	hash := txHash(req.GetTxBytes())
+	body, err := decodeTx(req.GetTxBytes())
+	if err != nil {
+		return &txtypes.BroadcastTxResponse{TxResponse: &abcitypes.TxResponse{Txhash: hash, Code: 2, RawLog: err.Error()}}, nil
+	}
+	if err := t.s.execute(body.GetMessages()); err != nil {
+		return &txtypes.BroadcastTxResponse{TxResponse: &abcitypes.TxResponse{Txhash: hash, Code: 1, RawLog: err.Error()}}, nil
+	}
+
+	result := &abcitypes.TxResponse{Height: height, Txhash: hash}
+	t.s.mu.Lock()
+	t.s.txs[hash] = result
+	t.s.mu.Unlock()
+	t.s.index(hash, body, height)
+	for _, msg := range body.GetMessages() {
+		t.s.publishRawTx(msg.GetTypeUrl(), req.GetTxBytes(), height)
+	}
+	return &txtypes.BroadcastTxResponse{TxResponse: &abcitypes.TxResponse{Txhash: hash}}, nil
+}
+
+// GetTx returns a broadcast transaction.
+func (t txServer) GetTx(ctx context.Context, req *txtypes.GetTxRequest) (*txtypes.GetTxResponse, error) {
+	if _, err := t.s.begin(ctx, MethodGetTx); err != nil {
+		return nil, err
+	}
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+	result, ok := t.s.txs[req.GetHash()]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "tx not found: %s", req.GetHash())
+	}
+	return &txtypes.GetTxResponse{TxResponse: proto.Clone(result).(*abcitypes.TxResponse)}, nil
+}
+
+// GetTxsEvent supports queries made of message.action and message.sender
+// conditions joined by AND. The sender of a message is the account of the
+// validator it signals for, its signer or its grantee.
+func (t txServer) GetTxsEvent(ctx context.Context, req *txtypes.GetTxsEventRequest) (*txtypes.GetTxsEventResponse, error) {
+	if _, err := t.s.begin(ctx, MethodGetTxsEvent); err != nil {
+		return nil, err
+	}
+	conditions := map[string]string{}
+	for _, m := range queryCondition.FindAllStringSubmatch(req.GetQuery(), -1) {
+		conditions[m[1]] = m[2]
+	}
+	if len(conditions) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported query %q", req.GetQuery())
+	}
+
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+	var resp txtypes.GetTxsEventResponse
+	for i := len(t.s.included) - 1; i >= 0; i-- {
+		if req.GetLimit() > 0 && uint64(len(resp.Txs)) >= req.GetLimit() {
+			break
+		}
+		tx := t.s.included[i]
+		if !matchesQuery(tx.body, conditions) {
+			continue
+		}
+		resp.Txs = append(resp.Txs, &txtypes.Tx{Body: tx.body})
+		resp.TxResponses = append(resp.TxResponses, &abcitypes.TxResponse{Height: tx.height, Txhash: tx.hash})
+	}
+	return &resp, nil
+}
+
+var queryCondition = regexp.MustCompile(`(message\.action|message\.sender)\s*=\s*'([^']*)'`)
+
+// includedTx is a transaction the fake has included in a block.
+type includedTx struct {
+	hash   string
+	height int64
+	body   *txtypes.TxBody
+}
+
+// index makes a transaction searchable with GetTxsEvent.
+func (s *Server) index(hash string, body *txtypes.TxBody, height int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.included = append(s.included, includedTx{hash: hash, height: height, body: body})
+}
+
+func matchesQuery(body *txtypes.TxBody, conditions map[string]string) bool {
+	for _, msg := range body.GetMessages() {
+		if action, ok := conditions["message.action"]; ok && msg.GetTypeUrl() != action {
+			continue
+		}
+		if sender, ok := conditions["message.sender"]; ok && messageSender(msg) != sender {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func messageSender(msg *anypb.Any) string {
+	switch msg.GetTypeUrl() {
+	case typeMsgSignalVersion:
+		var signal signaltypes.MsgSignalVersion
+		if proto.Unmarshal(msg.GetValue(), &signal) != nil {
+			return ""
+		}
+		account, _ := accountOf(signal.GetValidatorAddress())
+		return account
+	case typeMsgTryUpgrade:
+		var try signaltypes.MsgTryUpgrade
+		proto.Unmarshal(msg.GetValue(), &try)
+		return try.GetSigner()
+	case typeMsgExec:
+		var exec authztypes.MsgExec
+		proto.Unmarshal(msg.GetValue(), &exec)
+		return exec.GetGrantee()
+	}
+	return ""
+}
+
+func decodeTx(tx []byte) (*txtypes.TxBody, error) {
+	var raw txtypes.TxRaw
+	if err := proto.Unmarshal(tx, &raw); err != nil {
+		return nil, fmt.Errorf("invalid tx: %w", err)
+	}
+	var body txtypes.TxBody
+	if err := proto.Unmarshal(raw.GetBodyBytes(), &body); err != nil {
+		return nil, fmt.Errorf("invalid tx body: %w", err)
+	}
+	if len(body.GetMessages()) == 0 {
+		return nil, fmt.Errorf("tx has no messages")
+	}
+	return &body, nil
+}
+
+// execute applies signal messages to the fake's state, unwrapping those
+// sent through MsgExec. Unknown validators, missing grants and message
+// types fail the whole transaction.
+func (s *Server) execute(msgs []*anypb.Any) error {
+	var unwrapped []*anypb.Any
+	for _, msg := range msgs {
+		if msg.GetTypeUrl() != typeMsgExec {
+			unwrapped = append(unwrapped, msg)
+			continue
+		}
+		inner, err := s.unwrapExec(msg)
+		if err != nil {
+			return err
+		}
+		unwrapped = append(unwrapped, inner...)
+	}
+	msgs = unwrapped
+
+	for _, msg := range msgs {
+		switch msg.GetTypeUrl() {
+		case typeMsgSignalVersion:
+			var signal signaltypes.MsgSignalVersion
+			if err := proto.Unmarshal(msg.GetValue(), &signal); err != nil {
+				return err
+			}
+			s.mu.Lock()
+			_, ok := s.validators[signal.GetValidatorAddress()]
+			s.mu.Unlock()
+			if !ok {
+				return fmt.Errorf("validator %s not found", signal.GetValidatorAddress())
+			}
+		case typeMsgTryUpgrade:
+		default:
+			return fmt.Errorf("unsupported message %s", msg.GetTypeUrl())
+		}
+	}
+	for _, msg := range msgs {
+		switch msg.GetTypeUrl() {
+		case typeMsgSignalVersion:
+			var signal signaltypes.MsgSignalVersion
+			proto.Unmarshal(msg.GetValue(), &signal)
+			s.Signal(signal.GetValidatorAddress(), signal.GetVersion())
+		case typeMsgTryUpgrade:
+			s.tryUpgrade()
+		}
+	}
+	return nil
+}
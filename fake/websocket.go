@@ -0,0 +1,212 @@
+package fake
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	signaltypes "celestia-upgrade-monitor/celestia/signal/v1"
+
+	txtypes "cosmossdk.io/api/cosmos/tx/v1beta1"
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// wsClient is a websocket connection and the queries it subscribed to.
+type wsClient struct {
+	conn *websocket.Conn
+
+	mu      sync.Mutex
+	queries map[string]string
+}
+
+func (c *wsClient) send(v any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	c.conn.WriteJSON(v)
+}
+
+// WebsocketHandler serves a CometBFT-style /websocket endpoint that
+// supports subscribe and publishes NewBlock and signal Tx events.
+func (s *Server) WebsocketHandler() http.Handler {
+	upgrader := websocket.Upgrader{}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		client := &wsClient{conn: conn, queries: map[string]string{}}
+		s.wsMu.Lock()
+		s.wsClients[client] = struct{}{}
+		s.wsMu.Unlock()
+		defer func() {
+			s.wsMu.Lock()
+			delete(s.wsClients, client)
+			s.wsMu.Unlock()
+		}()
+
+		for {
+			var req struct {
+				ID     string         `json:"id"`
+				Method string         `json:"method"`
+				Params map[string]any `json:"params"`
+			}
+			if err := conn.ReadJSON(&req); err != nil {
+				return
+			}
+			if req.Method != "subscribe" {
+				client.send(map[string]any{"jsonrpc": "2.0", "id": req.ID, "error": map[string]any{"code": -32601, "message": "Method not found"}})
+				continue
+			}
+			query, _ := req.Params["query"].(string)
+			client.mu.Lock()
+			client.queries[req.ID] = query
+			client.mu.Unlock()
+			client.send(map[string]any{"jsonrpc": "2.0", "id": req.ID, "result": map[string]any{}})
+		}
+	})
+}
+
+// publish sends an event to every subscription whose query matches.
+func (s *Server) publish(match func(query string) bool, eventType string, value any, events map[string][]string) {
+	s.wsMu.Lock()
+	clients := make([]*wsClient, 0, len(s.wsClients))
+	for c := range s.wsClients {
+		clients = append(clients, c)
+	}
+	s.wsMu.Unlock()
+
+	for _, c := range clients {
+		c.mu.Lock()
+		var ids []string
+		var queries []string
+		for id, query := range c.queries {
+			if match(query) {
+				ids = append(ids, id)
+				queries = append(queries, query)
+			}
+		}
+		c.mu.Unlock()
+		for i, id := range ids {
+			c.send(map[string]any{
+				"jsonrpc": "2.0",
+				"id":      id + "#event",
+				"result": map[string]any{
+					"query":  queries[i],
+					"data":   map[string]any{"type": eventType, "value": value},
+					"events": events,
+				},
+			})
+		}
+	}
+}
+
+func (s *Server) publishBlock(height int64) {
+	h := s.header(height)
+	header := map[string]any{
+		"version":  map[string]string{"block": strconv.FormatUint(h.Version.Block, 10), "app": strconv.FormatUint(h.Version.App, 10)},
+		"chain_id": h.ChainId,
+		"height":   strconv.FormatInt(height, 10),
+		"time":     h.Time.AsTime(),
+	}
+	s.publish(func(query string) bool {
+		return strings.Contains(query, "tm.event='NewBlock'")
+	}, "tendermint/event/NewBlock", map[string]any{"block": map[string]any{"header": header}}, map[string][]string{
+		"tm.event": {"NewBlock"},
+	})
+}
+
+// publishTx publishes a successful transaction carrying msg.
+func (s *Server) publishTx(typeURL string, msg proto.Message) error {
+	value, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	body, err := proto.Marshal(&txtypes.TxBody{Messages: []*anypb.Any{{TypeUrl: typeURL, Value: value}}})
+	if err != nil {
+		return err
+	}
+	tx, err := proto.Marshal(&txtypes.TxRaw{BodyBytes: body})
+	if err != nil {
+		return err
+	}
+	height := s.Height()
+	s.index(txHash(tx), &txtypes.TxBody{Messages: []*anypb.Any{{TypeUrl: typeURL, Value: value}}}, height)
+	s.publishRawTx(typeURL, tx, height)
+	return nil
+}
+
+// publishRawTx publishes a successful transaction included at height.
+func (s *Server) publishRawTx(typeURL string, tx []byte, height int64) {
+	hash := txHash(tx)
+
+	s.publish(func(query string) bool {
+		if !strings.Contains(query, "tm.event='Tx'") {
+			return false
+		}
+		return !strings.Contains(query, "message.action=") || strings.Contains(query, "message.action='"+typeURL+"'")
+	}, "tendermint/event/Tx", map[string]any{
+		"TxResult": map[string]any{
+			"height": strconv.FormatInt(height, 10),
+			"tx":     tx,
+			"result": map[string]any{"code": 0},
+		},
+	}, map[string][]string{
+		"tm.event":       {"Tx"},
+		"tx.hash":        {hash},
+		"tx.height":      {strconv.FormatInt(height, 10)},
+		"message.action": {typeURL},
+	})
+}
+
+// SignalVersion records a validator signalling for version and publishes
+// the MsgSignalVersion transaction.
+func (s *Server) SignalVersion(address string, version uint64) error {
+	s.Signal(address, version)
+	return s.publishTx(typeMsgSignalVersion, &signaltypes.MsgSignalVersion{ValidatorAddress: address, Version: version})
+}
+
+// TryUpgrade publishes a MsgTryUpgrade transaction and, if some version
+// has reached the threshold, schedules the upgrade to it. It reports
+// whether an upgrade was scheduled.
+func (s *Server) TryUpgrade(signer string) (bool, error) {
+	scheduled := s.tryUpgrade()
+	return scheduled, s.publishTx(typeMsgTryUpgrade, &signaltypes.MsgTryUpgrade{Signer: signer})
+}
+
+// tryUpgrade schedules an upgrade to any version that has reached the
+// threshold and reports whether it did.
+func (s *Server) tryUpgrade() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var total int64
+	powers := map[uint64]int64{}
+	for addr, v := range s.validators {
+		total += v.Power
+		if version, ok := s.signals[addr]; ok {
+			powers[version] += v.Power
+		}
+	}
+	scheduled := false
+	if s.upgrade == nil {
+		for version, power := range powers {
+			if version > s.appVersion && power >= Threshold(total) {
+				s.upgrade = &signaltypes.Upgrade{AppVersion: version, UpgradeHeight: s.height + s.upgradeDelay}
+				scheduled = true
+			}
+		}
+	}
+	return scheduled
+}
+
+func txHash(tx []byte) string {
+	sum := sha256.Sum256(tx)
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fleet node roles
+const (
+	roleValidator = "validator"
+	roleSentry    = "sentry"
+	roleRPC       = "rpc"
+)
+
+// fleetNodeConfig is one of our celestia-appd instances in the -config
+// file. grpc takes the same forms as -grpc-addr; rpc, a CometBFT RPC
+// address, is used when grpc is empty.
+type fleetNodeConfig struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+	GRPC string `json:"grpc,omitempty"`
+	RPC  string `json:"rpc,omitempty"`
+}
+
+// fleetNode is a node from the inventory and the endpoint it is queried on.
+type fleetNode struct {
+	name     string
+	role     string
+	endpoint *endpoint
+}
+
+// configFleet turns the fleet inventory in the config file into nodes.
+func configFleet(cfg fileConfig) ([]fleetNode, error) {
+	var nodes []fleetNode
+	seen := map[string]bool{}
+	for _, nc := range cfg.Fleet {
+		if nc.Name == "" {
+			return nil, fmt.Errorf("fleet node without a name")
+		}
+		if seen[nc.Name] {
+			return nil, fmt.Errorf("fleet node %s listed twice", nc.Name)
+		}
+		seen[nc.Name] = true
+		switch nc.Role {
+		case roleValidator, roleSentry, roleRPC:
+		default:
+			return nil, fmt.Errorf("fleet node %s: role must be %s, %s or %s", nc.Name, roleValidator, roleSentry, roleRPC)
+		}
+		addr := nc.GRPC
+		if addr == "" {
+			if nc.RPC == "" {
+				return nil, fmt.Errorf("fleet node %s: grpc or rpc must be set", nc.Name)
+			}
+			addr = "comet+" + nc.RPC
+		}
+		parsed, err := parseEndpointAddress(addr)
+		if err != nil {
+			return nil, fmt.Errorf("fleet node %s: %w", nc.Name, err)
+		}
+		nodes = append(nodes, fleetNode{name: nc.Name, role: nc.Role, endpoint: &endpoint{address: parsed}})
+	}
+	return nodes, nil
+}
+
+// Fleet checks, the columns of the readiness matrix
+const (
+	checkReachable = "reachable"
+	checkSynced    = "synced"
+	checkChainID   = "chain_id"
+	checkUpgrade   = "upgrade"
+)
+
+var fleetChecks = []string{checkReachable, checkSynced, checkChainID, checkUpgrade}
+
+// fleetNodeStatus is one row of the fleet readiness matrix.
+type fleetNodeStatus struct {
+	Name       string          `json:"name"`
+	Role       string          `json:"role"`
+	Address    string          `json:"address"`
+	ChainID    string          `json:"chain_id,omitempty"`
+	Height     int64           `json:"height,omitempty"`
+	LagBlocks  int64           `json:"lag_blocks"`
+	Syncing    bool            `json:"syncing"`
+	AppVersion uint64          `json:"app_version,omitempty"`
+	Version    string          `json:"version,omitempty"`
+	GitCommit  string          `json:"git_commit,omitempty"`
+	Checks     map[string]bool `json:"checks"`
+	Ready      bool            `json:"ready"`
+	Problems   []string        `json:"problems,omitempty"`
+}
+
+// fleetReport is the /fleet response.
+type fleetReport struct {
+	ChainID          string            `json:"chain_id"`
+	Height           int64             `json:"height"`
+	TargetAppVersion uint64            `json:"target_app_version"`
+	UpgradeHeight    int64             `json:"upgrade_height,omitempty"`
+	Ready            bool              `json:"ready"`
+	ReadyNodes       int               `json:"ready_nodes"`
+	Nodes            []fleetNodeStatus `json:"nodes"`
+}
+
+// fleetChecker collects the state of every node in the inventory each poll
+// and alerts when a node starts failing a check.
+type fleetChecker struct {
+	nodes       []fleetNode
+	minReleases map[uint64]string
+	maxLag      int64
+	alertBlocks int64
+
+	mu     sync.Mutex
+	report fleetReport
+	// failing holds the checks each node failed at the last update, and the
+	// severity they were reported with
+	failing map[string]map[string]string
+}
+
+// fleet is set when the config file has a fleet inventory, nil otherwise.
+var fleet *fleetChecker
+
+func newFleetChecker(nodes []fleetNode, minReleases map[uint64]string, maxLag, alertBlocks int64) *fleetChecker {
+	return &fleetChecker{
+		nodes:       nodes,
+		minReleases: minReleases,
+		maxLag:      maxLag,
+		alertBlocks: alertBlocks,
+		failing:     map[string]map[string]string{},
+	}
+}
+
+// collect queries one node. Checks that need the whole fleet are left to
+// evaluate.
+func (f *fleetChecker) collect(n fleetNode) (fleetNodeStatus, error) {
+	s := fleetNodeStatus{Name: n.name, Role: n.role, Address: n.endpoint.address.String(), Checks: map[string]bool{}}
+	b, err := n.endpoint.client()
+	if err != nil {
+		return s, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	block, err := b.Block(ctx, 0)
+	if err != nil {
+		return s, err
+	}
+	s.ChainID = block.ChainID
+	s.Height = block.Height
+	s.AppVersion = block.AppVersion
+	if s.Syncing, err = b.Syncing(ctx); err != nil {
+		return s, err
+	}
+	info, err := b.NodeInfo(ctx)
+	if err != nil {
+		return s, err
+	}
+	s.Version = info.GetApplicationVersion().GetVersion()
+	s.GitCommit = info.GetApplicationVersion().GetGitCommit()
+	return s, nil
+}
+
+// evaluate fills in the checks for a node given the network state and the
+// highest height seen across the fleet.
+func (f *fleetChecker) evaluate(s *fleetNodeStatus, err error, data UpgradeData, bestHeight int64) {
+	s.Checks[checkReachable] = err == nil
+	if err != nil {
+		s.Problems = append(s.Problems, "unreachable: "+err.Error())
+		s.Checks[checkSynced] = false
+		s.Checks[checkChainID] = false
+		s.Checks[checkUpgrade] = false
+		return
+	}
+
+	s.Checks[checkChainID] = s.ChainID == data.ChainID
+	if !s.Checks[checkChainID] {
+		s.Problems = append(s.Problems, fmt.Sprintf("on chain %s, not %s", s.ChainID, data.ChainID))
+	}
+
+	s.LagBlocks = max(bestHeight-s.Height, 0)
+	s.Checks[checkSynced] = !s.Syncing && s.LagBlocks <= f.maxLag
+	if s.Syncing {
+		s.Problems = append(s.Problems, "catching up")
+	} else if s.LagBlocks > f.maxLag {
+		s.Problems = append(s.Problems, fmt.Sprintf("%d blocks behind", s.LagBlocks))
+	}
+
+	target := targetAppVersion(data)
+	if s.AppVersion >= target {
+		// Already past it
+		s.Checks[checkUpgrade] = true
+		return
+	}
+	ok, _, reason := checkRelease(s.Version, target, f.minReleases)
+	s.Checks[checkUpgrade] = ok
+	if !ok {
+		s.Problems = append(s.Problems, reason)
+	}
+}
+
+// update refreshes the readiness matrix from a poll result. Nodes are
+// queried in parallel.
+func (f *fleetChecker) update(data UpgradeData) {
+	statuses := make([]fleetNodeStatus, len(f.nodes))
+	errs := make([]error, len(f.nodes))
+	var wg sync.WaitGroup
+	for i, n := range f.nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses[i], errs[i] = f.collect(n)
+		}()
+	}
+	wg.Wait()
+
+	bestHeight := data.Height
+	for i, s := range statuses {
+		if errs[i] == nil && s.ChainID == data.ChainID {
+			bestHeight = max(bestHeight, s.Height)
+		}
+	}
+
+	report := fleetReport{
+		ChainID:          data.ChainID,
+		Height:           bestHeight,
+		TargetAppVersion: targetAppVersion(data),
+		UpgradeHeight:    data.UpgradeData.Upgrade.UpgradeHeight,
+		Ready:            true,
+	}
+	for i := range statuses {
+		s := &statuses[i]
+		f.evaluate(s, errs[i], data, bestHeight)
+		s.Ready = true
+		for _, check := range fleetChecks {
+			passed := s.Checks[check]
+			s.Ready = s.Ready && passed
+			value := 0.0
+			if passed {
+				value = 1
+			}
+			fleetNodeCheck.WithLabelValues(s.Name, s.Role, check).Set(value)
+		}
+		if errs[i] == nil {
+			fleetNodeHeight.WithLabelValues(s.Name, s.Role).Set(float64(s.Height))
+			fleetNodeLag.WithLabelValues(s.Name, s.Role).Set(float64(s.LagBlocks))
+			fleetNodeAppVersion.WithLabelValues(s.Name, s.Role).Set(float64(s.AppVersion))
+		}
+		if s.Ready {
+			report.ReadyNodes++
+		} else {
+			report.Ready = false
+		}
+		f.alert(data, *s)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Role != statuses[j].Role {
+			return statuses[i].Role < statuses[j].Role
+		}
+		return statuses[i].Name < statuses[j].Name
+	})
+	report.Nodes = statuses
+
+	f.mu.Lock()
+	f.report = report
+	f.mu.Unlock()
+}
+
+// severity is how urgent a failed check is. Not being able to follow the
+// upgrade only matters once one is scheduled, and becomes critical within
+// alertBlocks of it.
+func (f *fleetChecker) severity(check string, data UpgradeData) string {
+	if check != checkUpgrade {
+		return severityWarning
+	}
+	upgrade := data.UpgradeData.Upgrade
+	switch {
+	case upgrade.UpgradeHeight == 0:
+		return ""
+	case upgrade.UpgradeHeight-data.Height <= f.alertBlocks:
+		return severityCritical
+	default:
+		return severityWarning
+	}
+}
+
+// alert emits an event for each check a node newly fails, or fails more
+// urgently, and for each it recovers from.
+func (f *fleetChecker) alert(data UpgradeData, s fleetNodeStatus) {
+	f.mu.Lock()
+	previous := f.failing[s.Name]
+	current := map[string]string{}
+	for _, check := range fleetChecks {
+		if !s.Checks[check] {
+			if severity := f.severity(check, data); severity != "" {
+				current[check] = severity
+			}
+		}
+	}
+	f.failing[s.Name] = current
+	f.mu.Unlock()
+
+	fields := map[string]string{
+		"node":    s.Name,
+		"role":    s.Role,
+		"address": s.Address,
+		"height":  strconv.FormatInt(s.Height, 10),
+		"version": s.Version,
+	}
+	for _, check := range fleetChecks {
+		severity, failing := current[check]
+		was, wasFailing := previous[check]
+		switch {
+		case failing && (!wasFailing || severity == severityCritical && was != severityCritical):
+			emitEvent(Event{
+				Kind:     "fleet_node_check_failed",
+				Severity: severity,
+				Message:  fmt.Sprintf("%s %s failed the %s check: %s", s.Role, s.Name, check, strings.Join(s.Problems, "; ")),
+				Height:   data.Height,
+				Fields:   withField(fields, "check", check),
+			})
+		case !failing && wasFailing:
+			emitEvent(Event{
+				Kind:    "fleet_node_check_recovered",
+				Message: fmt.Sprintf("%s %s passes the %s check again", s.Role, s.Name, check),
+				Height:  data.Height,
+				Fields:  withField(fields, "check", check),
+			})
+		}
+	}
+}
+
+// withField returns a copy of fields with key set to value.
+func withField(fields map[string]string, key, value string) map[string]string {
+	result := make(map[string]string, len(fields)+1)
+	for k, v := range fields {
+		result[k] = v
+	}
+	result[key] = value
+	return result
+}
+
+// snapshot returns the latest readiness matrix.
+func (f *fleetChecker) snapshot() fleetReport {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.report
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// snapshot is the result of the most recent successful poll.
+var snapshot = struct {
+	sync.Mutex
+	data UpgradeData
+	time time.Time
+}{}
+
+func setSnapshot(data UpgradeData) {
+	snapshot.Lock()
+	defer snapshot.Unlock()
+	snapshot.data = data
+	snapshot.time = time.Now()
+}
+
+// getSnapshot returns the latest snapshot and when it was taken. The time is
+// zero if no poll has succeeded yet.
+func getSnapshot() (UpgradeData, time.Time) {
+	snapshot.Lock()
+	defer snapshot.Unlock()
+	return snapshot.data, snapshot.time
+}
+
+// Liveness and readiness settings
+var (
+	ReadyMaxAge    = time.Hour
+	LivenessMaxAge = 2 * time.Hour
+
+	lastPollAttempt = struct {
+		sync.Mutex
+		time time.Time
+	}{time: time.Now()}
+)
+
+func markPollAttempt() {
+	lastPollAttempt.Lock()
+	defer lastPollAttempt.Unlock()
+	lastPollAttempt.time = time.Now()
+}
+
+type networkHealth struct {
+	ChainID            string           `json:"chain_id"`
+	SnapshotHeight     int64            `json:"snapshot_height,omitempty"`
+	SnapshotAgeSeconds *float64         `json:"snapshot_age_seconds,omitempty"`
+	SnapshotFresh      bool             `json:"snapshot_fresh"`
+	HealthyEndpoints   int              `json:"healthy_endpoints"`
+	Endpoints          []endpointStatus `json:"endpoints"`
+}
+
+type readiness struct {
+	Ready    bool            `json:"ready"`
+	Reasons  []string        `json:"reasons,omitempty"`
+	Networks []networkHealth `json:"networks"`
+}
+
+// checkReadiness reports whether the monitor has fresh data and a working
+// upstream, with per-network and per-endpoint detail.
+func checkReadiness(now time.Time) readiness {
+	data, taken := getSnapshot()
+
+	// Group endpoints by the chain they reported
+	byChain := map[string]*networkHealth{}
+	var healthy int
+	for _, e := range endpoints {
+		status := e.status()
+		n, ok := byChain[status.ChainID]
+		if !ok {
+			n = &networkHealth{ChainID: status.ChainID, Endpoints: []endpointStatus{}}
+			byChain[status.ChainID] = n
+		}
+		n.Endpoints = append(n.Endpoints, status)
+		if status.Healthy {
+			n.HealthyEndpoints++
+			healthy++
+		}
+	}
+	if _, ok := byChain[data.ChainID]; !ok && !taken.IsZero() {
+		byChain[data.ChainID] = &networkHealth{ChainID: data.ChainID, Endpoints: []endpointStatus{}}
+	}
+
+	r := readiness{Ready: true, Networks: []networkHealth{}}
+	fresh := !taken.IsZero() && now.Sub(taken) <= ReadyMaxAge
+	for _, n := range byChain {
+		if !taken.IsZero() && n.ChainID == data.ChainID {
+			age := now.Sub(taken).Seconds()
+			n.SnapshotHeight = data.Height
+			n.SnapshotAgeSeconds = &age
+			n.SnapshotFresh = fresh
+		}
+		r.Networks = append(r.Networks, *n)
+	}
+	sort.Slice(r.Networks, func(i, j int) bool { return r.Networks[i].ChainID < r.Networks[j].ChainID })
+
+	if taken.IsZero() {
+		r.Ready = false
+		r.Reasons = append(r.Reasons, "no successful poll yet")
+	} else if !fresh {
+		r.Ready = false
+		r.Reasons = append(r.Reasons, "latest snapshot is older than "+ReadyMaxAge.String())
+	}
+	if healthy == 0 {
+		r.Ready = false
+		r.Reasons = append(r.Reasons, "no healthy "+transportNames(endpoints)+" endpoint")
+	}
+	return r
+}
+
+// transportNames lists the transports of endpoints for messages, e.g.
+// "gRPC or REST".
+func transportNames(endpoints []*endpoint) string {
+	names := map[string]string{
+		transportGRPC:   "gRPC",
+		transportREST:   "REST",
+		transportComet:  "CometBFT RPC",
+		transportReplay: "replay",
+	}
+	var listed []string
+	seen := map[string]bool{}
+	for _, e := range endpoints {
+		if t := e.address.transport; !seen[t] {
+			seen[t] = true
+			listed = append(listed, names[t])
+		}
+	}
+	switch len(listed) {
+	case 0:
+		return "upstream"
+	case 1:
+		return listed[0]
+	}
+	return strings.Join(listed[:len(listed)-1], ", ") + " or " + listed[len(listed)-1]
+}
+
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	lastPollAttempt.Lock()
+	since := time.Since(lastPollAttempt.time)
+	lastPollAttempt.Unlock()
+
+	body := map[string]any{"status": "ok", "seconds_since_poll": since.Seconds()}
+	code := http.StatusOK
+	if since > LivenessMaxAge {
+		// The poll loop is stuck
+		body["status"] = "stalled"
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	result := checkReadiness(time.Now())
+	w.Header().Set("Content-Type", "application/json")
+	if !result.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(result)
+}
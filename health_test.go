@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadinessNamesTransports(t *testing.T) {
+	saved := endpoints
+	t.Cleanup(func() { endpoints = saved })
+	endpoints = nil
+	for _, addr := range []string{"127.0.0.1:1", "rest+http://127.0.0.1:1"} {
+		parsed, err := parseEndpointAddress(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		endpoints = append(endpoints, &endpoint{address: parsed})
+	}
+	r := checkReadiness(time.Now())
+	if !strings.Contains(strings.Join(r.Reasons, "; "), "no healthy gRPC or REST endpoint") {
+		t.Errorf("got reasons %v, want both transports named", r.Reasons)
+	}
+	if r.Networks[0].Endpoints[1].Transport != transportREST {
+		t.Errorf("got %+v, want the REST endpoint's transport", r.Networks[0].Endpoints[1])
+	}
+}
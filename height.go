@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// Cosmos SDK gRPC metadata key used to query state at a past height. The
+// node also sets it on responses to report the height that was served.
+const blockHeightHeader = "x-cosmos-block-height"
+
+// withHeight pins the gRPC queries made with ctx to the given height. A
+// height of 0 leaves the node to pick the latest height.
+func withHeight(ctx context.Context, height int64) context.Context {
+	if height <= 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, blockHeightHeader, strconv.FormatInt(height, 10))
+}
+
+// heightFromHeader returns the height a gRPC response was served at.
+func heightFromHeader(md metadata.MD) (int64, bool) {
+	values := md.Get(blockHeightHeader)
+	if len(values) == 0 {
+		return 0, false
+	}
+	height, err := strconv.ParseInt(values[0], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return height, true
+}
+
+// parseHeight parses the ?height= query parameter, where empty means latest.
+func parseHeight(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	height, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || height < 0 {
+		return 0, fmt.Errorf("invalid height %q", value)
+	}
+	return height, nil
+}
+
+// printUpgradeAt writes the upgrade info and tally at height to stdout.
+func printUpgradeAt(height int64) error {
+	resp, err := queryUpgrade(height)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(resp)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// historySample is the signalling state of the network at one height.
+type historySample struct {
+	Height           int64     `json:"height"`
+	Time             time.Time `json:"time"`
+	AppVersion       uint64    `json:"app_version"`
+	Version          uint64    `json:"version"`
+	VotingPower      int64     `json:"voting_power"`
+	ThresholdPower   int64     `json:"threshold_power"`
+	TotalVotingPower int64     `json:"total_voting_power"`
+	UpgradeVersion   int       `json:"upgrade_version"`
+	UpgradeHeight    int64     `json:"upgrade_height"`
+}
+
+func sampleFromUpgrade(data UpgradeData) historySample {
+	return historySample{
+		Height:           data.Height,
+		Time:             data.BlockTime,
+		AppVersion:       data.AppVersion,
+		Version:          data.TallyData.Version,
+		VotingPower:      data.TallyData.VotingPower,
+		ThresholdPower:   data.TallyData.ThresholdPower,
+		TotalVotingPower: data.TallyData.TotalVotingPower,
+		UpgradeVersion:   data.UpgradeData.Upgrade.AppVersion,
+		UpgradeHeight:    data.UpgradeData.Upgrade.UpgradeHeight,
+	}
+}
+
+// votingPercent returns the fraction of power signalled for Version.
+func (s historySample) votingPercent() float64 {
+	if s.TotalVotingPower == 0 {
+		return 0
+	}
+	return float64(s.VotingPower) / float64(s.TotalVotingPower)
+}
+
+// historyStore keeps samples ordered by height, one per height, and appends
+// new samples to a JSON lines file when a path is configured.
+type historyStore struct {
+	mu      sync.Mutex
+	path    string
+	samples []historySample
+}
+
+var history = &historyStore{}
+
+// openHistory loads the samples in path, if it exists. An empty path keeps
+// history in memory only.
+func openHistory(path string) (*historyStore, error) {
+	h := &historyStore{path: path}
+	if path == "" {
+		return h, nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var s historySample
+		if err := json.Unmarshal(scanner.Bytes(), &s); err != nil {
+			return nil, fmt.Errorf("failed to parse history file %s: %w", path, err)
+		}
+		h.insert(s)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read history file: %w", err)
+	}
+	return h, nil
+}
+
+// insert adds s in height order, replacing any sample at the same height.
+func (h *historyStore) insert(s historySample) {
+	i := sort.Search(len(h.samples), func(i int) bool { return h.samples[i].Height >= s.Height })
+	if i < len(h.samples) && h.samples[i].Height == s.Height {
+		h.samples[i] = s
+		return
+	}
+	h.samples = append(h.samples, historySample{})
+	copy(h.samples[i+1:], h.samples[i:])
+	h.samples[i] = s
+}
+
+// add stores samples and appends them to the history file.
+func (h *historyStore) add(samples ...historySample) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, s := range samples {
+		h.insert(s)
+	}
+	if h.path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open history file: %w", err)
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	for _, s := range samples {
+		if err := enc.Encode(s); err != nil {
+			return fmt.Errorf("failed to write history file: %w", err)
+		}
+	}
+	return nil
+}
+
+// record stores s if the signalling state changed since the latest sample.
+func (h *historyStore) record(s historySample) error {
+	h.mu.Lock()
+	if n := len(h.samples); n > 0 {
+		last := h.samples[n-1]
+		if s.Height <= last.Height || !changed(last, s, 0) {
+			h.mu.Unlock()
+			return nil
+		}
+	}
+	h.mu.Unlock()
+	return h.add(s)
+}
+
+// between returns the samples with from <= height <= to. A to of 0 means
+// no upper bound.
+func (h *historyStore) between(from, to int64) []historySample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	result := []historySample{}
+	for _, s := range h.samples {
+		if s.Height >= from && (to == 0 || s.Height <= to) {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// changed reports whether the signalling state differs between two samples,
+// ignoring voting percent moves up to tolerance.
+func changed(a, b historySample, tolerance float64) bool {
+	if a.AppVersion != b.AppVersion || a.Version != b.Version ||
+		a.UpgradeVersion != b.UpgradeVersion || a.UpgradeHeight != b.UpgradeHeight {
+		return true
+	}
+	if tolerance == 0 {
+		return a.VotingPower != b.VotingPower
+	}
+	diff := a.votingPercent() - b.votingPercent()
+	return diff > tolerance || diff < -tolerance
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// metricsInterceptor records latency and errors for every unary RPC made
+// through a connection, so new RPCs are instrumented automatically.
+func metricsInterceptor(endpoint string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		rpcDuration.WithLabelValues(endpoint, method).Observe(time.Since(start).Seconds())
+		if err != nil {
+			rpcErrors.WithLabelValues(method, status.Code(err).String()).Inc()
+		}
+		return err
+	}
+}
+
+// snapshotAge returns the age of the latest snapshot in seconds, or -1 if no
+// poll has succeeded yet.
+func snapshotAge() float64 {
+	_, taken := getSnapshot()
+	if taken.IsZero() {
+		return -1
+	}
+	return time.Since(taken).Seconds()
+}
@@ -0,0 +1,1068 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	signaltypes "celestia-upgrade-monitor/celestia/signal/v1"
+	"celestia-upgrade-monitor/fake"
+
+	signingtypes "cosmossdk.io/api/cosmos/tx/signing/v1beta1"
+	txtypes "cosmossdk.io/api/cosmos/tx/v1beta1"
+	"github.com/cosmos/cosmos-sdk/crypto/hd"
+	"github.com/cosmos/cosmos-sdk/crypto/keyring"
+	"github.com/cosmos/cosmos-sdk/types/bech32"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const testChainID = "mocha-test"
+
+// newFakeNode starts a fake node at height 100 on app version 1 with 50 of
+// 100 power signalling for version 2.
+func newFakeNode(t *testing.T) (*fake.Server, endpointAddress) {
+	t.Helper()
+	node := fake.New(testChainID)
+	node.SetHeight(100)
+	node.SetTally(2, 50, 100)
+	addr, stop, err := node.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(stop)
+	parsed, err := parseEndpointAddress(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return node, parsed
+}
+
+// useEndpoints points the monitor at the given addresses for one test.
+func useEndpoints(t *testing.T, addrs ...endpointAddress) {
+	t.Helper()
+	saved := endpoints
+	endpoints = nil
+	for _, addr := range addrs {
+		endpoints = append(endpoints, &endpoint{address: addr})
+	}
+	t.Cleanup(func() { endpoints = saved })
+}
+
+func TestGetUpgrade(t *testing.T) {
+	node := fake.New(testChainID)
+	node.SetHeight(100)
+	node.SetTally(2, 50, 100)
+	conn, stop, err := node.Bufconn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	b := &grpcBackend{conn: conn}
+
+	data, err := getUpgrade(b, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.ChainID != testChainID || data.Height != 100 || data.AppVersion != 1 {
+		t.Errorf("got block %s/%d on v%d, want %s/100 on v1", data.ChainID, data.Height, data.AppVersion, testChainID)
+	}
+	want := TallyResponse{
+		Version:          2,
+		VotingPower:      50,
+		VotingPercent:    0.5,
+		TotalVotingPower: 100,
+		ThresholdPower:   84,
+		ThresholdPercent: 0.84,
+	}
+	if data.TallyData != want {
+		t.Errorf("got tally %+v, want %+v", data.TallyData, want)
+	}
+	if data.UpgradeData.Upgrade.UpgradeHeight != 0 {
+		t.Errorf("got upgrade height %d with nothing scheduled", data.UpgradeData.Upgrade.UpgradeHeight)
+	}
+
+	node.ScheduleUpgrade(2, 150)
+	data, err = getUpgrade(b, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.UpgradeData.Upgrade != (Upgrade{AppVersion: 2, UpgradeHeight: 150}) {
+		t.Errorf("got upgrade %+v, want v2 at 150", data.UpgradeData.Upgrade)
+	}
+
+	data, err = getUpgrade(b, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Height != 60 {
+		t.Errorf("got height %d, want 60", data.Height)
+	}
+
+	_, err = getUpgrade(b, 1000)
+	if !isRequestError(err) {
+		t.Errorf("got %v for a future height, want a request error", err)
+	}
+}
+
+func TestGetUpgradeTargetVersion(t *testing.T) {
+	node := fake.New(testChainID)
+	node.SetTally(3, 90, 100)
+	conn, stop, err := node.Bufconn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	TargetVersion = 3
+	defer func() { TargetVersion = 0 }()
+	data, err := getUpgrade(&grpcBackend{conn: conn}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.TallyData.Version != 3 || data.TallyData.VotingPower != 90 {
+		t.Errorf("got %d power for v%d, want 90 for v3", data.TallyData.VotingPower, data.TallyData.Version)
+	}
+}
+
+func TestQueryUpgradeFailover(t *testing.T) {
+	broken, brokenAddr := newFakeNode(t)
+	_, workingAddr := newFakeNode(t)
+	useEndpoints(t, brokenAddr, workingAddr)
+
+	broken.SetError("", status.Error(codes.Unavailable, "node is down"))
+	data, err := queryUpgrade(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.TallyData.VotingPower != 50 {
+		t.Errorf("got voting power %d, want 50", data.TallyData.VotingPower)
+	}
+	if endpoints[0].isHealthy() || !endpoints[1].isHealthy() {
+		t.Errorf("want only the broken endpoint marked unhealthy")
+	}
+
+	// Bad requests fail the same way everywhere, so they aren't retried
+	broken.SetError("", nil)
+	useEndpoints(t, brokenAddr, workingAddr)
+	if _, err := queryUpgrade(1000); !isRequestError(err) {
+		t.Errorf("got %v for a future height, want a request error", err)
+	}
+	if status := endpoints[0].status(); status.LastError != "" {
+		t.Errorf("request error was counted against the endpoint: %s", status.LastError)
+	}
+}
+
+func TestUpdatePromMetrics(t *testing.T) {
+	node, addr := newFakeNode(t)
+	useEndpoints(t, addr)
+	node.ScheduleUpgrade(2, 150)
+
+	if _, err := updatePromMetrics(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name  string
+		gauge prometheus.Gauge
+		want  float64
+	}{
+		{"status", upgradeStatus, 1},
+		{"version", upgradeVersion, 2},
+		{"height", upgradeHeight, 150},
+		{"voting power", tallyVotingPower, 50},
+		{"total power", tallyTotalVotingPower, 100},
+		{"threshold power", tallyThresholdPower, 84},
+		{"threshold percent", tallyThresholdPercent, 0.84},
+	} {
+		if got := testutil.ToFloat64(tc.gauge); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	node.SetError(fake.MethodVersionTally, status.Error(codes.Internal, "boom"))
+	if _, err := updatePromMetrics(); err == nil {
+		t.Errorf("want an error when the tally fails")
+	}
+}
+
+func TestSignalEvents(t *testing.T) {
+	node, addr := newFakeNode(t)
+	useEndpoints(t, addr)
+	valoper := "celestiavaloper1signalling"
+	node.SetValidator(fake.Validator{Address: valoper, Moniker: "val", Power: 10})
+
+	srv := httptest.NewServer(node.WebsocketHandler())
+	t.Cleanup(srv.Close)
+	sub, err := newCometSubscriber(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	connected := make(chan struct{}, 1)
+	sub.onConnect = func(ok bool) {
+		if ok {
+			connected <- struct{}{}
+		}
+	}
+	subscribeSignalTxs(sub)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go sub.run(ctx)
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("websocket didn't connect")
+	}
+	// Let the fake register the subscriptions
+	time.Sleep(200 * time.Millisecond)
+
+	signal := func(version uint64) Event {
+		t.Helper()
+		before := len(recentEvents())
+		if err := node.SignalVersion(valoper, version); err != nil {
+			t.Fatal(err)
+		}
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			for _, e := range recentEvents()[before:] {
+				if e.Kind == "validator_signalled" {
+					return e
+				}
+			}
+		}
+		t.Fatalf("no validator_signalled event for version %d", version)
+		return Event{}
+	}
+
+	if e := signal(2); !strings.Contains(e.Message, "(+9.09% power)") {
+		t.Errorf("got %q, want +9.09%% power", e.Message)
+	}
+	if e := signal(2); !strings.Contains(e.Message, "again, no change in power") {
+		t.Errorf("got %q for signalling the same version again", e.Message)
+	}
+	if e := signal(3); !strings.Contains(e.Message, "for version 3, not the target 2, moved from version 2") {
+		t.Errorf("got %q for signalling another version", e.Message)
+	}
+
+	// The signal is still reported when the tally can't be refreshed
+	node.SetError(fake.MethodVersionTally, status.Error(codes.Unavailable, "down"))
+	e := signal(2)
+	if !strings.Contains(e.Message, "(tally unavailable)") || e.Fields["tally_percent"] != "unknown" || e.Fields["target_version"] != "unknown" || e.Fields["power"] != "10" {
+		t.Errorf("got %+v with the tally failing", e)
+	}
+	node.SetError(fake.MethodVersionTally, nil)
+
+	// A TryUpgrade whose outcome can't be checked leaves it to the poll loop
+	select {
+	case <-pollNow:
+	default:
+	}
+	node.SetError(fake.MethodGetUpgrade, status.Error(codes.Unavailable, "down"))
+	onTryUpgrade(&signaltypes.MsgTryUpgrade{Signer: "celestia1trier"}, 101, "ABCD")
+	node.SetError(fake.MethodGetUpgrade, nil)
+	select {
+	case <-pollNow:
+	default:
+		t.Error("no poll requested after failing to check a TryUpgrade")
+	}
+}
+
+func TestHTTPHandlers(t *testing.T) {
+	_, addr := newFakeNode(t)
+	useEndpoints(t, addr)
+	srv := httptest.NewServer(newHTTPHandler())
+	defer srv.Close()
+
+	get := func(path string) (int, string) {
+		t.Helper()
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	code, body := get("/upgrade")
+	if code != http.StatusOK {
+		t.Fatalf("/upgrade: got %d: %s", code, body)
+	}
+	var data UpgradeData
+	if err := json.Unmarshal([]byte(body), &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Height != 100 || data.TallyData.VotingPower != 50 {
+		t.Errorf("/upgrade: got height %d and power %d, want 100 and 50", data.Height, data.TallyData.VotingPower)
+	}
+
+	for _, path := range []string{"/upgrade?height=abc", "/upgrade?height=1000", "/history?from=-1"} {
+		if code, body := get(path); code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want 400: %s", path, code, body)
+		}
+	}
+
+	markPollAttempt()
+	if _, err := updatePromMetrics(); err != nil {
+		t.Fatal(err)
+	}
+	if code, body := get("/readyz"); code != http.StatusOK {
+		t.Errorf("/readyz: got %d after a poll, want 200: %s", code, body)
+	}
+	if code, body := get("/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz: got %d, want 200: %s", code, body)
+	}
+
+	code, body = get("/metrics")
+	if code != http.StatusOK || !strings.Contains(body, "celestia_tally_voting_power 50") {
+		t.Errorf("/metrics: got %d without the tally", code)
+	}
+
+}
+
+func TestRecordReplay(t *testing.T) {
+	node, addr := newFakeNode(t)
+	path := filepath.Join(t.TempDir(), "calls.jsonl")
+	rec, err := openRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder = rec
+	b, err := newBackend(addr)
+	recorder = nil
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := getUpgrade(b, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.ScheduleUpgrade(2, 150)
+	after, err := getUpgrade(b, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getUpgrade(b, 1000); err == nil {
+		t.Fatal("want an error for a future height")
+	}
+	b.Close()
+	rec.Close()
+
+	replayAddr, err := parseEndpointAddress("replay://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay, err := newBackend(replayAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Repeated calls get the recorded responses in order, then the last
+	for i, want := range []UpgradeData{before, after, after} {
+		got, err := getUpgrade(replay, 0)
+		if err != nil {
+			t.Fatalf("replay %d: %v", i, err)
+		}
+		if got.Height != want.Height || got.UpgradeData.Upgrade != want.UpgradeData.Upgrade || got.TallyData != want.TallyData {
+			t.Errorf("replay %d: got %+v, want %+v", i, got, want)
+		}
+	}
+	if _, err := getUpgrade(replay, 1000); !isRequestError(err) {
+		t.Errorf("got %v replaying a future height, want a request error", err)
+	}
+	if _, err := getUpgrade(replay, 60); status.Code(err) != codes.NotFound {
+		t.Errorf("got %v for an unrecorded call, want NotFound", err)
+	}
+}
+
+// newTestSigner returns a signer with a fresh key in an in-memory keyring.
+func newTestSigner(t *testing.T, conn *grpc.ClientConn) (*txSigner, signerKey) {
+	t.Helper()
+	kr := keyring.NewInMemory(keyringCodec())
+	if _, _, err := kr.NewMnemonic("operator", keyring.English, "m/44'/118'/0'/0/0", keyring.DefaultBIP39Passphrase, hd.Secp256k1); err != nil {
+		t.Fatal(err)
+	}
+	signer := &txSigner{conn: conn, keyring: kr, from: "operator", gasPrice: gasPrice{0.002, "utia"}, gasAdjustment: 1.3, timeout: 5 * time.Second}
+	key, err := signer.key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, key
+}
+
+func TestSignal(t *testing.T) {
+	node := fake.New(testChainID)
+	node.SetHeight(100)
+	node.SetTally(2, 50, 100)
+	conn, stop, err := node.Bufconn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	signer, key := newTestSigner(t, conn)
+	valoper := key.bech32(valoperPrefix)
+	node.SetValidator(fake.Validator{Address: valoper, Moniker: "operator", Power: 20})
+
+	result, err := signalVersion(context.Background(), signer, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := signalResult{Version: 2, Validator: valoper, TxHash: result.TxHash, Height: 100, Before: 50, After: 70, Total: 120, Threshold: 100}
+	if result != want || result.TxHash == "" {
+		t.Errorf("got %+v, want %+v", result, want)
+	}
+	if signer.chainID != testChainID {
+		t.Errorf("signed for chain %q, want the node's %q", signer.chainID, testChainID)
+	}
+
+	if _, err := signalVersion(context.Background(), signer, 2, "celestiavaloper1unknown"); err == nil {
+		t.Errorf("want an error signalling for an unknown validator")
+	}
+
+	// Genesis validators often have vesting accounts
+	address := key.bech32(accountPrefix)
+	node.SetVestingAccount(address, 7, 3)
+	account, err := signer.account(context.Background(), address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.GetAccountNumber() != 7 || account.GetSequence() != 3 {
+		t.Errorf("vesting account has number %d and sequence %d, want 7 and 3", account.GetAccountNumber(), account.GetSequence())
+	}
+	if _, err := signalVersion(context.Background(), signer, 2, ""); err != nil {
+		t.Errorf("signalling from a vesting account: %v", err)
+	}
+}
+
+func TestAuthzSignal(t *testing.T) {
+	node := fake.New(testChainID)
+	node.SetHeight(100)
+	node.SetTally(2, 50, 100)
+	conn, stop, err := node.Bufconn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	signer, key := newTestSigner(t, conn)
+	grantee := key.bech32(accountPrefix)
+	valoper, err := bech32.ConvertAndEncode(valoperPrefix, make([]byte, 20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	granter, err := validatorAccount(valoper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.SetValidator(fake.Validator{Address: valoper, Moniker: "validator", Power: 20})
+
+	if _, err := authzSignalVersion(context.Background(), signer, 2, valoper); !errors.Is(err, errNoGrant) {
+		t.Fatalf("got %v without a grant, want errNoGrant", err)
+	}
+	node.SetGrant(granter, grantee, msgSignalVersionURL, time.Now().Add(-time.Hour))
+	if _, err := authzSignalVersion(context.Background(), signer, 2, valoper); !errors.Is(err, errNoGrant) {
+		t.Fatalf("got %v with an expired grant, want errNoGrant", err)
+	}
+
+	expiry := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	node.SetGrant(granter, grantee, msgSignalVersionURL, expiry)
+	result, err := authzSignalVersion(context.Background(), signer, 2, valoper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Before != 50 || result.After != 70 {
+		t.Errorf("tally went from %d to %d, want 50 to 70", result.Before, result.After)
+	}
+
+	// A grant expiring within the warning window is reported once
+	g := newGrantWatcher([]grantWatch{{Validator: valoper, Granter: granter, Grantee: grantee}}, 48*time.Hour)
+	got, err := signalGrant(context.Background(), &grpcBackend{conn: conn}, granter, grantee, time.Now())
+	if err != nil || !got.Equal(expiry) {
+		t.Fatalf("got expiry %v, %v, want %v", got, err, expiry)
+	}
+	before := len(recentEvents())
+	g.update(g.watches[0], got, false, time.Now())
+	g.update(g.watches[0], got, false, time.Now())
+	events := recentEvents()
+	if len(events) != before+1 || events[len(events)-1].Kind != "authz_grant_expiring" {
+		t.Errorf("want one authz_grant_expiring event, got %+v", events[before:])
+	}
+}
+
+func TestTryUpgrade(t *testing.T) {
+	node, addr := newFakeNode(t)
+	node.SetTally(2, 90, 100)
+	useEndpoints(t, addr)
+	conn, err := grpcClient(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	signer, _ := newTestSigner(t, conn)
+	tu := newTryUpgrader(signer)
+
+	data, err := queryUpgrade(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tryUpgradeDue(data) {
+		t.Fatalf("want MsgTryUpgrade due with %d of %d signalled", data.TallyData.VotingPower, data.TallyData.TotalVotingPower)
+	}
+	lastEvent := func() Event {
+		events := recentEvents()
+		return events[len(events)-1]
+	}
+
+	signer.maxFee = 1
+	tu.submit(context.Background(), data)
+	if e := lastEvent(); e.Kind != "try_upgrade_skipped" {
+		t.Errorf("got %s over the fee cap, want try_upgrade_skipped", e.Kind)
+	}
+
+	// 104000 gas costs 208utia at -gas-prices, but the node wants 0.1utia
+	signer.maxFee = 10_000
+	node.SetMinGasPrice("0.100000000000000000utia,0.5uother")
+	tu.submit(context.Background(), data)
+	if e := lastEvent(); e.Kind != "try_upgrade_skipped" || !strings.Contains(e.Message, "10400utia") {
+		t.Errorf("got %s %q at the node's minimum gas price, want try_upgrade_skipped for 10400utia", e.Kind, e.Message)
+	}
+	node.SetMinGasPrice("")
+
+	signer.maxFee = 0
+	tu.submit(context.Background(), data)
+	e := lastEvent()
+	if e.Kind != "try_upgrade_submitted" || e.Fields["upgrade_height"] != "200" || e.Fields["tx_hash"] == "" {
+		t.Errorf("got %s %v, want try_upgrade_submitted scheduling height 200", e.Kind, e.Fields)
+	}
+	if data, err := queryUpgrade(0); err != nil || tryUpgradeDue(data) {
+		t.Errorf("want MsgTryUpgrade no longer due once scheduled (err %v)", err)
+	}
+}
+
+func TestSignalOffline(t *testing.T) {
+	node := fake.New(testChainID)
+	node.SetHeight(100)
+	node.SetTally(2, 50, 100)
+	conn, stop, err := node.Bufconn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	offline, key := newTestSigner(t, conn)
+	valoper := key.bech32(valoperPrefix)
+	node.SetValidator(fake.Validator{Address: valoper, Moniker: "operator", Power: 20})
+
+	ctx := context.Background()
+	online := &txSigner{conn: conn, gasPrice: gasPrice{0.002, "utia"}, gasAdjustment: 1.3, timeout: 5 * time.Second}
+	version, err := prepareSignal(ctx, online, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned, err := generateSignal(ctx, online, valoper, version, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sign as celestia-appd tx sign --offline would
+	sign := func(tx *txtypes.Tx, s *txSigner, k signerKey) []byte {
+		t.Helper()
+		tx = proto.Clone(tx).(*txtypes.Tx)
+		tx.AuthInfo.SignerInfos = []*txtypes.SignerInfo{{
+			PublicKey: k.pubKey,
+			ModeInfo:  &txtypes.ModeInfo{Sum: &txtypes.ModeInfo_Single_{Single: &txtypes.ModeInfo_Single{Mode: signingtypes.SignMode_SIGN_MODE_DIRECT}}},
+			Sequence:  unsigned.Sequence,
+		}}
+		body, _ := proto.Marshal(tx.Body)
+		authInfo, _ := proto.Marshal(tx.AuthInfo)
+		s.chainID = unsigned.ChainID
+		signature, err := s.sign(body, authInfo, unsigned.AccountNumber)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx.Signatures = [][]byte{signature}
+		data, err := txJSON.Marshal(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	signed := sign(unsigned.Tx, offline, key)
+
+	if _, err := broadcastSignal(ctx, online, signed, valoper, version+1); err == nil {
+		t.Errorf("want an error broadcasting a signal for another version")
+	}
+	other, otherKey := newTestSigner(t, conn)
+	if _, err := broadcastSignal(ctx, online, sign(unsigned.Tx, other, otherKey), valoper, version); err == nil {
+		t.Errorf("want an error broadcasting a transaction signed by another key")
+	}
+
+	result, err := broadcastSignal(ctx, online, signed, valoper, version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Before != 50 || result.After != 70 {
+		t.Errorf("got tally %d -> %d, want 50 -> 70", result.Before, result.After)
+	}
+}
+
+func TestSelfCheck(t *testing.T) {
+	node, addr := newFakeNode(t)
+	useEndpoints(t, addr)
+	ours, err := bech32.ConvertAndEncode(valoperPrefix, []byte("our-validator-address"[:20]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.SetValidator(fake.Validator{Address: ours, Moniker: "ours", Power: 10})
+	milestones, err := parseSelfMilestones("40,quorum-5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newSelfChecker([]string{ours}, milestones, nil, 0)
+
+	check := func(wantLevel int, wantSeverity string, wantSignalled bool) {
+		t.Helper()
+		data, err := queryUpgrade(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		before := len(recentEvents())
+		c.update(data)
+		v := c.snapshot().Validators[0]
+		if v.Signalled != wantSignalled || v.Severity != wantSeverity || v.Power != 10 {
+			t.Errorf("got %+v, want signalled %v with severity %q", v, wantSignalled, wantSeverity)
+		}
+		if got := testutil.ToFloat64(ownValidatorAlertLevel.WithLabelValues(ours)); got != float64(wantLevel) {
+			t.Errorf("alert level is %v, want %d", got, wantLevel)
+		}
+		events := recentEvents()[before:]
+		if wantSeverity != "" && (len(events) != 1 || events[0].Kind != "own_validator_not_signalled" || events[0].Severity != wantSeverity) {
+			t.Errorf("want one %s own_validator_not_signalled event, got %+v", wantSeverity, events)
+		}
+	}
+
+	// 50 of 110 is past 40%
+	check(1, severityWarning, false)
+	// 100 of 110 is within 5 points of the 92 threshold
+	node.SignalVersion("silent", 2)
+	check(2, severityCritical, false)
+	// Not searched for again at the height it was missed at
+	node.SignalVersion(ours, 2)
+	data, err := queryUpgrade(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.update(data)
+	if v := c.snapshot().Validators[0]; v.Signalled {
+		t.Errorf("got %+v, want the miss at height %d kept", v, data.Height)
+	}
+	// Found by searching transactions once a block passes, with no websocket
+	node.AdvanceHeight(1)
+	check(0, "", true)
+	if got := testutil.ToFloat64(ownValidatorSignalled.WithLabelValues(ours)); got != 1 {
+		t.Errorf("celestia_own_validator_signalled is %v, want 1", got)
+	}
+
+	// A signal sent by an authz grantee is found too, and not searched for
+	// again once found
+	delegated, err := bech32.ConvertAndEncode(valoperPrefix, []byte("delegated-validator!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	grantee, err := bech32.ConvertAndEncode(accountPrefix, []byte("delegated-grantee!!!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.SetValidator(fake.Validator{Address: delegated, Moniker: "delegated", Power: 5})
+	c = newSelfChecker([]string{delegated}, milestones, []grantWatch{{Validator: delegated, Grantee: grantee}}, 0)
+	node.AdvanceHeight(1)
+	if err := node.ExecSignalVersion(grantee, delegated, 2); err != nil {
+		t.Fatal(err)
+	}
+	data, err = queryUpgrade(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 2 {
+		c.update(data)
+		if v := c.snapshot().Validators[0]; !v.Signalled || v.SignalHeight != 102 {
+			t.Errorf("update %d: got %+v, want the signal executed by the grantee at 102", i, v)
+		}
+		node.SetError(fake.MethodGetTxsEvent, status.Error(codes.Unavailable, "down"))
+	}
+	node.SetError(fake.MethodGetTxsEvent, nil)
+
+	// A miss isn't searched again until -self-search-interval passes
+	late, err := bech32.ConvertAndEncode(valoperPrefix, []byte("late-validator-addr!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.SetValidator(fake.Validator{Address: late, Moniker: "late", Power: 1})
+	c = newSelfChecker([]string{late}, milestones, nil, time.Hour)
+	updateLate := func() ownValidator {
+		t.Helper()
+		data, err := queryUpgrade(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.update(data)
+		return c.snapshot().Validators[0]
+	}
+	updateLate()
+	node.SignalVersion(late, 2)
+	node.AdvanceHeight(1)
+	if v := updateLate(); v.Signalled {
+		t.Errorf("got %+v, want no search within the interval", v)
+	}
+	c.mu.Lock()
+	miss := c.misses[late]
+	miss.at = miss.at.Add(-2 * time.Hour)
+	c.misses[late] = miss
+	c.mu.Unlock()
+	if v := updateLate(); !v.Signalled {
+		t.Errorf("got %+v, want the signal found once the interval passed", v)
+	}
+}
+
+func TestNodeReadiness(t *testing.T) {
+	node, addr := newFakeNode(t)
+	useEndpoints(t, addr)
+	node.SetNodeVersion("v1.5.0", "abc123")
+	releases, err := parseMinReleases("2=v2.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newNodeChecker([]*endpoint{{address: addr}}, releases, 50)
+
+	check := func(wantReady bool, wantSeverity string) {
+		t.Helper()
+		data, err := queryUpgrade(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		before := len(recentEvents())
+		c.update(data)
+		s := c.snapshot()[0]
+		if s.Ready != wantReady || s.TargetAppVersion != 2 {
+			t.Errorf("got %+v, want ready %v for app version 2", s, wantReady)
+		}
+		if got := testutil.ToFloat64(nodeReady.WithLabelValues(addr.String())); (got == 1) != wantReady {
+			t.Errorf("celestia_node_ready is %v, want ready %v", got, wantReady)
+		}
+		events := recentEvents()[before:]
+		if wantSeverity == "" && len(events) > 0 {
+			t.Errorf("want no events, got %+v", events)
+		}
+		if wantSeverity != "" && (len(events) != 1 || events[0].Kind != "node_not_ready" || events[0].Severity != wantSeverity) {
+			t.Errorf("want one %s node_not_ready event, got %+v", wantSeverity, events)
+		}
+	}
+
+	// Not ready, but nothing is scheduled yet
+	check(false, "")
+	node.ScheduleUpgrade(2, 200)
+	check(false, severityWarning)
+	check(false, "")
+	node.SetHeight(160)
+	check(false, severityCritical)
+	// A release candidate comes before the required release
+	node.SetNodeVersion("v2.0.0-rc1", "def456")
+	check(false, "")
+	node.SetNodeVersion("v2.0.1", "0a1b2c")
+	check(true, "")
+	// The info series moves to the new release without the old one left over
+	if got := testutil.ToFloat64(nodeInfo.WithLabelValues(addr.String(), "v2.0.1", "0a1b2c")); got != 1 {
+		t.Errorf("celestia_node_info for v2.0.1 is %v, want 1", got)
+	}
+	if nodeInfo.DeleteLabelValues(addr.String(), "v2.0.0-rc1", "def456") {
+		t.Error("celestia_node_info for v2.0.0-rc1 left over")
+	}
+
+	// Nodes are queried in parallel
+	var nodes []*endpoint
+	for range 3 {
+		slow := fake.New(testChainID)
+		slow.SetLatency(300 * time.Millisecond)
+		a, stop, err := slow.Listen("127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(stop)
+		parsed, err := parseEndpointAddress(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes = append(nodes, &endpoint{address: parsed})
+	}
+	c = newNodeChecker(nodes, releases, 50)
+	data, err := queryUpgrade(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	c.update(data)
+	if elapsed := time.Since(start); elapsed > 800*time.Millisecond {
+		t.Errorf("checking 3 nodes with 300ms latency took %s", elapsed)
+	}
+	if statuses := c.snapshot(); len(statuses) != 3 || statuses[2].Node != nodes[2].address.String() || statuses[2].Version != "1.0.0" {
+		t.Errorf("got %+v, want the 3 nodes in order", statuses)
+	}
+}
+
+func TestFleet(t *testing.T) {
+	node, addr := newFakeNode(t)
+	useEndpoints(t, addr)
+	node.SetNodeVersion("v2.0.0", "abc123")
+	listen := func(chainID string, height int64) (*fake.Server, string) {
+		n := fake.New(chainID)
+		n.SetHeight(height)
+		n.SetNodeVersion("v2.0.0", "abc123")
+		a, stop, err := n.Listen("127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(stop)
+		return n, a
+	}
+	sentry, sentryAddr := listen(testChainID, 80)
+	_, rpcAddr := listen("other-chain", 100)
+
+	nodes, err := configFleet(fileConfig{Fleet: []fleetNodeConfig{
+		{Name: "val-1", Role: roleValidator, GRPC: addr.addr},
+		{Name: "sentry-1", Role: roleSentry, GRPC: sentryAddr},
+		{Name: "rpc-1", Role: roleRPC, GRPC: rpcAddr},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := newFleetChecker(nodes, nil, 10, 50)
+
+	update := func() (fleetReport, []Event) {
+		t.Helper()
+		data, err := queryUpgrade(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		before := len(recentEvents())
+		f.update(data)
+		return f.snapshot(), recentEvents()[before:]
+	}
+
+	report, events := update()
+	if report.Ready || report.ReadyNodes != 1 {
+		t.Errorf("got %d ready nodes, want only val-1", report.ReadyNodes)
+	}
+	failed := map[string]string{}
+	for _, s := range report.Nodes {
+		for check, ok := range s.Checks {
+			if !ok {
+				failed[s.Name] = check
+			}
+		}
+	}
+	if len(failed) != 2 || failed["sentry-1"] != checkSynced || failed["rpc-1"] != checkChainID {
+		t.Errorf("got failed checks %v, want sentry-1 not synced and rpc-1 on the wrong chain", failed)
+	}
+	if len(events) != 2 {
+		t.Errorf("want two fleet_node_check_failed events, got %+v", events)
+	}
+	if got := testutil.ToFloat64(fleetNodeLag.WithLabelValues("sentry-1", roleSentry)); got != 20 {
+		t.Errorf("sentry-1 lag is %v, want 20", got)
+	}
+
+	sentry.SetHeight(100)
+	if _, events = update(); len(events) != 1 || events[0].Kind != "fleet_node_check_recovered" {
+		t.Errorf("want sentry-1 to recover, got %+v", events)
+	}
+
+	// A node still on a release without the next app version only alerts
+	// once the upgrade is scheduled
+	sentry.SetNodeVersion("v1.9.0", "def456")
+	if _, events = update(); len(events) != 0 {
+		t.Errorf("want no events before the upgrade is scheduled, got %+v", events)
+	}
+	node.ScheduleUpgrade(2, 200)
+	if _, events = update(); len(events) != 1 || events[0].Severity != severityWarning || events[0].Fields["check"] != checkUpgrade {
+		t.Errorf("want an upgrade warning for sentry-1, got %+v", events)
+	}
+}
+
+func TestCosmovisor(t *testing.T) {
+	node, addr := newFakeNode(t)
+	useEndpoints(t, addr)
+	node.SetHeight(100)
+
+	home := t.TempDir()
+	dir := filepath.Join(home, "cosmovisor")
+	stage := func(name, version string) string {
+		t.Helper()
+		bin := filepath.Join(dir, name, "bin")
+		if err := os.MkdirAll(bin, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(bin, cosmovisorDaemon)
+		if err := os.WriteFile(path, []byte("#!/bin/sh\necho "+version+"\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		sum, err := fileSHA256(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sum
+	}
+	stage("genesis", "v1.0.0")
+	if err := os.Symlink("genesis", filepath.Join(dir, "current")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(home, "data"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	sum := stage("upgrades/v2", "v2.0.0")
+	manifestPath := filepath.Join(home, "manifest")
+	if err := os.WriteFile(manifestPath, []byte(sum+"  upgrades/v2/bin/celestia-appd\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := loadManifest(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newCosmovisorChecker(home, "v%d", manifest, nil, 50)
+
+	update := func() (map[string]string, []Event) {
+		t.Helper()
+		data, err := queryUpgrade(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		before := len(recentEvents())
+		c.update(data)
+		statuses := map[string]string{}
+		for _, r := range c.snapshot().Checks {
+			statuses[r.Check] = r.Status
+		}
+		return statuses, recentEvents()[before:]
+	}
+
+	statuses, events := update()
+	if statuses[checkBinaryPresent] != checkSkip || statuses[checkCurrentLink] != checkPass || len(events) != 0 {
+		t.Errorf("without an upgrade got %v and events %+v, want skipped checks", statuses, events)
+	}
+
+	node.ScheduleUpgrade(2, 200)
+	statuses, events = update()
+	for _, check := range cosmovisorChecks {
+		if statuses[check] != checkPass {
+			t.Errorf("%s is %s, want pass", check, statuses[check])
+		}
+	}
+	if len(events) != 0 {
+		t.Errorf("want no events with the binary staged, got %+v", events)
+	}
+
+	// A different build of an older release
+	stage("upgrades/v2", "v1.9.0-rc0")
+	statuses, events = update()
+	if statuses[checkBinaryVersion] != checkFail || statuses[checkChecksum] != checkFail {
+		t.Errorf("got %v, want the version and checksum checks to fail", statuses)
+	}
+	if len(events) != 2 || events[0].Kind != "cosmovisor_check_failed" || events[0].Severity != severityWarning {
+		t.Errorf("want two cosmovisor_check_failed warnings, got %+v", events)
+	}
+	if got := testutil.ToFloat64(cosmovisorCheck.WithLabelValues(checkChecksum)); got != 0 {
+		t.Errorf("checksum metric is %v, want 0", got)
+	}
+
+	// The node halts at the upgrade height
+	info := []byte(`{"name":"v2","height":200}`)
+	if err := os.WriteFile(filepath.Join(home, "data", "upgrade-info.json"), info, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	before := len(recentEvents())
+	c.checkUpgradeInfo()
+	events = recentEvents()[before:]
+	if len(events) != 1 || events[0].Kind != "cosmovisor_upgrade_info" || events[0].Severity != severityInfo {
+		t.Errorf("want a cosmovisor_upgrade_info event, got %+v", events)
+	}
+}
+
+func TestReadinessChecklist(t *testing.T) {
+	node, addr := newFakeNode(t)
+	useEndpoints(t, addr)
+	node.SetHeight(100)
+	node.SetNodeVersion("v2.0.0", "abc123")
+	node.ScheduleUpgrade(2, 200)
+
+	dir := t.TempDir()
+	appToml := filepath.Join(dir, "app.toml")
+	if err := os.WriteFile(appToml, []byte("minimum-gas-prices = \"0.002utia\"\nhalt-height = 150\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c := &checklist{
+		items:   checklistItems,
+		dataDir: dir,
+		// More than any test machine has free
+		minFree: 1 << 60,
+		appToml: appToml,
+	}
+	data, err := queryUpgrade(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.update(data)
+	c.refreshes.Wait()
+	report := c.snapshot()
+	statuses := map[string]string{}
+	for _, r := range report.Checks {
+		statuses[r.Check] = r.Status
+	}
+	want := map[string]string{
+		checkDiskSpace:    checkFail,
+		checkStagedBinary: checkSkip,
+		checkNodeSynced:   checkPass,
+		checkHaltHeight:   checkFail,
+		checkNodeVersion:  checkPass,
+		checkSignalled:    checkSkip,
+		checkAlerting:     checkSkip,
+	}
+	for check, status := range want {
+		if statuses[check] != status {
+			t.Errorf("%s is %s, want %s", check, statuses[check], status)
+		}
+	}
+	if report.Status != checkFail || report.Summary[checkFail] != 2 || report.Summary[checkPass] != 2 {
+		t.Errorf("got status %s and summary %v", report.Status, report.Summary)
+	}
+	if got := testutil.ToFloat64(readinessCheck.WithLabelValues(checkHaltHeight)); got != 2 {
+		t.Errorf("halt_height metric is %v, want 2", got)
+	}
+
+	// Network checks keep their last results until networkInterval passes
+	node.SetSyncing(true)
+	node.SetNodeVersion("v1.9.0", "def456")
+	c.networkInterval = time.Hour
+	c.update(data)
+	c.refreshes.Wait()
+	for _, r := range c.snapshot().Checks {
+		if (r.Check == checkNodeSynced || r.Check == checkNodeVersion) && r.Status != checkPass {
+			t.Errorf("%s is %s before the interval passed, want the cached pass", r.Check, r.Status)
+		}
+	}
+	c.networkInterval = 0
+	c.update(data)
+	c.refreshes.Wait()
+	for _, r := range c.snapshot().Checks {
+		if (r.Check == checkNodeSynced || r.Check == checkNodeVersion) && r.Status != checkFail {
+			t.Errorf("%s is %s, want fail", r.Check, r.Status)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of node log lines the log watcher picks out
+const (
+	logUpgradeNeeded    = "upgrade_needed"
+	logHalt             = "halt"
+	logAppVersionSwitch = "app_version_switch"
+	logPanic            = "panic"
+	logConsensusFailure = "consensus_failure"
+)
+
+// logPatterns are checked in order; the first match wins.
+var logPatterns = []struct {
+	match string
+	re    *regexp.Regexp
+}{
+	{logConsensusFailure, regexp.MustCompile(`(?i)CONSENSUS FAILURE|wrong Block\.Header\.AppHash|wrong Block\.Header\.LastResultsHash`)},
+	{logPanic, regexp.MustCompile(`(?:^|[\s"])panic:`)},
+	{logUpgradeNeeded, regexp.MustCompile(`UPGRADE "[^"]*" NEEDED|(?i:upgrade-info\.json written)`)},
+	{logHalt, regexp.MustCompile(`(?i)halt(ing)? (the )?(node|chain)? ?per configuration|halt-height|halt height reached`)},
+	{logAppVersionSwitch, regexp.MustCompile(`(?i)upgraded from app version|switch(ed|ing)? to app version|app version (changed|switched)`)},
+}
+
+var (
+	logHeightPattern = regexp.MustCompile(`\bheight[=:]"?(\d+)`)
+	// ansiPattern matches the colour codes of console log output
+	ansiPattern = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+	// journalFieldPattern matches the fields of journalctl -o export output
+	journalFieldPattern = regexp.MustCompile(`^_*[A-Z0-9_]+=`)
+)
+
+// logThrottle is how long repeats of the same kind of match at the same
+// severity are only counted, so a crash loop doesn't flood the event log.
+const logThrottle = time.Minute
+
+// logWatcher tails celestia-appd logs, a plain log file or a journalctl
+// -o json or -o export dump, and emits events for upgrade related lines.
+type logWatcher struct {
+	path string
+
+	mu     sync.Mutex
+	state  lifecycleState
+	height int64
+	// lastUpgradeHeight is the latest upgrade height seen, kept after the
+	// upgrade is no longer scheduled
+	lastUpgradeHeight int64
+	// lastEmitted is keyed by match and severity
+	lastEmitted map[[2]string]time.Time
+}
+
+// logWatch is set when -node-log is given, nil otherwise.
+var logWatch *logWatcher
+
+func newLogWatcher(path string) *logWatcher {
+	return &logWatcher{path: path, lastEmitted: map[[2]string]time.Time{}}
+}
+
+// setState records the lifecycle state and height of the latest poll, for
+// matches to be put in context.
+func (w *logWatcher) setState(data UpgradeData, state lifecycleState) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.state = state
+	w.height = data.Height
+	if upgradeHeight := data.UpgradeData.Upgrade.UpgradeHeight; upgradeHeight > 0 {
+		w.lastUpgradeHeight = upgradeHeight
+	}
+}
+
+// run follows the log file from its end, reopening it from the start when
+// it is rotated or truncated, until ctx is cancelled.
+func (w *logWatcher) run(ctx context.Context, interval time.Duration) {
+	var (
+		f       *os.File
+		reader  *bufio.Reader
+		offset  int64
+		partial string
+		failed  bool
+	)
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+	wait := func() bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(interval):
+			return true
+		}
+	}
+	fromEnd := true
+	for ctx.Err() == nil {
+		if f == nil {
+			var err error
+			if f, err = os.Open(w.path); err != nil {
+				if !failed {
+					log.Printf("Failed to open node log: %v", err)
+					failed = true
+				}
+				// A log that appears later is read from its start
+				fromEnd = false
+				if !wait() {
+					return
+				}
+				continue
+			}
+			failed = false
+			offset = 0
+			if fromEnd {
+				if offset, err = f.Seek(0, io.SeekEnd); err != nil {
+					log.Printf("Failed to seek node log: %v", err)
+				}
+				fromEnd = false
+			}
+			reader = bufio.NewReader(f)
+			partial = ""
+		}
+
+		line, err := reader.ReadString('\n')
+		offset += int64(len(line))
+		if err == nil {
+			w.handle(partial + line)
+			partial = ""
+			continue
+		}
+		partial += line
+		if err != io.EOF {
+			log.Printf("Failed to read node log: %v", err)
+		}
+		if logRotated(w.path, f, offset) {
+			if partial != "" {
+				w.handle(partial)
+			}
+			f.Close()
+			f = nil
+			continue
+		}
+		if !wait() {
+			return
+		}
+	}
+}
+
+// logRotated reports whether path no longer is the open file, or the file
+// was truncated below what has been read.
+func logRotated(path string, f *os.File, offset int64) bool {
+	current, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	open, err := f.Stat()
+	if err != nil {
+		return true
+	}
+	return !os.SameFile(current, open) || current.Size() < offset
+}
+
+// logMessage extracts the log message from a line, unwrapping journald
+// entries. ok is false for journald fields other than the message.
+func logMessage(line string) (string, bool) {
+	line = strings.TrimRight(line, "\r\n")
+	if strings.HasPrefix(line, "{") {
+		var entry map[string]any
+		if json.Unmarshal([]byte(line), &entry) == nil {
+			if msg, ok := entry["MESSAGE"].(string); ok {
+				line = msg
+			}
+		}
+	} else if msg, ok := strings.CutPrefix(line, "MESSAGE="); ok {
+		line = msg
+	} else if journalFieldPattern.MatchString(line) {
+		return "", false
+	}
+	return ansiPattern.ReplaceAllString(line, ""), true
+}
+
+// matchLogLine returns the kind of upgrade related line msg is, if any.
+func matchLogLine(msg string) (string, bool) {
+	for _, p := range logPatterns {
+		if p.re.MatchString(msg) {
+			return p.match, true
+		}
+	}
+	return "", false
+}
+
+// handle checks one log line and emits an event when it matches. Halting
+// for an upgrade is expected in the final window; a panic is critical from
+// the upgrade height on, when it usually means the new binary can't run the
+// new app version.
+func (w *logWatcher) handle(line string) {
+	msg, ok := logMessage(line)
+	if !ok {
+		return
+	}
+	match, ok := matchLogLine(msg)
+	if !ok {
+		return
+	}
+	nodeLogMatches.WithLabelValues(match).Inc()
+
+	w.mu.Lock()
+	state, height, upgradeHeight := w.state, w.height, w.lastUpgradeHeight
+	w.mu.Unlock()
+	if state == "" {
+		// Nothing polled yet
+		state = "unknown"
+	}
+	if m := logHeightPattern.FindStringSubmatch(msg); m != nil {
+		if h, err := strconv.ParseInt(m[1], 10, 64); err == nil {
+			height = h
+		}
+	}
+	afterUpgrade := upgradeHeight > 0 && height >= upgradeHeight
+
+	severity := severityWarning
+	switch match {
+	case logUpgradeNeeded, logHalt:
+		if state != stateFinal && !afterUpgrade {
+			severity = severityCritical
+		}
+	case logAppVersionSwitch:
+		severity = severityInfo
+	case logPanic:
+		if afterUpgrade {
+			severity = severityCritical
+		}
+	case logConsensusFailure:
+		severity = severityCritical
+	}
+
+	// Repeats are throttled per severity, so a match turning critical, e.g.
+	// a panic once the upgrade height is reached, is never held back
+	now := time.Now()
+	key := [2]string{match, severity}
+	w.mu.Lock()
+	throttled := now.Sub(w.lastEmitted[key]) < logThrottle
+	if !throttled {
+		w.lastEmitted[key] = now
+	}
+	w.mu.Unlock()
+	if throttled {
+		return
+	}
+
+	if len(msg) > 500 {
+		msg = msg[:500] + "..."
+	}
+	emitEvent(Event{
+		Kind:     "node_log_" + match,
+		Severity: severity,
+		Message:  fmt.Sprintf("node log shows %s while %s: %s", strings.ReplaceAll(match, "_", " "), state, msg),
+		Height:   height,
+		Fields: map[string]string{
+			"match":          match,
+			"lifecycle":      string(state),
+			"upgrade_height": strconv.FormatInt(upgradeHeight, 10),
+			"line":           msg,
+		},
+	})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMatchLogLine(t *testing.T) {
+	tests := []struct {
+		line      string
+		wantMatch string
+		wantOK    bool
+	}{
+		{"3:04PM ERR CONSENSUS FAILURE!!! err=\"wrong Block.Header.AppHash\"", logConsensusFailure, true},
+		{"panic: runtime error: index out of range", logPanic, true},
+		{`{"MESSAGE":"panic: failed to run migrations","_SYSTEMD_UNIT":"celestia-appd.service"}`, logPanic, true},
+		{"MESSAGE=\x1b[31mERR\x1b[0m UPGRADE \"v2\" NEEDED at height: 200", logUpgradeNeeded, true},
+		{"3:05PM INF upgrade-info.json written height=200 name=v2", logUpgradeNeeded, true},
+		// Other mentions of upgrade-info.json don't mean the upgrade is due
+		{"3:04PM INF cosmovisor: no upgrade-info.json found, watching file=data/upgrade-info.json", "", false},
+		{"3:04PM ERR failed to read upgrade-info.json err=\"file does not exist\"", "", false},
+		{"3:05PM INF halting node per configuration height=150", logHalt, true},
+		{"3:06PM INF upgraded from app version 1 to 2", logAppVersionSwitch, true},
+		{"3:05PM INF finalizing commit of block height=199", "", false},
+		// The panic must start the message, not be part of another word
+		{"3:05PM INF nopanic: module loaded", "", false},
+		// Other journald fields are skipped
+		{"_SYSTEMD_UNIT=celestia-appd.service", "", false},
+	}
+	for _, tt := range tests {
+		match, ok := "", false
+		if msg, isMessage := logMessage(tt.line); isMessage {
+			match, ok = matchLogLine(msg)
+		}
+		if match != tt.wantMatch || ok != tt.wantOK {
+			t.Errorf("%q: got %q, %v, want %q, %v", tt.line, match, ok, tt.wantMatch, tt.wantOK)
+		}
+	}
+}
+
+func TestLogWatcher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "celestia-appd.log")
+	if err := os.WriteFile(path, []byte("3:04PM ERR CONSENSUS FAILURE!!! old line before the monitor started\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w := newLogWatcher(path)
+	w.setState(UpgradeData{Height: 190, UpgradeData: UpgradeResponse{Upgrade: Upgrade{AppVersion: 2, UpgradeHeight: 200}}}, stateFinal)
+	before := len(recentEvents())
+	panics := testutil.ToFloat64(nodeLogMatches.WithLabelValues(logPanic))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped := make(chan struct{})
+	go func() {
+		w.run(ctx, 10*time.Millisecond)
+		close(stopped)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lines := []string{
+		"3:05PM INF finalizing commit of block height=199 module=consensus",
+		"panic: peer reactor crashed height=199",
+		`{"MESSAGE":"3:06PM INF upgraded from app version 1 to 2 height=200","_SYSTEMD_UNIT":"celestia-appd.service"}`,
+		"_SYSTEMD_UNIT=celestia-appd.service",
+		"MESSAGE=panic: failed to run migrations height=201",
+		"panic: second panic in a crash loop height=202",
+	}
+	if _, err := f.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []Event
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if got = recentEvents()[before:]; len(got) >= 3 {
+			break
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	got = recentEvents()[before:]
+	// The critical panic isn't throttled by the warning before it, but its
+	// repeat is
+	if len(got) != 3 {
+		t.Fatalf("want a panic, a switch and one more panic event, got %+v", got)
+	}
+	if got[0].Kind != "node_log_panic" || got[0].Severity != severityWarning || got[0].Height != 199 {
+		t.Errorf("got %+v, want a warning for a panic at height 199", got[0])
+	}
+	if got[1].Kind != "node_log_app_version_switch" || got[1].Fields["lifecycle"] != string(stateFinal) {
+		t.Errorf("got %+v, want an app version switch during the final window", got[1])
+	}
+	if got[2].Kind != "node_log_panic" || got[2].Severity != severityCritical || got[2].Height != 201 {
+		t.Errorf("got %+v, want a critical panic at height 201", got[2])
+	}
+	if n := testutil.ToFloat64(nodeLogMatches.WithLabelValues(logPanic)) - panics; n != 3 {
+		t.Errorf("counted %v panics, want 3", n)
+	}
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Error("log watcher didn't stop when cancelled")
+	}
+}
@@ -3,16 +3,18 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
-	signaltypes "celestia-upgrade-monitor/celestia/signal/v1"
-
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"google.golang.org/grpc"
@@ -27,18 +29,94 @@ func init() {
 		tallyThresholdPower,
 		tallyTotalVotingPower,
 		tallyThresholdPercent,
+		tallyVotingPower,
+		tallyVotingPercent,
+		pollInterval,
+		chainHeight,
+		chainAppVersion,
+		chainHalted,
+		websocketConnected,
+		eventsTotal,
+		lastSuccessfulPoll,
+		pollDuration,
+		rpcErrors,
+		rpcDuration,
+		snapshotAgeSeconds,
+		upgradeVerified,
+		tryUpgradeSubmissions,
+		authzGrantExpiry,
+		authzGrantExpiring,
+		ownValidatorSignalled,
+		ownValidatorAlertLevel,
+		nodeReady,
+		nodeInfo,
+		fleetNodeCheck,
+		fleetNodeHeight,
+		fleetNodeLag,
+		fleetNodeAppVersion,
+		cosmovisorCheck,
+		cosmovisorUpgradeInfoHeight,
+		nodeLogMatches,
+		readinessCheck,
+		readinessSummary,
 	)
 }
 
-type grpcAddress struct {
-	addr   string
-	useTLS bool
+// Transports an endpoint can be queried over
+const (
+	transportGRPC   = "grpc"
+	transportREST   = "rest"
+	transportComet  = "comet"
+	transportReplay = "replay"
+)
+
+type endpointAddress struct {
+	transport string
+	addr      string
+	useTLS    bool
+	tls       *tlsConfig
+	auth      *authConfig
+}
+
+// String returns the address with any embedded credentials redacted, for
+// logs, metrics and status output.
+func (a endpointAddress) String() string {
+	return redactAddress(a.addr)
+}
+
+// parseEndpointAddress parses an endpoint address, picking the transport
+// from its scheme: rest+https:// selects the REST API, comet+https:// the
+// CometBFT RPC, replay:// a recording, and anything else is a gRPC address.
+func parseEndpointAddress(addr string) (endpointAddress, error) {
+	if path, ok := strings.CutPrefix(addr, transportReplay+"://"); ok {
+		if path == "" {
+			return endpointAddress{}, fmt.Errorf("replay address must look like replay://path/to/recording.jsonl")
+		}
+		return endpointAddress{transport: transportReplay, addr: path}, nil
+	}
+	for _, transport := range []string{transportREST, transportComet} {
+		httpURL, ok := strings.CutPrefix(addr, transport+"+")
+		if !ok {
+			continue
+		}
+		u, err := url.Parse(httpURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return endpointAddress{}, fmt.Errorf("%s address must look like %s+https://host[:port]", transport, transport)
+		}
+		return endpointAddress{
+			transport: transport,
+			addr:      strings.TrimSuffix(httpURL, "/"),
+			useTLS:    u.Scheme == "https",
+		}, nil
+	}
+	return parseGrpcAddress(addr)
 }
 
-func parseGrpcAddress(addr string) (grpcAddress, error) {
-	result := grpcAddress{
-		addr:   addr,
-		useTLS: false,
+func parseGrpcAddress(addr string) (endpointAddress, error) {
+	result := endpointAddress{
+		transport: transportGRPC,
+		addr:      addr,
+		useTLS:    false,
 	}
 
 	if strings.HasPrefix(addr, "https://") {
@@ -56,60 +134,313 @@ func parseGrpcAddress(addr string) (grpcAddress, error) {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "backfill":
+			runBackfill(os.Args[2:])
+			return
+		case "simulate":
+			runSimulate(os.Args[2:])
+			return
+		case "signal":
+			runSignal(os.Args[2:])
+			return
+		}
+	}
+	runMonitor(os.Args[1:])
+}
+
+// runMonitor parses the monitor's flags from args and runs it until the
+// HTTP server fails.
+func runMonitor(args []string) {
 	log.Println("Starting gRPC client...")
 
 	// Define flags for gRPC server address and HTTP server port
-	addr := flag.String("grpc-addr", "string", "gRPC server address with port (e.g., host:443 or https://host:443)")
-	port := flag.String("server-port", "string", "HTTP server port, used to serve JSON data from this HTTP server")
-	flag.Parse()
-
-	if *addr == "" || *addr == "string" {
+	userHome, _ := os.UserHomeDir()
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	addr := fs.String("grpc-addr", "string", "gRPC server address with port (e.g., host:443 or https://host:443), rest+https://host for the REST API or comet+http://host:26657 for the CometBFT RPC; a comma-separated list fails over between them")
+	port := fs.String("server-port", "string", "HTTP server port, used to serve JSON data from this HTTP server")
+	targetVersion := fs.Uint64("target-version", 0, "App version to tally signals for (0 = current app version + 1)")
+	pollIdle := fs.Duration("poll-idle", 30*time.Minute, "Poll interval while no validator is signalling")
+	pollSignalling := fs.Duration("poll-signalling", 5*time.Minute, "Poll interval while validators are signalling")
+	pollScheduled := fs.Duration("poll-scheduled", time.Minute, "Poll interval once an upgrade height is scheduled")
+	pollFinalBlocks := fs.Int64("poll-final-blocks", 300, "Poll every block once the upgrade height is this many blocks away")
+	blockTime := fs.Duration("block-time", 6*time.Second, "Expected block time, used to poll every block near the upgrade")
+	pollMin := fs.Duration("poll-min", time.Second, "Lower bound for the poll interval")
+	pollMax := fs.Duration("poll-max", time.Hour, "Upper bound for the poll interval")
+	pollJitter := fs.Float64("poll-jitter", 0.1, "Random jitter applied to the poll interval, as a fraction of it")
+	rpcAddr := fs.String("rpc-addr", "", "Optional CometBFT RPC address to follow new blocks over websocket (e.g., http://host:26657)")
+	haltBlocks := fs.Float64("halt-blocks", 10, "Report a chain halt when no block arrives within this many average block times")
+	webhooks := fs.String("webhook-url", "", "Optional comma-separated list of URLs to POST events to as JSON")
+	height := fs.Int64("height", 0, "Print the upgrade info and tally at this height as JSON and exit")
+	historyFile := fs.String("history-file", "", "Optional JSON lines file to persist signalling history to")
+	configFile := fs.String("config", "", "Optional JSON config file with per-endpoint settings")
+	fs.BoolVar(&allowPlaintextAuth, "allow-plaintext-auth", false, "Allow endpoint credentials to be sent without TLS")
+	fs.BoolVar(&allowInlineSecrets, "allow-inline-secrets", false, "Allow secrets to be given inline in the config rather than by file or env")
+	verify := fs.Bool("verify", false, "Verify responses with Merkle proofs and a light client, using -rpc-addr as the primary")
+	trustedHeight := fs.Int64("trusted-height", 0, "Height of the trusted header the light client starts from")
+	trustedHash := fs.String("trusted-hash", "", "Hex hash of the trusted header the light client starts from")
+	trustPeriod := fs.Duration("trust-period", 14*24*time.Hour, "Light client trusting period, well below the unbonding period")
+	lightWitnesses := fs.String("light-witnesses", "", "Comma-separated CometBFT RPC addresses the light client cross-checks headers with")
+	readyMaxAge := fs.Duration("ready-max-age", time.Hour, "Report not ready when the latest successful poll is older than this")
+	tryUpgradeFrom := fs.String("try-upgrade-from", "", "Optional keyring key to send MsgTryUpgrade from once the target version reaches the threshold")
+	keyringBackend := fs.String("keyring-backend", "file", "Keyring backend for -try-upgrade-from: file or test")
+	keyringDir := fs.String("keyring-dir", filepath.Join(userHome, ".celestia-app"), "Directory holding the keyring for -try-upgrade-from")
+	gasPrices := fs.String("gas-prices", "0.002utia", "Gas price to pay MsgTryUpgrade fees at, or the node's minimum gas price if higher")
+	maxFee := fs.String("max-fee", "100000utia", "Don't send MsgTryUpgrade if its fee would be higher than this")
+	authzGrants := fs.String("authz-grants", "", "Optional comma-separated valoper=grantee pairs whose MsgSignalVersion authz grants to check")
+	authzWarnBefore := fs.Duration("authz-warn-before", 30*24*time.Hour, "Warn when a grant from -authz-grants expires within this")
+	ownValidators := fs.String("own-validators", "", "Optional comma-separated operator addresses of our validators to check have signalled")
+	selfAlertAt := fs.String("self-alert-at", "50,quorum-5", "Escalating milestones at which to alert that -own-validators haven't signalled: percentages of power signalled, quorum or quorum-N")
+	selfSearchInterval := fs.Duration("self-search-interval", 10*time.Minute, "How often to search transactions for a signal from -own-validators not yet seen on the websocket")
+	ownNodes := fs.String("own-nodes", "", "Optional comma-separated addresses of our own nodes, in -grpc-addr form, to check are ready for the upgrade")
+	minRelease := fs.String("min-release", "", "Optional comma-separated appversion=release pairs giving the oldest celestia-app release each app version needs (e.g., 4=v4.0.2)")
+	nodeAlertBlocks := fs.Int64("node-alert-blocks", 14400, "Alert as critical when one of -own-nodes isn't ready this many blocks before the upgrade height")
+	fleetMaxLag := fs.Int64("fleet-max-lag", 10, "Blocks a fleet node from the config file may be behind the highest height seen before it counts as lagging")
+	cosmovisorHome := fs.String("cosmovisor-home", "", "Optional $DAEMON_HOME of a node run by cosmovisor, whose staged upgrade binaries and upgrade-info.json to check")
+	cosmovisorUpgradeName := fs.String("cosmovisor-upgrade-name", "v%d", "Cosmovisor upgrade directory name, formatted with the app version")
+	cosmovisorManifest := fs.String("cosmovisor-manifest", "", "Optional sha256sum style file of checksums for binaries under -cosmovisor-home/cosmovisor")
+	nodeLog := fs.String("node-log", "", "Optional celestia-appd log file, or journalctl -o json or -o export output, to watch for upgrade related lines")
+	readinessChecks := fs.String("readiness-checks", strings.Join(checklistItems, ","), "Comma-separated pre-upgrade checklist items to run for /readiness")
+	readinessNode := fs.String("readiness-node", "", "Optional address of the node to check for sync and version in /readiness, in -grpc-addr form; the monitored endpoints by default")
+	readinessDataDir := fs.String("readiness-data-dir", "", "Node data directory to check free space on; -cosmovisor-home/data by default")
+	readinessMinFree := fs.Float64("readiness-min-free-gib", 50, "Free GiB on -readiness-data-dir below which disk_space fails; it warns below twice this")
+	readinessAppToml := fs.String("readiness-app-toml", "", "Node app.toml to check halt-height in; -cosmovisor-home/config/app.toml by default")
+	checkInterval := fs.Duration("check-interval", time.Minute, "How often the -own-validators, -own-nodes, fleet and -cosmovisor-home checks run, each in the background against the latest poll")
+	readinessNetworkInterval := fs.Duration("readiness-network-interval", time.Minute, "How often the node_synced, node_version and alerting checks run; their last results are reported in between")
+	recordFile := fs.String("record", "", "Optional JSON lines file to record every upstream request and response to, for replay:// endpoints")
+	fs.Parse(args)
+
+	var (
+		err        error
+		fleetNodes []fleetNode
+	)
+	if *addr != "" && *addr != "string" {
+		endpoints, err = parseEndpoints(*addr)
+		if err != nil {
+			log.Fatalf("Invalid gRPC address: %v", err)
+		}
+	}
+	if *configFile != "" {
+		cfg, err := loadConfig(*configFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fromConfig, err := configEndpoints(cfg)
+		if err != nil {
+			log.Fatalf("Invalid endpoint in config: %v", err)
+		}
+		endpoints = append(endpoints, fromConfig...)
+		fleetNodes, err = configFleet(cfg)
+		if err != nil {
+			log.Fatalf("Invalid fleet in config: %v", err)
+		}
+	}
+	if len(endpoints) == 0 {
 		log.Fatal("gRPC server address must be provided using -grpc-addr flag with explicit port (e.g., host:443)")
 	}
-
-	parsedAddr, err := parseGrpcAddress(*addr)
-	if err != nil {
-		log.Fatalf("Invalid gRPC address: %v", err)
+	if err := checkReplayFlags(fs, endpoints); err != nil {
+		log.Fatal(err)
 	}
-	GrpcServerAddress = parsedAddr.addr
-	GrpcUseTLS = parsedAddr.useTLS
 	HttpServerPort = *port
+	TargetVersion = *targetVersion
+	ReadyMaxAge = *readyMaxAge
+	LivenessMaxAge = 2**pollMax + time.Minute
 
-	log.Printf("Connecting to gRPC server at: %s (TLS: %v)", GrpcServerAddress, GrpcUseTLS)
+	for _, e := range endpoints {
+		log.Printf("Connecting to %s server at: %s (TLS: %v)", e.address.transport, e.address, e.address.useTLS)
+	}
+
+	if *recordFile != "" {
+		recorder, err = openRecorder(*recordFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Recording upstream calls to %s", *recordFile)
+		if *verify || *rpcAddr != "" || *tryUpgradeFrom != "" {
+			log.Println("WARNING: -verify, -rpc-addr and -try-upgrade-from calls aren't recorded and can't be replayed")
+		}
+	}
+
+	if *verify {
+		if *rpcAddr == "" || *trustedHeight <= 0 || *trustedHash == "" {
+			log.Fatal("-verify needs -rpc-addr, -trusted-height and -trusted-hash")
+		}
+		hash, err := hex.DecodeString(*trustedHash)
+		if err != nil {
+			log.Fatalf("Invalid trusted hash: %v", err)
+		}
+		witnesses := []string{*rpcAddr}
+		if *lightWitnesses != "" {
+			witnesses = strings.Split(*lightWitnesses, ",")
+		} else {
+			log.Println("WARNING: no -light-witnesses given, the light client cannot detect a lying primary")
+		}
+		verifier, err = newStateVerifier(context.Background(), *rpcAddr, witnesses, *trustedHeight, hash, *trustPeriod)
+		if err != nil {
+			log.Fatalf("Failed to set up verification: %v", err)
+		}
+		log.Printf("Verifying responses against light client headers for chain %s", verifier.chainID)
+		addBackgroundCheck("verify", 0, verifyPoll)
+	}
+
+	if *tryUpgradeFrom != "" {
+		autoTry, err = setupTryUpgrade(*tryUpgradeFrom, *keyringBackend, *keyringDir, *gasPrices, *maxFee)
+		if err != nil {
+			log.Fatalf("Failed to set up -try-upgrade-from: %v", err)
+		}
+	}
+
+	if *height > 0 {
+		if err := printUpgradeAt(*height); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
 	if HttpServerPort == "" || HttpServerPort == "string" {
 		log.Println("HTTP server port not provided, defaulting to :8080")
 		HttpServerPort = "8080"
 	}
 
-	// Start Prometheus metrics update func
-	go func() {
-		for {
-			log.Println("Querying upgrade status for Prometheus /metrics...")
-			updatePromMetrics()
-			time.Sleep(30 * time.Minute)
+	schedule := pollSchedule{
+		IdleInterval:       *pollIdle,
+		SignallingInterval: *pollSignalling,
+		ScheduledInterval:  *pollScheduled,
+		FinalWindow:        *pollFinalBlocks,
+		BlockTime:          *blockTime,
+		MinInterval:        *pollMin,
+		MaxInterval:        *pollMax,
+		Jitter:             *pollJitter,
+	}
+
+	history, err = openHistory(*historyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *webhooks != "" {
+		events.webhooks = strings.Split(*webhooks, ",")
+	}
+
+	// Follow new blocks and signal transactions in real time if a CometBFT
+	// RPC address is given
+	if *rpcAddr != "" {
+		sub, err := newCometSubscriber(*rpcAddr)
+		if err != nil {
+			log.Fatalf("Invalid RPC address: %v", err)
 		}
-	}()
+		watcher = newBlockWatcher(*blockTime, *haltBlocks)
+		watcher.watch(context.Background(), sub)
+		subscribeSignalTxs(sub)
+		go sub.run(context.Background())
+	}
 
-	// Start the HTTP server
-	go httpServer()
-	if err := http.ListenAndServe(":8080", nil); err != nil {
-		log.Fatalf("Failed to start HTTP server: %v", err)
+	var grants []grantWatch
+	if *authzGrants != "" {
+		grants, err = parseGrantWatches(*authzGrants)
+		if err != nil {
+			log.Fatalf("Invalid -authz-grants: %v", err)
+		}
+		go newGrantWatcher(grants, *authzWarnBefore).run(time.Hour)
 	}
-	log.Println("HTTP server started on :8080")
-	log.Println("gRPC client and HTTP server are running...")
 
-	select {}
+	if *ownValidators != "" {
+		milestones, err := parseSelfMilestones(*selfAlertAt)
+		if err != nil {
+			log.Fatalf("Invalid -self-alert-at: %v", err)
+		}
+		var valopers []string
+		for _, v := range strings.Split(*ownValidators, ",") {
+			if _, err := valoperAccount(strings.TrimSpace(v)); err != nil {
+				log.Fatalf("Invalid -own-validators: %v", err)
+			}
+			valopers = append(valopers, strings.TrimSpace(v))
+		}
+		selfCheck = newSelfChecker(valopers, milestones, grants, *selfSearchInterval)
+		addBackgroundCheck("own-validators", *checkInterval, selfCheck.update)
+	}
+
+	releases, err := parseMinReleases(*minRelease)
+	if err != nil {
+		log.Fatalf("Invalid -min-release: %v", err)
+	}
+	if *ownNodes != "" {
+		nodes, err := parseEndpoints(*ownNodes)
+		if err != nil {
+			log.Fatalf("Invalid -own-nodes: %v", err)
+		}
+		nodeCheck = newNodeChecker(nodes, releases, *nodeAlertBlocks)
+		addBackgroundCheck("own-nodes", *checkInterval, nodeCheck.update)
+	}
+	if len(fleetNodes) > 0 {
+		fleet = newFleetChecker(fleetNodes, releases, *fleetMaxLag, *nodeAlertBlocks)
+		addBackgroundCheck("fleet", *checkInterval, fleet.update)
+		log.Printf("Checking %d fleet nodes every %s", len(fleetNodes), *checkInterval)
+	}
+	if *cosmovisorHome != "" {
+		var manifest map[string]string
+		if *cosmovisorManifest != "" {
+			if manifest, err = loadManifest(*cosmovisorManifest); err != nil {
+				log.Fatalf("Invalid -cosmovisor-manifest: %v", err)
+			}
+		}
+		cosmovisor = newCosmovisorChecker(*cosmovisorHome, *cosmovisorUpgradeName, manifest, releases, *nodeAlertBlocks)
+		addBackgroundCheck("cosmovisor", *checkInterval, cosmovisor.update)
+		go cosmovisor.watchUpgradeInfo(2 * time.Second)
+	}
+	items, err := parseChecklistItems(*readinessChecks)
+	if err != nil {
+		log.Fatalf("Invalid -readiness-checks: %v", err)
+	}
+	if len(items) > 0 {
+		readinessChecklist = &checklist{
+			items:       items,
+			minReleases: releases,
+			dataDir:     *readinessDataDir,
+			minFree:     uint64(*readinessMinFree * (1 << 30)),
+			appToml:     *readinessAppToml,
+
+			networkInterval: *readinessNetworkInterval,
+		}
+		if *cosmovisorHome != "" {
+			if readinessChecklist.dataDir == "" {
+				readinessChecklist.dataDir = filepath.Join(*cosmovisorHome, "data")
+			}
+			if readinessChecklist.appToml == "" {
+				readinessChecklist.appToml = filepath.Join(*cosmovisorHome, "config", "app.toml")
+			}
+		}
+		if *readinessNode != "" {
+			node, err := parseEndpointAddress(*readinessNode)
+			if err != nil {
+				log.Fatalf("Invalid -readiness-node: %v", err)
+			}
+			readinessChecklist.node = &endpoint{address: node}
+		}
+	}
+	if *nodeLog != "" {
+		logWatch = newLogWatcher(*nodeLog)
+		go logWatch.run(context.Background(), time.Second)
+	}
+
+	// Start Prometheus metrics update func
+	go pollLoop(schedule)
+
+	// Start the HTTP server; this blocks until it fails
+	log.Println("gRPC client and HTTP server are running...")
+	httpServer()
 }
 
-func grpcClient(addr string) (*grpc.ClientConn, error) {
+func grpcClient(address endpointAddress) (*grpc.ClientConn, error) {
 	// Create a gRPC client connection to the specified address
 	// Use passthrough resolver to bypass gRPC's DNS resolver
+	addr := address.addr
 	target := "passthrough:///" + addr
 
 	var clientOptions grpc.DialOption
 
-	if GrpcUseTLS {
+	if address.useTLS {
 		// Extract hostname from address for ServerName
 		hostname := addr
 		if idx := strings.Index(addr, ":"); idx != -1 {
@@ -119,61 +450,160 @@ func grpcClient(addr string) (*grpc.ClientConn, error) {
 		tlsConfig := &tls.Config{
 			ServerName: hostname,
 		}
+		if address.tls != nil {
+			var err error
+			tlsConfig, err = clientTLSConfig(*address.tls, hostname)
+			if err != nil {
+				return nil, fmt.Errorf("invalid TLS settings for %s: %w", address, err)
+			}
+		}
 		tlsCredentials := credentials.NewTLS(tlsConfig)
 		clientOptions = grpc.WithTransportCredentials(tlsCredentials)
-		log.Printf("Using TLS credentials for gRPC connection (ServerName: %s)", hostname)
+		log.Printf("Using TLS credentials for gRPC connection (ServerName: %s)", tlsConfig.ServerName)
 	} else {
 		clientOptions = grpc.WithTransportCredentials(insecure.NewCredentials())
 		log.Println("Using insecure credentials for gRPC connection")
 	}
 
-	log.Printf("Attempting to connect to target: %s", target)
-	conn, err := grpc.NewClient(
-		target,
+	options := []grpc.DialOption{
 		clientOptions,
-	)
+		grpc.WithChainUnaryInterceptor(metricsInterceptor(address.String())),
+	}
+	if address.auth != nil {
+		options = append(options, grpc.WithPerRPCCredentials(address.auth))
+	}
+
+	log.Printf("Attempting to connect to target: passthrough:///%s", address)
+	conn, err := grpc.NewClient(target, options...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create client: %w", err)
 	}
 	return conn, nil
 }
 
-func getUpgrade(client signaltypes.QueryClient) (UpgradeData, error) {
-	// Create a context with a timeout for the gRPC request
+// pollNow wakes the poll loop early, e.g. when a websocket event shows that
+// the chain state changed.
+var pollNow = make(chan struct{}, 1)
+
+func requestPoll() {
+	select {
+	case pollNow <- struct{}{}:
+	default:
+	}
+}
+
+// pollLoop keeps the Prometheus metrics up to date, polling more often as
+// the upgrade gets closer.
+func pollLoop(schedule pollSchedule) {
+	var lastState lifecycleState
+	for {
+		log.Println("Querying upgrade status for Prometheus /metrics...")
+		markPollAttempt()
+		state := stateIdle
+		var blocksRemaining int64
+		if resp, err := updatePromMetrics(); err == nil {
+			if err := history.record(sampleFromUpgrade(resp)); err != nil {
+				log.Printf("Failed to record history: %v", err)
+			}
+			// Prefer the websocket height when it is ahead of the poll
+			if watcher != nil {
+				watcher.setUpgradeHeight(resp.UpgradeData.Upgrade.UpgradeHeight)
+				if height, ok := watcher.latestHeight(); ok && height > resp.Height {
+					resp.Height = height
+				}
+			}
+			state = lifecycle(resp, schedule.FinalWindow)
+			blocksRemaining = resp.UpgradeData.Upgrade.UpgradeHeight - resp.Height
+			if lastState != "" && state != lastState {
+				emitEvent(Event{
+					Kind:    "lifecycle_changed",
+					Message: fmt.Sprintf("lifecycle state changed from %s to %s", lastState, state),
+					Height:  resp.Height,
+					Fields:  map[string]string{"from": string(lastState), "to": string(state)},
+				})
+			}
+			lastState = state
+			if logWatch != nil {
+				logWatch.setState(resp, state)
+			}
+			if autoTry != nil {
+				autoTry.check(resp)
+			}
+			for _, c := range backgroundChecks {
+				c.offer(resp)
+			}
+			// Uses the latest results of the background checks
+			if readinessChecklist != nil {
+				readinessChecklist.update(resp)
+			}
+		}
+
+		interval := schedule.next(state, blocksRemaining)
+		pollInterval.Set(interval.Seconds())
+		log.Printf("Lifecycle state %s, next poll in %s", state, interval)
+		select {
+		case <-time.After(interval):
+		case <-pollNow:
+		}
+	}
+}
+
+// getUpgrade returns the upgrade info and tally at the given height, or at
+// the latest height when height is 0. Both queries are pinned to the same
+// height so they describe the same block.
+func getUpgrade(b backend, height int64) (UpgradeData, error) {
+	// Create a context with a timeout for the upstream requests
 	// Used for the Prometheus /metrics endpoint
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	// Get the upgrade information from the gRPC client
-	resp, err := client.GetUpgrade(ctx, &signaltypes.QueryGetUpgradeRequest{})
+	// Get the height and app version to pin to and work out the target version
+	block, err := b.Block(ctx, height)
 	if err != nil {
-		return UpgradeData{}, fmt.Errorf("failed to get upgrade: %w", err)
+		return UpgradeData{}, err
+	}
+	height = block.Height
+	version := TargetVersion
+	if version == 0 {
+		version = block.AppVersion + 1
 	}
-	jsonBytes, err := json.Marshal(resp)
+
+	// Get the upgrade information
+	upgrade, served, err := b.GetUpgrade(ctx, block.Height)
 	if err != nil {
-		log.Fatalf("marshal failed: %v", err)
+		return UpgradeData{}, fmt.Errorf("failed to get upgrade: %w", err)
 	}
-	var upgrade UpgradeResponse
-	if err := json.Unmarshal(jsonBytes, &upgrade); err != nil {
-		log.Fatalf("unmarshal failed: %v", err)
+	if served > 0 {
+		height = served
 	}
 
-	// Get the version tally information from the gRPC client
-	tally, err := client.VersionTally(ctx, &signaltypes.QueryVersionTallyRequest{})
+	// Get the version tally information
+	tally, _, err := b.VersionTally(ctx, version, block.Height)
 	if err != nil {
 		return UpgradeData{}, fmt.Errorf("failed to get version tally: %w", err)
 	}
 
 	// Prepare the return data
-	percent := float64(tally.ThresholdPower) / float64(tally.TotalVotingPower)
+	var percent, votingPercent float64
+	if tally.TotalVotingPower > 0 {
+		percent = float64(tally.ThresholdPower) / float64(tally.TotalVotingPower)
+		votingPercent = float64(tally.VotingPower) / float64(tally.TotalVotingPower)
+	}
 	returnData := UpgradeData{
+		ChainID:    block.ChainID,
+		Height:     height,
+		BlockTime:  block.Time,
+		AppVersion: block.AppVersion,
 		UpgradeData: UpgradeResponse{
 			Upgrade: Upgrade{
-				AppVersion:    upgrade.Upgrade.AppVersion,
-				UpgradeHeight: upgrade.Upgrade.UpgradeHeight,
+				AppVersion:    int(upgrade.GetUpgrade().GetAppVersion()),
+				UpgradeHeight: upgrade.GetUpgrade().GetUpgradeHeight(),
 			},
 		},
 		TallyData: TallyResponse{
+			Version:          version,
+			VotingPower:      int64(tally.VotingPower),
+			VotingPercent:    votingPercent,
 			TotalVotingPower: int64(tally.TotalVotingPower),
 			ThresholdPower:   int64(tally.ThresholdPower),
 			ThresholdPercent: percent,
@@ -185,17 +615,28 @@ func getUpgrade(client signaltypes.QueryClient) (UpgradeData, error) {
 
 // HTTP server to responsed with JSON data from gRPC response
 func httpServer() {
-	http.HandleFunc("/upgrade", func(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Starting HTTP server on :%s", HttpServerPort)
+	log.Fatal(http.ListenAndServe(":"+HttpServerPort, newHTTPHandler()))
+}
+
+// newHTTPHandler returns the handler serving the JSON API, health checks
+// and metrics.
+func newHTTPHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/upgrade", func(w http.ResponseWriter, r *http.Request) {
 		// Handle the request and respond with JSON data
-		conn, err := grpcClient(GrpcServerAddress)
+		height, err := parseHeight(r.URL.Query().Get("height"))
 		if err != nil {
-			log.Printf("Failed to connect to gRPC server: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
-		defer conn.Close()
-		client := signaltypes.NewQueryClient(conn)
-		resp, err := getUpgrade(client)
+		resp, err := queryUpgrade(height)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to get upgrade: %v", err), http.StatusInternalServerError)
+			code := http.StatusInternalServerError
+			if isRequestError(err) {
+				code = http.StatusBadRequest
+			}
+			http.Error(w, fmt.Sprintf("Failed to get upgrade: %v", err), code)
 			return
 		}
 
@@ -204,52 +645,106 @@ func httpServer() {
 		log.Println("HTTP request handled successfully: /upgrade")
 	})
 
-	// Handle Prometheus metrics endpoint
-	http.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(recentEvents())
+	})
 
-	log.Printf("Starting HTTP server on :%s", HttpServerPort)
-	log.Fatal(http.ListenAndServe(":"+HttpServerPort, nil))
-}
+	mux.HandleFunc("/history", func(w http.ResponseWriter, r *http.Request) {
+		from, err := parseHeight(r.URL.Query().Get("from"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		to, err := parseHeight(r.URL.Query().Get("to"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(history.between(from, to))
+	})
 
-func updatePromMetrics() {
-	// Create a gRPC connection
-	conn, err := grpcClient(GrpcServerAddress)
-	if err != nil {
-		log.Printf("Failed to connect to gRPC server: %v", err)
-		return
-	}
-	defer conn.Close()
+	mux.HandleFunc("/signals", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(recentSignals())
+	})
 
-	// Create a gRPC client
-	client := signaltypes.NewQueryClient(conn)
-	resp, err := getUpgrade(client)
-	if err != nil {
-		log.Printf("Failed to get upgrade: %v", err)
-		return
-	}
+	mux.HandleFunc("/self", func(w http.ResponseWriter, r *http.Request) {
+		if selfCheck == nil {
+			http.Error(w, "no -own-validators configured", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(selfCheck.snapshot())
+	})
 
-	// Marshal the response to JSON
-	jsonBytes, err := json.Marshal(resp) // marshal just the Upgrade message
-	if err != nil {
-		log.Fatalf("marshal failed: %v", err)
-	}
-	var upgrade UpgradeResponse
-	if err := json.Unmarshal(jsonBytes, &upgrade); err != nil {
-		log.Fatalf("unmarshal failed: %v", err)
-	}
+	mux.HandleFunc("/nodes", func(w http.ResponseWriter, r *http.Request) {
+		if nodeCheck == nil {
+			http.Error(w, "no -own-nodes configured", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(nodeCheck.snapshot())
+	})
 
-	// Collect tally data
-	tally, err := client.VersionTally(context.Background(), &signaltypes.QueryVersionTallyRequest{})
+	mux.HandleFunc("/fleet", func(w http.ResponseWriter, r *http.Request) {
+		if fleet == nil {
+			http.Error(w, "no fleet in the -config file", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(fleet.snapshot())
+	})
+
+	mux.HandleFunc("/cosmovisor", func(w http.ResponseWriter, r *http.Request) {
+		if cosmovisor == nil {
+			http.Error(w, "no -cosmovisor-home configured", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(cosmovisor.snapshot())
+	})
+
+	mux.HandleFunc("/readiness", func(w http.ResponseWriter, r *http.Request) {
+		if readinessChecklist == nil {
+			http.Error(w, "no -readiness-checks configured", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(readinessChecklist.snapshot())
+	})
+
+	// Liveness and readiness probes
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.HandleFunc("/readyz", readyzHandler)
+
+	// Handle Prometheus metrics endpoint
+	mux.Handle("/metrics", promhttp.Handler())
+	return mux
+}
+
+// updatePromMetrics polls the endpoints and updates the metrics. In
+// verified mode the result is verified in the background by verifyPoll.
+func updatePromMetrics() (UpgradeData, error) {
+	start := time.Now()
+	resp, err := fetchUpgrade(0)
 	if err != nil {
-		log.Printf("Failed to get version tally: %v", err)
-		return
+		pollDuration.WithLabelValues("error").Observe(time.Since(start).Seconds())
+		log.Printf("Failed to get upgrade: %v", err)
+		return UpgradeData{}, err
 	}
+	pollDuration.WithLabelValues("success").Observe(time.Since(start).Seconds())
+	lastSuccessfulPoll.SetToCurrentTime()
+	setSnapshot(resp)
 
 	// Update Prometheus metrics
+	tally := resp.TallyData
 	tallyThresholdPower.Set(float64(tally.ThresholdPower))
 	tallyTotalVotingPower.Set(float64(tally.TotalVotingPower))
-	percent := float64(tally.ThresholdPower) / float64(tally.TotalVotingPower)
-	tallyThresholdPercent.Set(percent)
+	tallyThresholdPercent.Set(tally.ThresholdPercent)
+	tallyVotingPower.Set(float64(tally.VotingPower))
+	tallyVotingPercent.Set(tally.VotingPercent)
 	upgradeHeight.Set(float64(resp.UpgradeData.Upgrade.UpgradeHeight))
 	upgradeVersion.Set(float64(resp.UpgradeData.Upgrade.AppVersion))
 	if resp.UpgradeData.Upgrade.UpgradeHeight > 0 {
@@ -257,4 +752,15 @@ func updatePromMetrics() {
 	} else {
 		upgradeStatus.Set(0)
 	}
+	return resp, nil
+}
+
+// verifyPoll verifies a poll result and sets celestia_upgrade_verified.
+func verifyPoll(data UpgradeData) {
+	verifyUpgrade(&data)
+	if data.Verified {
+		upgradeVerified.Set(1)
+	} else {
+		upgradeVerified.Set(0)
+	}
 }
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// parseMinReleases parses a comma-separated list of appversion=release
+// pairs, such as 4=v4.0.2, giving the oldest celestia-app release a node
+// needs to run each app version.
+func parseMinReleases(s string) (map[uint64]string, error) {
+	releases := map[uint64]string{}
+	if s == "" {
+		return releases, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		version, release, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid release %q, expected appversion=release", pair)
+		}
+		v, err := strconv.ParseUint(version, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid app version in %q", pair)
+		}
+		if _, ok := parseRelease(release); !ok {
+			return nil, fmt.Errorf("invalid release in %q", pair)
+		}
+		releases[v] = release
+	}
+	return releases, nil
+}
+
+// release is a parsed vMAJOR.MINOR.PATCH[-pre] version.
+type release struct {
+	parts      [3]uint64
+	prerelease string
+}
+
+func parseRelease(s string) (release, bool) {
+	var r release
+	s = strings.TrimPrefix(s, "v")
+	s, r.prerelease, _ = strings.Cut(s, "-")
+	fields := strings.Split(s, ".")
+	if len(fields) == 0 || len(fields) > 3 {
+		return r, false
+	}
+	for i, f := range fields {
+		n, err := strconv.ParseUint(f, 10, 64)
+		if err != nil {
+			return r, false
+		}
+		r.parts[i] = n
+	}
+	return r, true
+}
+
+// atLeast reports whether r is the same release as min or a later one. A
+// prerelease comes before the release it leads up to.
+func (r release) atLeast(min release) bool {
+	for i := range r.parts {
+		if r.parts[i] != min.parts[i] {
+			return r.parts[i] > min.parts[i]
+		}
+	}
+	return r.prerelease == "" || min.prerelease != "" && r.prerelease >= min.prerelease
+}
+
+// nodeStatus is what one of our nodes runs and whether that will do for
+// the target app version.
+type nodeStatus struct {
+	Node             string `json:"node"`
+	Moniker          string `json:"moniker,omitempty"`
+	Network          string `json:"network,omitempty"`
+	AppName          string `json:"app_name,omitempty"`
+	Version          string `json:"version,omitempty"`
+	GitCommit        string `json:"git_commit,omitempty"`
+	CosmosSDKVersion string `json:"cosmos_sdk_version,omitempty"`
+	TargetAppVersion uint64 `json:"target_app_version"`
+	// Required is the configured minimum release for the target app version;
+	// without one the node's major version has to be at least the app
+	// version, as each celestia-app major release adds one
+	Required string `json:"required,omitempty"`
+	Ready    bool   `json:"ready"`
+	Reason   string `json:"reason,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// checkRelease decides whether a node running version can run appVersion.
+func checkRelease(version string, appVersion uint64, minReleases map[uint64]string) (bool, string, string) {
+	running, ok := parseRelease(version)
+	if !ok {
+		return false, "", fmt.Sprintf("cannot parse node version %q", version)
+	}
+	if required, ok := minReleases[appVersion]; ok {
+		min, _ := parseRelease(required)
+		if !running.atLeast(min) {
+			return false, required, fmt.Sprintf("runs %s, app version %d needs %s or later", version, appVersion, required)
+		}
+		return true, required, ""
+	}
+	if running.parts[0] < appVersion {
+		return false, "", fmt.Sprintf("runs %s, which only supports app versions up to %d", version, running.parts[0])
+	}
+	return true, "", ""
+}
+
+// nodeChecker compares the binaries our own nodes run with the app version
+// the chain is upgrading to.
+type nodeChecker struct {
+	nodes       []*endpoint
+	minReleases map[uint64]string
+	// alertBlocks is how close to the upgrade height a node that isn't
+	// ready becomes critical
+	alertBlocks int64
+
+	mu       sync.Mutex
+	statuses []nodeStatus
+	// level is 1 once a node was reported not ready for version, 2 once it
+	// was reported critical
+	level        map[string]int
+	levelVersion uint64
+}
+
+// nodeCheck is set when -own-nodes is given, nil otherwise.
+var nodeCheck *nodeChecker
+
+func newNodeChecker(nodes []*endpoint, minReleases map[uint64]string, alertBlocks int64) *nodeChecker {
+	return &nodeChecker{nodes: nodes, minReleases: minReleases, alertBlocks: alertBlocks, level: map[string]int{}}
+}
+
+// targetAppVersion is the scheduled upgrade's app version, or otherwise
+// the version being signalled for.
+func targetAppVersion(data UpgradeData) uint64 {
+	if data.UpgradeData.Upgrade.UpgradeHeight > 0 {
+		return uint64(data.UpgradeData.Upgrade.AppVersion)
+	}
+	return data.TallyData.Version
+}
+
+// check queries one node and evaluates it against appVersion.
+func (c *nodeChecker) check(e *endpoint, appVersion uint64) nodeStatus {
+	s := nodeStatus{Node: e.address.String(), TargetAppVersion: appVersion}
+	b, err := e.client()
+	if err != nil {
+		s.Error = err.Error()
+		s.Reason = "unreachable"
+		return s
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	info, err := b.NodeInfo(ctx)
+	if err != nil {
+		s.Error = err.Error()
+		s.Reason = "unreachable"
+		return s
+	}
+	s.Moniker = info.GetDefaultNodeInfo().GetMoniker()
+	s.Network = info.GetDefaultNodeInfo().GetNetwork()
+	s.AppName = info.GetApplicationVersion().GetAppName()
+	s.Version = info.GetApplicationVersion().GetVersion()
+	s.GitCommit = info.GetApplicationVersion().GetGitCommit()
+	s.CosmosSDKVersion = info.GetApplicationVersion().GetCosmosSdkVersion()
+	s.Ready, s.Required, s.Reason = checkRelease(s.Version, appVersion, c.minReleases)
+	return s
+}
+
+// update checks every node against a poll result, and alerts for nodes
+// that aren't ready once an upgrade is scheduled, again as critical within
+// alertBlocks of the upgrade height. Nodes are queried in parallel.
+func (c *nodeChecker) update(data UpgradeData) {
+	appVersion := targetAppVersion(data)
+	upgrade := data.UpgradeData.Upgrade
+	level := 0
+	if upgrade.UpgradeHeight > 0 {
+		level = 1
+		if upgrade.UpgradeHeight-data.Height <= c.alertBlocks {
+			level = 2
+		}
+	}
+
+	c.mu.Lock()
+	if c.levelVersion != appVersion {
+		c.level = map[string]int{}
+		c.levelVersion = appVersion
+	}
+	c.mu.Unlock()
+
+	statuses := make([]nodeStatus, len(c.nodes))
+	var wg sync.WaitGroup
+	for i, e := range c.nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses[i] = c.check(e, appVersion)
+		}()
+	}
+	wg.Wait()
+
+	// The new info series are set before the old ones are dropped, so a
+	// scrape in between never sees a node without one
+	c.mu.Lock()
+	previous := c.statuses
+	c.mu.Unlock()
+	current := map[[3]string]bool{}
+	for _, s := range statuses {
+		if s.Version != "" {
+			nodeInfo.WithLabelValues(s.Node, s.Version, s.GitCommit).Set(1)
+			current[[3]string{s.Node, s.Version, s.GitCommit}] = true
+		}
+	}
+	for _, p := range previous {
+		if p.Version != "" && !current[[3]string{p.Node, p.Version, p.GitCommit}] {
+			nodeInfo.DeleteLabelValues(p.Node, p.Version, p.GitCommit)
+		}
+	}
+
+	for _, s := range statuses {
+		if s.Ready {
+			nodeReady.WithLabelValues(s.Node).Set(1)
+		} else {
+			nodeReady.WithLabelValues(s.Node).Set(0)
+		}
+
+		nodeLevel := 0
+		if !s.Ready {
+			nodeLevel = level
+		}
+		c.mu.Lock()
+		previous := c.level[s.Node]
+		c.level[s.Node] = nodeLevel
+		c.mu.Unlock()
+		if nodeLevel > previous {
+			c.alert(data, s, nodeLevel)
+		}
+	}
+
+	c.mu.Lock()
+	c.statuses = statuses
+	c.mu.Unlock()
+}
+
+func (c *nodeChecker) alert(data UpgradeData, s nodeStatus, level int) {
+	severity := severityWarning
+	if level == 2 {
+		severity = severityCritical
+	}
+	reason := s.Reason
+	if s.Error != "" {
+		reason = s.Error
+	}
+	upgrade := data.UpgradeData.Upgrade
+	emitEvent(Event{
+		Kind:     "node_not_ready",
+		Severity: severity,
+		Message:  fmt.Sprintf("node %s is not ready for the upgrade to app version %d at height %d (%d blocks left): %s", s.Node, upgrade.AppVersion, upgrade.UpgradeHeight, upgrade.UpgradeHeight-data.Height, reason),
+		Height:   data.Height,
+		Fields: map[string]string{
+			"node":           s.Node,
+			"version":        s.Version,
+			"required":       s.Required,
+			"app_version":    strconv.Itoa(upgrade.AppVersion),
+			"upgrade_height": strconv.FormatInt(upgrade.UpgradeHeight, 10),
+		},
+	})
+}
+
+// snapshot returns the latest node statuses.
+func (c *nodeChecker) snapshot() []nodeStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]nodeStatus{}, c.statuses...)
+}
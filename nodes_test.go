@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestParseMinReleases(t *testing.T) {
+	releases, err := parseMinReleases("2=v2.0.0, 3=v3.1.0-rc2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(releases) != 2 || releases[2] != "v2.0.0" || releases[3] != "v3.1.0-rc2" {
+		t.Errorf("got %v", releases)
+	}
+	for _, s := range []string{"v2.0.0", "two=v2.0.0", "2=latest", "2=v1.2.3.4"} {
+		if _, err := parseMinReleases(s); err == nil {
+			t.Errorf("%q: want an error", s)
+		}
+	}
+}
+
+func TestCheckRelease(t *testing.T) {
+	minReleases := map[uint64]string{2: "v2.0.1", 3: "v3.0.0-rc2"}
+	tests := []struct {
+		version      string
+		appVersion   uint64
+		wantReady    bool
+		wantRequired string
+	}{
+		{"v2.0.1", 2, true, "v2.0.1"},
+		{"v2.1.0", 2, true, "v2.0.1"},
+		{"v2.0.0", 2, false, "v2.0.1"},
+		// A prerelease comes before the release, but after earlier
+		// prereleases
+		{"v2.0.1-rc1", 2, false, "v2.0.1"},
+		{"v3.0.0-rc3", 3, true, "v3.0.0-rc2"},
+		{"v3.0.0-rc1", 3, false, "v3.0.0-rc2"},
+		// Without a configured release the major version has to be at least
+		// the app version
+		{"v4.0.0", 4, true, ""},
+		{"v3.9.9", 4, false, ""},
+		{"main", 2, false, ""},
+	}
+	for _, tt := range tests {
+		ready, required, reason := checkRelease(tt.version, tt.appVersion, minReleases)
+		if ready != tt.wantReady || required != tt.wantRequired || ready != (reason == "") {
+			t.Errorf("%s for app version %d: got %v, %q, %q", tt.version, tt.appVersion, ready, required, reason)
+		}
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	signaltypes "celestia-upgrade-monitor/celestia/signal/v1"
+
+	authztypes "cosmossdk.io/api/cosmos/authz/v1beta1"
+	cmtservice "cosmossdk.io/api/cosmos/base/tendermint/v1beta1"
+	stakingtypes "cosmossdk.io/api/cosmos/staking/v1beta1"
+	txtypes "cosmossdk.io/api/cosmos/tx/v1beta1"
+	cmtversion "cosmossdk.io/api/tendermint/version"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Methods recorded for block lookups. Blocks are stored as SDK headers.
+const (
+	methodGetLatestBlock   = "/cosmos.base.tendermint.v1beta1.Service/GetLatestBlock"
+	methodGetBlockByHeight = "/cosmos.base.tendermint.v1beta1.Service/GetBlockByHeight"
+)
+
+// recordedCall is one upstream request and its response, as stored in a
+// recording.
+type recordedCall struct {
+	Time       time.Time `json:"time"`
+	Endpoint   string    `json:"endpoint"`
+	Method     string    `json:"method"`
+	Height     int64     `json:"height,omitempty"`
+	Request    []byte    `json:"request,omitempty"`
+	Response   []byte    `json:"response,omitempty"`
+	Served     int64     `json:"served_height,omitempty"`
+	Code       string    `json:"code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMS float64   `json:"duration_ms"`
+}
+
+// callRecorder appends every upstream call to a JSON lines file.
+type callRecorder struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// recorder is set when -record is given, nil otherwise.
+var recorder *callRecorder
+
+func openRecorder(path string) (*callRecorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording: %w", err)
+	}
+	return &callRecorder{file: f}, nil
+}
+
+func (r *callRecorder) write(call recordedCall) error {
+	line, err := json.Marshal(call)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, err = r.file.Write(append(line, '\n'))
+	return err
+}
+
+func (r *callRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+// wrap returns b with every call recorded.
+func (r *callRecorder) wrap(b backend, address endpointAddress) backend {
+	return &recordingBackend{backend: b, rec: r, endpoint: address.String()}
+}
+
+// recordingBackend records the calls made through the backend it wraps.
+type recordingBackend struct {
+	backend
+	rec      *callRecorder
+	endpoint string
+}
+
+// record stores a call. Recording failures are logged rather than failing
+// the query.
+func (b *recordingBackend) record(start time.Time, method string, height int64, req, resp proto.Message, served int64, err error) {
+	call := recordedCall{
+		Time:       start,
+		Endpoint:   b.endpoint,
+		Method:     method,
+		Height:     height,
+		Served:     served,
+		DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	call.Request, _ = proto.Marshal(req)
+	if err != nil {
+		call.Code = status.Code(err).String()
+		call.Error = err.Error()
+	} else {
+		call.Response, _ = proto.Marshal(resp)
+	}
+	if err := b.rec.write(call); err != nil {
+		log.Printf("Failed to write recording: %v", err)
+	}
+}
+
+func (b *recordingBackend) Block(ctx context.Context, height int64) (blockInfo, error) {
+	start := time.Now()
+	info, err := b.backend.Block(ctx, height)
+	method, req := blockRequest(height)
+	b.record(start, method, height, req, blockToHeader(info), 0, err)
+	return info, err
+}
+
+func (b *recordingBackend) GetUpgrade(ctx context.Context, height int64) (*signaltypes.QueryGetUpgradeResponse, int64, error) {
+	start := time.Now()
+	resp, served, err := b.backend.GetUpgrade(ctx, height)
+	b.record(start, signaltypes.Query_GetUpgrade_FullMethodName, height, &signaltypes.QueryGetUpgradeRequest{}, resp, served, err)
+	return resp, served, err
+}
+
+func (b *recordingBackend) VersionTally(ctx context.Context, version uint64, height int64) (*signaltypes.QueryVersionTallyResponse, int64, error) {
+	start := time.Now()
+	resp, served, err := b.backend.VersionTally(ctx, version, height)
+	b.record(start, signaltypes.Query_VersionTally_FullMethodName, height, &signaltypes.QueryVersionTallyRequest{Version: version}, resp, served, err)
+	return resp, served, err
+}
+
+func (b *recordingBackend) Validator(ctx context.Context, valoper string) (*stakingtypes.Validator, error) {
+	start := time.Now()
+	resp, err := b.backend.Validator(ctx, valoper)
+	b.record(start, stakingtypes.Query_Validator_FullMethodName, 0, &stakingtypes.QueryValidatorRequest{ValidatorAddr: valoper}, resp, 0, err)
+	return resp, err
+}
+
+func (b *recordingBackend) Grants(ctx context.Context, granter, grantee, msgTypeURL string) ([]*authztypes.Grant, error) {
+	start := time.Now()
+	grants, err := b.backend.Grants(ctx, granter, grantee, msgTypeURL)
+	req := &authztypes.QueryGrantsRequest{Granter: granter, Grantee: grantee, MsgTypeUrl: msgTypeURL}
+	b.record(start, authztypes.Query_Grants_FullMethodName, 0, req, &authztypes.QueryGrantsResponse{Grants: grants}, 0, err)
+	return grants, err
+}
+
+func (b *recordingBackend) TxsEvent(ctx context.Context, query string, limit uint64) (*txtypes.GetTxsEventResponse, error) {
+	start := time.Now()
+	resp, err := b.backend.TxsEvent(ctx, query, limit)
+	b.record(start, txtypes.Service_GetTxsEvent_FullMethodName, 0, txsEventRequest(query, limit), resp, 0, err)
+	return resp, err
+}
+
+func (b *recordingBackend) NodeInfo(ctx context.Context) (*cmtservice.GetNodeInfoResponse, error) {
+	start := time.Now()
+	resp, err := b.backend.NodeInfo(ctx)
+	b.record(start, cmtservice.Service_GetNodeInfo_FullMethodName, 0, &cmtservice.GetNodeInfoRequest{}, resp, 0, err)
+	return resp, err
+}
+
+func (b *recordingBackend) Syncing(ctx context.Context) (bool, error) {
+	start := time.Now()
+	syncing, err := b.backend.Syncing(ctx)
+	b.record(start, cmtservice.Service_GetSyncing_FullMethodName, 0, &cmtservice.GetSyncingRequest{}, &cmtservice.GetSyncingResponse{Syncing: syncing}, 0, err)
+	return syncing, err
+}
+
+func blockRequest(height int64) (string, proto.Message) {
+	if height > 0 {
+		return methodGetBlockByHeight, &cmtservice.GetBlockByHeightRequest{Height: height}
+	}
+	return methodGetLatestBlock, &cmtservice.GetLatestBlockRequest{}
+}
+
+func blockToHeader(info blockInfo) *cmtservice.Header {
+	return &cmtservice.Header{
+		Version: &cmtversion.Consensus{App: info.AppVersion},
+		ChainId: info.ChainID,
+		Height:  info.Height,
+		Time:    timestamppb.New(info.Time),
+	}
+}
+
+// unrecordedFlags are the flags whose upstream calls don't go through a
+// backend and so are neither recorded nor replayed: the light client's
+// headers and proofs, the websocket and transaction broadcasts.
+var unrecordedFlags = []string{"verify", "rpc-addr", "try-upgrade-from"}
+
+// checkReplayFlags fails when replay endpoints are used with any of the
+// unrecorded flags set.
+func checkReplayFlags(fs *flag.FlagSet, endpoints []*endpoint) error {
+	replaying := false
+	for _, e := range endpoints {
+		replaying = replaying || e.address.transport == transportReplay
+	}
+	if !replaying {
+		return nil
+	}
+	var set []string
+	fs.Visit(func(f *flag.Flag) {
+		if value := f.Value.String(); slices.Contains(unrecordedFlags, f.Name) && value != "" && value != "false" {
+			set = append(set, "-"+f.Name)
+		}
+	})
+	if len(set) > 0 {
+		return fmt.Errorf("%s can't be used with replay:// endpoints, recordings only hold node queries", strings.Join(set, ", "))
+	}
+	return nil
+}
+
+// replayBackend serves calls from a recording. Calls are matched on method,
+// request and height; repeated calls get the recorded responses in order,
+// and the last one once they run out.
+type replayBackend struct {
+	path string
+
+	mu    sync.Mutex
+	calls map[string][]recordedCall
+}
+
+func newReplayBackend(path string) (*replayBackend, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording: %w", err)
+	}
+	defer f.Close()
+
+	b := &replayBackend{path: path, calls: map[string][]recordedCall{}}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		var call recordedCall
+		if err := json.Unmarshal(scanner.Bytes(), &call); err != nil {
+			return nil, fmt.Errorf("failed to parse recording %s: %w", path, err)
+		}
+		key := replayKey(call.Method, call.Height, call.Request)
+		b.calls[key] = append(b.calls[key], call)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read recording: %w", err)
+	}
+	return b, nil
+}
+
+func replayKey(method string, height int64, request []byte) string {
+	return fmt.Sprintf("%s@%d:%x", method, height, request)
+}
+
+// replay finds the next recorded response for a call and decodes it into
+// resp.
+func (b *replayBackend) replay(method string, height int64, req, resp proto.Message) (int64, error) {
+	reqBytes, err := proto.Marshal(req)
+	if err != nil {
+		return 0, err
+	}
+	key := replayKey(method, height, reqBytes)
+
+	b.mu.Lock()
+	calls := b.calls[key]
+	if len(calls) == 0 {
+		b.mu.Unlock()
+		return 0, status.Errorf(codes.NotFound, "no recorded %s call at height %d in %s", method, height, b.path)
+	}
+	call := calls[0]
+	if len(calls) > 1 {
+		b.calls[key] = calls[1:]
+	}
+	b.mu.Unlock()
+
+	if call.Code != "" {
+		return 0, status.Error(parseCode(call.Code), call.Error)
+	}
+	if err := proto.Unmarshal(call.Response, resp); err != nil {
+		return 0, fmt.Errorf("failed to decode recorded %s response: %w", method, err)
+	}
+	return call.Served, nil
+}
+
+// parseCode turns a recorded code name back into a gRPC code.
+func parseCode(name string) codes.Code {
+	for code := codes.OK; code <= codes.Unauthenticated; code++ {
+		if code.String() == name {
+			return code
+		}
+	}
+	return codes.Unknown
+}
+
+func (b *replayBackend) Block(_ context.Context, height int64) (blockInfo, error) {
+	method, req := blockRequest(height)
+	var header cmtservice.Header
+	if _, err := b.replay(method, height, req, &header); err != nil {
+		return blockInfo{}, err
+	}
+	return headerToBlockInfo(&header), nil
+}
+
+func (b *replayBackend) GetUpgrade(_ context.Context, height int64) (*signaltypes.QueryGetUpgradeResponse, int64, error) {
+	var resp signaltypes.QueryGetUpgradeResponse
+	served, err := b.replay(signaltypes.Query_GetUpgrade_FullMethodName, height, &signaltypes.QueryGetUpgradeRequest{}, &resp)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &resp, served, nil
+}
+
+func (b *replayBackend) VersionTally(_ context.Context, version uint64, height int64) (*signaltypes.QueryVersionTallyResponse, int64, error) {
+	var resp signaltypes.QueryVersionTallyResponse
+	served, err := b.replay(signaltypes.Query_VersionTally_FullMethodName, height, &signaltypes.QueryVersionTallyRequest{Version: version}, &resp)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &resp, served, nil
+}
+
+func (b *replayBackend) Validator(_ context.Context, valoper string) (*stakingtypes.Validator, error) {
+	var validator stakingtypes.Validator
+	if _, err := b.replay(stakingtypes.Query_Validator_FullMethodName, 0, &stakingtypes.QueryValidatorRequest{ValidatorAddr: valoper}, &validator); err != nil {
+		return nil, err
+	}
+	return &validator, nil
+}
+
+func (b *replayBackend) Grants(_ context.Context, granter, grantee, msgTypeURL string) ([]*authztypes.Grant, error) {
+	var resp authztypes.QueryGrantsResponse
+	req := &authztypes.QueryGrantsRequest{Granter: granter, Grantee: grantee, MsgTypeUrl: msgTypeURL}
+	if _, err := b.replay(authztypes.Query_Grants_FullMethodName, 0, req, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Grants, nil
+}
+
+func (b *replayBackend) TxsEvent(_ context.Context, query string, limit uint64) (*txtypes.GetTxsEventResponse, error) {
+	var resp txtypes.GetTxsEventResponse
+	if _, err := b.replay(txtypes.Service_GetTxsEvent_FullMethodName, 0, txsEventRequest(query, limit), &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (b *replayBackend) NodeInfo(context.Context) (*cmtservice.GetNodeInfoResponse, error) {
+	var resp cmtservice.GetNodeInfoResponse
+	if _, err := b.replay(cmtservice.Service_GetNodeInfo_FullMethodName, 0, &cmtservice.GetNodeInfoRequest{}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (b *replayBackend) Syncing(context.Context) (bool, error) {
+	var resp cmtservice.GetSyncingResponse
+	if _, err := b.replay(cmtservice.Service_GetSyncing_FullMethodName, 0, &cmtservice.GetSyncingRequest{}, &resp); err != nil {
+		return false, err
+	}
+	return resp.Syncing, nil
+}
+
+func (b *replayBackend) Close() error {
+	return nil
+}
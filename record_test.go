@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+// Flags whose calls aren't recorded are refused with replay endpoints
+func TestCheckReplayFlags(t *testing.T) {
+	replaying := []*endpoint{
+		{address: endpointAddress{addr: "localhost:9090"}},
+		{address: endpointAddress{transport: transportReplay, addr: "calls.jsonl"}},
+	}
+	for _, args := range [][]string{{"-verify"}, {"-rpc-addr", "localhost:26657"}, {"-try-upgrade-from", "operator"}} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.Bool("verify", false, "")
+		fs.String("rpc-addr", "", "")
+		fs.String("try-upgrade-from", "", "")
+		fs.String("own-validators", "", "")
+		if err := fs.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		if err := checkReplayFlags(fs, replaying); err == nil || !strings.Contains(err.Error(), args[0]) {
+			t.Errorf("%s with a replay endpoint: got %v", args[0], err)
+		}
+		if err := checkReplayFlags(fs, replaying[:1]); err != nil {
+			t.Errorf("%s without a replay endpoint: %v", args[0], err)
+		}
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Bool("verify", false, "")
+	fs.String("own-validators", "", "")
+	if err := fs.Parse([]string{"-verify=false", "-own-validators", "celestiavaloper1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkReplayFlags(fs, replaying); err != nil {
+		t.Errorf("replayable flags refused: %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// lifecycleState describes where the network is in the upgrade process.
+type lifecycleState string
+
+const (
+	// No validator has signalled for the target version
+	stateIdle lifecycleState = "idle"
+	// Validators are signalling but no upgrade is scheduled yet
+	stateSignalling lifecycleState = "signalling"
+	// An upgrade height is scheduled and is still far away
+	stateScheduled lifecycleState = "scheduled"
+	// The upgrade height is within the final window
+	stateFinal lifecycleState = "final"
+	// The upgrade height has been reached but the upgrade is still reported,
+	// e.g. because the chain halted there
+	statePast lifecycleState = "past"
+)
+
+// lifecycle derives the lifecycle state from a poll result.
+func lifecycle(data UpgradeData, finalWindow int64) lifecycleState {
+	upgradeHeight := data.UpgradeData.Upgrade.UpgradeHeight
+	switch {
+	case upgradeHeight > 0 && data.Height >= upgradeHeight:
+		return statePast
+	case upgradeHeight > 0 && upgradeHeight-data.Height <= finalWindow:
+		return stateFinal
+	case upgradeHeight > 0:
+		return stateScheduled
+	case data.TallyData.VotingPower > 0:
+		return stateSignalling
+	default:
+		return stateIdle
+	}
+}
+
+// pollSchedule decides how long to wait between polls based on the
+// lifecycle state and the number of blocks left until the upgrade.
+type pollSchedule struct {
+	IdleInterval       time.Duration
+	SignallingInterval time.Duration
+	ScheduledInterval  time.Duration
+	FinalWindow        int64
+	BlockTime          time.Duration
+	MinInterval        time.Duration
+	MaxInterval        time.Duration
+	Jitter             float64
+}
+
+// next returns the delay before the next poll.
+func (s pollSchedule) next(state lifecycleState, blocksRemaining int64) time.Duration {
+	var interval time.Duration
+	switch state {
+	case stateFinal:
+		// Poll every block until the upgrade height is reached
+		interval = s.BlockTime
+	case stateScheduled:
+		// Don't sleep past the start of the final window
+		interval = s.ScheduledInterval
+		if untilFinal := time.Duration(blocksRemaining-s.FinalWindow) * s.BlockTime; untilFinal < interval {
+			interval = untilFinal
+		}
+	case statePast:
+		// Block watching catches the switch; polling every block would
+		// only hammer a halted chain
+		interval = s.ScheduledInterval
+	case stateSignalling:
+		interval = s.SignallingInterval
+	default:
+		interval = s.IdleInterval
+	}
+
+	if s.Jitter > 0 {
+		interval += time.Duration((rand.Float64()*2 - 1) * s.Jitter * float64(interval))
+	}
+	if interval < s.MinInterval {
+		interval = s.MinInterval
+	}
+	if s.MaxInterval > 0 && interval > s.MaxInterval {
+		interval = s.MaxInterval
+	}
+	return interval
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLifecycleSchedule(t *testing.T) {
+	schedule := pollSchedule{
+		IdleInterval:       30 * time.Minute,
+		SignallingInterval: 5 * time.Minute,
+		ScheduledInterval:  time.Minute,
+		FinalWindow:        300,
+		BlockTime:          6 * time.Second,
+		MinInterval:        time.Second,
+		MaxInterval:        time.Hour,
+	}
+	data := func(height, upgradeHeight int64, power int64) UpgradeData {
+		return UpgradeData{
+			Height:      height,
+			UpgradeData: UpgradeResponse{Upgrade: Upgrade{AppVersion: 2, UpgradeHeight: upgradeHeight}},
+			TallyData:   TallyResponse{Version: 2, VotingPower: power},
+		}
+	}
+	tests := []struct {
+		name     string
+		data     UpgradeData
+		state    lifecycleState
+		interval time.Duration
+	}{
+		{"idle", data(100, 0, 0), stateIdle, 30 * time.Minute},
+		{"signalling", data(100, 0, 50), stateSignalling, 5 * time.Minute},
+		{"scheduled", data(100, 10000, 90), stateScheduled, time.Minute},
+		// 5 blocks before the final window starts
+		{"scheduled near the final window", data(9695, 10000, 90), stateScheduled, 30 * time.Second},
+		{"final window", data(9700, 10000, 90), stateFinal, 6 * time.Second},
+		{"one block left", data(9999, 10000, 90), stateFinal, 6 * time.Second},
+		{"upgrade height reached", data(10000, 10000, 90), statePast, time.Minute},
+		{"halted past the upgrade", data(10500, 10000, 90), statePast, time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := lifecycle(tt.data, schedule.FinalWindow)
+			if state != tt.state {
+				t.Fatalf("got state %s, want %s", state, tt.state)
+			}
+			remaining := tt.data.UpgradeData.Upgrade.UpgradeHeight - tt.data.Height
+			if got := schedule.next(state, remaining); got != tt.interval {
+				t.Errorf("got interval %s, want %s", got, tt.interval)
+			}
+		})
+	}
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	signaltypes "celestia-upgrade-monitor/celestia/signal/v1"
+)
+
+// selfMilestone is a point in the signalling at which not having signalled
+// ourselves gets more urgent.
+type selfMilestone struct {
+	Name string
+	// Percent of the total power that has signalled, or for a quorum
+	// milestone, how many percentage points below the threshold the tally
+	// may still be
+	Percent float64
+	Quorum  bool
+}
+
+// reached reports whether the tally has reached the milestone.
+func (m selfMilestone) reached(tally TallyResponse) bool {
+	if tally.TotalVotingPower == 0 {
+		return false
+	}
+	signalled := float64(tally.VotingPower) / float64(tally.TotalVotingPower) * 100
+	if m.Quorum {
+		threshold := float64(tally.ThresholdPower) / float64(tally.TotalVotingPower) * 100
+		return signalled >= threshold-m.Percent
+	}
+	return signalled >= m.Percent
+}
+
+// parseSelfMilestones parses a comma-separated list of milestones in
+// escalating order. Each is a percentage of the total power, "quorum" for
+// the threshold itself, or "quorum-N" for N percentage points below it.
+func parseSelfMilestones(s string) ([]selfMilestone, error) {
+	var milestones []selfMilestone
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		m := selfMilestone{Name: name}
+		var value string
+		if rest, ok := strings.CutPrefix(name, "quorum"); ok {
+			m.Quorum = true
+			value = strings.TrimPrefix(rest, "-")
+			if value == "" {
+				value = "0"
+			}
+		} else {
+			value = strings.TrimSuffix(name, "%")
+		}
+		percent, err := strconv.ParseFloat(value, 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return nil, fmt.Errorf("invalid milestone %q", name)
+		}
+		m.Percent = percent
+		milestones = append(milestones, m)
+	}
+	return milestones, nil
+}
+
+// ownValidator is the self-check result for one of our validators.
+type ownValidator struct {
+	Validator string `json:"validator"`
+	Moniker   string `json:"moniker,omitempty"`
+	Power     int64  `json:"power"`
+	// Signalled is true once the validator signalled the target version
+	Signalled     bool   `json:"signalled"`
+	SignalVersion uint64 `json:"signal_version,omitempty"`
+	SignalHeight  int64  `json:"signal_height,omitempty"`
+	SignalTxHash  string `json:"signal_tx_hash,omitempty"`
+	// MissingSeconds is how long the network has been signalling for the
+	// target version without this validator
+	MissingSeconds float64 `json:"missing_seconds,omitempty"`
+	Milestone      string  `json:"milestone,omitempty"`
+	Severity       string  `json:"severity,omitempty"`
+}
+
+// selfReport is the /self response.
+type selfReport struct {
+	Height          int64          `json:"height"`
+	TargetVersion   uint64         `json:"target_version"`
+	VotingPercent   float64        `json:"voting_percent"`
+	SignallingSince *time.Time     `json:"network_signalling_since,omitempty"`
+	Validators      []ownValidator `json:"validators"`
+}
+
+// selfChecker tracks whether our own validators have signalled the target
+// version and escalates as the rest of the network moves on without them.
+type selfChecker struct {
+	validators []string
+	milestones []selfMilestone
+	// grantees are the accounts allowed to signal for each validator
+	// through authz, from -authz-grants
+	grantees map[string][]string
+
+	mu     sync.Mutex
+	report selfReport
+	// level is the number of milestones each validator has been alerted
+	// for, and levelVersion the target version they apply to
+	level        map[string]int
+	levelVersion uint64
+	// firstSeen is when signalling for a version was first polled, for
+	// when history doesn't go back far enough
+	firstSeen map[uint64]time.Time
+	// signals holds the signal for the target version found by searching
+	// transactions, so each validator's is only searched for until found
+	signals map[string]signalRecord
+	// misses holds the last search that didn't find a validator's signal
+	// for the target version, which isn't repeated until the chain has
+	// moved on and searchInterval has passed
+	misses         map[string]signalMiss
+	searchInterval time.Duration
+}
+
+// signalMiss is a transaction search that didn't find the signal for
+// version, with the latest signal for another version it found, if any.
+type signalMiss struct {
+	version uint64
+	height  int64
+	at      time.Time
+	record  signalRecord
+	found   bool
+}
+
+// selfCheck is set when -own-validators is given, nil otherwise.
+var selfCheck *selfChecker
+
+func newSelfChecker(validators []string, milestones []selfMilestone, grants []grantWatch, searchInterval time.Duration) *selfChecker {
+	c := &selfChecker{
+		validators:     validators,
+		milestones:     milestones,
+		grantees:       map[string][]string{},
+		level:          map[string]int{},
+		firstSeen:      map[uint64]time.Time{},
+		signals:        map[string]signalRecord{},
+		misses:         map[string]signalMiss{},
+		searchInterval: searchInterval,
+	}
+	for _, g := range grants {
+		c.grantees[g.Validator] = append(c.grantees[g.Validator], g.Grantee)
+	}
+	return c
+}
+
+// signallingSince returns when the network started signalling for the
+// target version of data: the start of the latest run of history samples
+// with power behind it, or when it was first polled.
+func (c *selfChecker) signallingSince(data UpgradeData, now time.Time) (time.Time, bool) {
+	version := data.TallyData.Version
+	if data.TallyData.VotingPower == 0 {
+		return time.Time{}, false
+	}
+	c.mu.Lock()
+	since, ok := c.firstSeen[version]
+	if !ok {
+		since = now
+		c.firstSeen[version] = now
+	}
+	c.mu.Unlock()
+
+	samples := history.between(0, 0)
+	for i := len(samples) - 1; i >= 0; i-- {
+		s := samples[i]
+		if s.Version != version || s.VotingPower == 0 {
+			break
+		}
+		if !s.Time.IsZero() && s.Time.Before(since) {
+			since = s.Time
+		}
+	}
+	return since, true
+}
+
+// lastSignal returns the latest MsgSignalVersion seen from valoper as of
+// height. One seen on the websocket or found earlier for version is
+// enough; otherwise transactions from the validator and its authz grantees
+// are searched, which also covers signals sent before the monitor started.
+func (c *selfChecker) lastSignal(valoper string, version uint64, height int64, now time.Time) (signalRecord, bool) {
+	signalRecords.Lock()
+	record, found := signalRecords.byValidator[valoper]
+	signalRecords.Unlock()
+	c.mu.Lock()
+	if cached, ok := c.signals[valoper]; ok && (!found || cached.Height > record.Height) {
+		record, found = cached, true
+	}
+	miss, missed := c.misses[valoper]
+	c.mu.Unlock()
+	if found && record.Version == version {
+		return record, true
+	}
+	if missed && miss.version == version && (height <= miss.height || now.Sub(miss.at) < c.searchInterval) {
+		if miss.found && (!found || miss.record.Height > record.Height) {
+			record, found = miss.record, true
+		}
+		return record, found
+	}
+
+	account, err := validatorAccount(valoper)
+	if err != nil {
+		return record, found
+	}
+	queries := []string{fmt.Sprintf("message.action='%s' AND message.sender='%s'", msgSignalVersionURL, account)}
+	for _, grantee := range c.grantees[valoper] {
+		queries = append(queries, fmt.Sprintf("message.action='%s' AND message.sender='%s'", msgExecURL, grantee))
+	}
+	failed := false
+	for i, query := range queries {
+		// A grantee may execute other messages, so look further back
+		limit := uint64(1)
+		if i > 0 {
+			limit = 20
+		}
+		err = queryEndpoints(func(b backend) (string, error) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			resp, err := b.TxsEvent(ctx, query, limit)
+			if err != nil {
+				return "", err
+			}
+			for i, tx := range resp.GetTxs() {
+				if i >= len(resp.GetTxResponses()) {
+					break
+				}
+				msgs, err := decodeSignalMessages(tx.GetBody().GetMessages())
+				if err != nil {
+					continue
+				}
+				result := resp.GetTxResponses()[i]
+				for _, msg := range msgs {
+					m, ok := msg.(*signaltypes.MsgSignalVersion)
+					if ok && m.ValidatorAddress == valoper && result.GetHeight() > record.Height {
+						record = signalRecord{Validator: valoper, Version: m.Version, Height: result.GetHeight(), TxHash: result.GetTxhash()}
+						found = true
+					}
+				}
+			}
+			return "", nil
+		})
+		if err != nil {
+			log.Printf("Failed to search for signals from %s: %v", valoper, err)
+			failed = true
+		}
+	}
+	c.mu.Lock()
+	if found && record.Version == version {
+		c.signals[valoper] = record
+		delete(c.misses, valoper)
+	} else if !failed {
+		// A failed search is retried on the next check
+		c.misses[valoper] = signalMiss{version: version, height: height, at: now, record: record, found: found}
+	}
+	c.mu.Unlock()
+	return record, found
+}
+
+// update checks our validators against a poll result.
+func (c *selfChecker) update(data UpgradeData) {
+	now := time.Now()
+	tally := data.TallyData
+	since, signalling := c.signallingSince(data, now)
+
+	report := selfReport{
+		Height:        data.Height,
+		TargetVersion: tally.Version,
+		VotingPercent: tally.VotingPercent,
+		Validators:    []ownValidator{},
+	}
+	if signalling {
+		report.SignallingSince = &since
+	}
+
+	// The highest milestone reached applies to every validator that hasn't
+	// signalled yet
+	reached := 0
+	for i, m := range c.milestones {
+		if m.reached(tally) {
+			reached = i + 1
+		}
+	}
+
+	c.mu.Lock()
+	if c.levelVersion != tally.Version {
+		c.level = map[string]int{}
+		c.levelVersion = tally.Version
+	}
+	c.mu.Unlock()
+
+	for _, valoper := range c.validators {
+		v := ownValidator{Validator: valoper}
+		err := queryEndpoints(func(b backend) (string, error) {
+			var err error
+			v.Moniker, v.Power, err = getValidatorPower(b, valoper)
+			return "", err
+		})
+		if err != nil {
+			log.Printf("Failed to look up own validator %s: %v", valoper, err)
+		}
+		if record, ok := c.lastSignal(valoper, tally.Version, data.Height, now); ok {
+			v.SignalVersion = record.Version
+			v.SignalHeight = record.Height
+			v.SignalTxHash = record.TxHash
+			v.Signalled = record.Version == tally.Version
+		}
+
+		level := 0
+		if !v.Signalled {
+			if signalling {
+				v.MissingSeconds = now.Sub(since).Seconds()
+			}
+			level = reached
+		}
+		if level > 0 {
+			v.Milestone = c.milestones[level-1].Name
+			v.Severity = c.severity(level)
+		}
+		if v.Signalled {
+			ownValidatorSignalled.WithLabelValues(valoper).Set(1)
+		} else {
+			ownValidatorSignalled.WithLabelValues(valoper).Set(0)
+		}
+		ownValidatorAlertLevel.WithLabelValues(valoper).Set(float64(level))
+
+		c.mu.Lock()
+		previous := c.level[valoper]
+		c.level[valoper] = level
+		c.mu.Unlock()
+		if level > previous {
+			c.alert(data, v, level, since)
+		}
+		report.Validators = append(report.Validators, v)
+	}
+
+	c.mu.Lock()
+	c.report = report
+	c.mu.Unlock()
+}
+
+// severity is warning for every milestone but the last, which is critical.
+func (c *selfChecker) severity(level int) string {
+	if level == len(c.milestones) {
+		return severityCritical
+	}
+	return severityWarning
+}
+
+func (c *selfChecker) alert(data UpgradeData, v ownValidator, level int, since time.Time) {
+	name := v.Moniker
+	if name == "" {
+		name = v.Validator
+	}
+	missing := time.Duration(v.MissingSeconds * float64(time.Second)).Round(time.Minute)
+	emitEvent(Event{
+		Kind:     "own_validator_not_signalled",
+		Severity: c.severity(level),
+		Message:  fmt.Sprintf("%s has not signalled version %d; the network passed milestone %s with %.2f%% signalled and has been signalling for %s", name, data.TallyData.Version, v.Milestone, data.TallyData.VotingPercent*100, missing),
+		Height:   data.Height,
+		Fields: map[string]string{
+			"validator":        v.Validator,
+			"moniker":          v.Moniker,
+			"version":          strconv.FormatUint(data.TallyData.Version, 10),
+			"signal_version":   strconv.FormatUint(v.SignalVersion, 10),
+			"milestone":        v.Milestone,
+			"missing_seconds":  strconv.FormatFloat(v.MissingSeconds, 'f', 0, 64),
+			"signalling_since": since.Format(time.RFC3339),
+		},
+	})
+}
+
+// snapshot returns the latest self-check report.
+func (c *selfChecker) snapshot() selfReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.report
+}
+
+// isOwnValidator reports whether valoper is one of ours.
+func (c *selfChecker) isOwnValidator(valoper string) bool {
+	for _, v := range c.validators {
+		if v == valoper {
+			return true
+		}
+	}
+	return false
+}
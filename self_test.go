@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestSelfMilestones(t *testing.T) {
+	milestones, err := parseSelfMilestones("40%, quorum-5,quorum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []selfMilestone{
+		{Name: "40%", Percent: 40},
+		{Name: "quorum-5", Percent: 5, Quorum: true},
+		{Name: "quorum", Quorum: true},
+	}
+	if len(milestones) != len(want) {
+		t.Fatalf("got %+v, want %+v", milestones, want)
+	}
+	for i := range want {
+		if milestones[i] != want[i] {
+			t.Errorf("milestone %d: got %+v, want %+v", i, milestones[i], want[i])
+		}
+	}
+	for _, s := range []string{"", "quorum-x", "120", "-1"} {
+		if _, err := parseSelfMilestones(s); err == nil {
+			t.Errorf("%q: want an error", s)
+		}
+	}
+
+	// The threshold is 92 of 110
+	tally := TallyResponse{VotingPower: 50, ThresholdPower: 92, TotalVotingPower: 110}
+	reached := func() []bool {
+		var got []bool
+		for _, m := range milestones {
+			got = append(got, m.reached(tally))
+		}
+		return got
+	}
+	if got := reached(); !got[0] || got[1] || got[2] {
+		t.Errorf("at 50 of 110 got %v, want only 40%% reached", got)
+	}
+	tally.VotingPower = 87
+	if got := reached(); !got[1] || got[2] {
+		t.Errorf("at 87 of 110 got %v, want quorum-5 reached", got)
+	}
+	if (selfMilestone{Percent: 1}).reached(TallyResponse{}) {
+		t.Error("milestone reached without any voting power")
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	signaltypes "celestia-upgrade-monitor/celestia/signal/v1"
+
+	abcitypes "cosmossdk.io/api/cosmos/base/abci/v1beta1"
+)
+
+// signalResult is the outcome of signalling for a version.
+type signalResult struct {
+	Version   uint64
+	Validator string
+	TxHash    string
+	Height    int64
+
+	// Tally for the version before the transaction and at its height
+	Before    uint64
+	After     uint64
+	Total     uint64
+	Threshold uint64
+}
+
+// signalVersion submits a MsgSignalVersion for valoper, or the validator
+// whose operator key s signs with if empty, and reads the tally back at
+// the height it was included. A version of 0 means the next app version.
+func signalVersion(ctx context.Context, s *txSigner, version uint64, valoper string) (signalResult, error) {
+	version, err := prepareSignal(ctx, s, version)
+	if err != nil {
+		return signalResult{}, err
+	}
+	if valoper == "" {
+		key, err := s.key()
+		if err != nil {
+			return signalResult{}, err
+		}
+		valoper = key.bech32(valoperPrefix)
+	}
+
+	return trackSignal(ctx, &grpcBackend{conn: s.conn}, version, valoper, func() (*abcitypes.TxResponse, error) {
+		log.Printf("Signalling for version %d as %s on %s", version, valoper, s.chainID)
+		return s.submit(ctx, &signaltypes.MsgSignalVersion{ValidatorAddress: valoper, Version: version})
+	})
+}
+
+// trackSignal reads the tally for version, sends a signal transaction and
+// reads the tally again at the height it was included.
+func trackSignal(ctx context.Context, b backend, version uint64, valoper string, send func() (*abcitypes.TxResponse, error)) (signalResult, error) {
+	before, _, err := b.VersionTally(ctx, version, 0)
+	if err != nil {
+		return signalResult{}, fmt.Errorf("failed to query tally: %w", err)
+	}
+	log.Printf("Tally for version %d is %d of %d", version, before.GetVotingPower(), before.GetTotalVotingPower())
+
+	resp, err := send()
+	if err != nil {
+		return signalResult{}, err
+	}
+	after, _, err := b.VersionTally(ctx, version, resp.GetHeight())
+	if err != nil {
+		return signalResult{}, fmt.Errorf("transaction %s was included but the tally query failed: %w", resp.GetTxhash(), err)
+	}
+	return signalResult{
+		Version:   version,
+		Validator: valoper,
+		TxHash:    resp.GetTxhash(),
+		Height:    resp.GetHeight(),
+		Before:    before.GetVotingPower(),
+		After:     after.GetVotingPower(),
+		Total:     after.GetTotalVotingPower(),
+		Threshold: after.GetThresholdPower(),
+	}, nil
+}
+
+// runSignal implements the signal subcommand: it signs and broadcasts a
+// MsgSignalVersion with a validator's operator key and checks the tally
+// moved once it is included. signal generate and signal broadcast split
+// this up for keys kept offline.
+func runSignal(args []string) {
+	if len(args) > 0 {
+		switch args[0] {
+		case "generate":
+			runSignalGenerate(args[1:])
+			return
+		case "broadcast":
+			runSignalBroadcast(args[1:])
+			return
+		}
+	}
+	home, _ := os.UserHomeDir()
+	fs := flag.NewFlagSet("signal", flag.ExitOnError)
+	addr := fs.String("grpc-addr", "", "gRPC address of the node to simulate and broadcast through (e.g., https://host:443)")
+	version := fs.Uint64("version", 0, "App version to signal for (0 = current app version + 1)")
+	from := fs.String("from", "", "Name of the validator operator key in the keyring")
+	keyringBackend := fs.String("keyring-backend", "file", "Keyring backend: file or test")
+	keyringDir := fs.String("home", filepath.Join(home, ".celestia-app"), "Directory holding the keyring")
+	validator := fs.String("validator", "", "Validator operator address to signal for (default: derived from the key)")
+	viaAuthz := fs.Bool("authz", false, "Signal for -validator through an authz grant to the -from key, wrapped in MsgExec")
+	chainID := fs.String("chain-id", "", "Chain ID to sign for (default: the node's)")
+	gasPrices := fs.String("gas-prices", "0.002utia", "Gas price to pay the fee at, or the node's minimum gas price if higher")
+	gasAdjustment := fs.Float64("gas-adjustment", 1.3, "Multiplier applied to the simulated gas")
+	timeout := fs.Duration("timeout", time.Minute, "How long to wait for the transaction to be included")
+	fs.Parse(args)
+
+	if *from == "" {
+		log.Fatal("signal: -from must be provided")
+	}
+	if *viaAuthz && *validator == "" {
+		log.Fatal("signal: -authz needs -validator")
+	}
+	price, err := parseGasPrice(*gasPrices)
+	if err != nil {
+		log.Fatal(err)
+	}
+	kr, err := openKeyring(*keyringBackend, *keyringDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	conn := dialSignal(*addr)
+	defer conn.Close()
+
+	signer := &txSigner{
+		conn:          conn,
+		keyring:       kr,
+		from:          *from,
+		chainID:       *chainID,
+		gasPrice:      price,
+		gasAdjustment: *gasAdjustment,
+		timeout:       *timeout,
+	}
+	signal := signalVersion
+	if *viaAuthz {
+		signal = authzSignalVersion
+	}
+	result, err := signal(context.Background(), signer, *version, *validator)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logSignalResult(result)
+}
+
+func logSignalResult(result signalResult) {
+	log.Printf("Signalled for version %d in transaction %s at height %d", result.Version, result.TxHash, result.Height)
+	log.Printf("Tally for version %d: %d -> %d of %d (threshold %d)", result.Version, result.Before, result.After, result.Total, result.Threshold)
+	if result.After == result.Before {
+		log.Printf("WARNING: the tally did not change; %s may already have been signalling for version %d", result.Validator, result.Version)
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	signaltypes "celestia-upgrade-monitor/celestia/signal/v1"
+
+	abcitypes "cosmossdk.io/api/cosmos/base/abci/v1beta1"
+	secp256k1types "cosmossdk.io/api/cosmos/crypto/secp256k1"
+	signingtypes "cosmossdk.io/api/cosmos/tx/signing/v1beta1"
+	txtypes "cosmossdk.io/api/cosmos/tx/v1beta1"
+	"github.com/cosmos/cosmos-sdk/crypto/keys/secp256k1"
+	"github.com/cosmos/cosmos-sdk/types/bech32"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// txJSON encodes transactions the way celestia-appd does, so files can go
+// through `celestia-appd tx sign` and back.
+var txJSON = protojson.MarshalOptions{UseProtoNames: true, Indent: "  "}
+
+// valoperAccount returns the account address bytes of a validator's
+// operator, which are the bytes of its operator address.
+func valoperAccount(valoper string) ([]byte, error) {
+	prefix, addr, err := bech32.DecodeAndConvert(valoper)
+	if err != nil {
+		return nil, fmt.Errorf("invalid validator address %s: %w", valoper, err)
+	}
+	if prefix != valoperPrefix {
+		return nil, fmt.Errorf("validator address %s should start with %s", valoper, valoperPrefix)
+	}
+	return addr, nil
+}
+
+// prepareSignal checks the node is on the signer's chain, or adopts the
+// node's chain ID if none is set, and resolves a version of 0 to the next
+// app version.
+func prepareSignal(ctx context.Context, s *txSigner, version uint64) (uint64, error) {
+	block, err := (&grpcBackend{conn: s.conn}).Block(ctx, 0)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get latest block: %w", err)
+	}
+	if s.chainID == "" {
+		s.chainID = block.ChainID
+	} else if s.chainID != block.ChainID {
+		return 0, fmt.Errorf("node is on chain %s, not %s", block.ChainID, s.chainID)
+	}
+	if version == 0 {
+		version = block.AppVersion + 1
+	}
+	return version, nil
+}
+
+// unsignedSignal is an unsigned MsgSignalVersion transaction and what is
+// needed to sign it offline.
+type unsignedSignal struct {
+	Tx            *txtypes.Tx
+	ChainID       string
+	AccountNumber uint64
+	Sequence      uint64
+}
+
+// generateSignal builds an unsigned MsgSignalVersion transaction for
+// valoper. Gas is simulated unless given.
+func generateSignal(ctx context.Context, s *txSigner, valoper string, version, gas uint64) (unsignedSignal, error) {
+	addr, err := valoperAccount(valoper)
+	if err != nil {
+		return unsignedSignal{}, err
+	}
+	key := signerKey{address: addr}
+	account, err := s.account(ctx, key.bech32(accountPrefix))
+	if err != nil {
+		return unsignedSignal{}, err
+	}
+	body, err := newTxBody(&signaltypes.MsgSignalVersion{ValidatorAddress: valoper, Version: version})
+	if err != nil {
+		return unsignedSignal{}, err
+	}
+	fee := &txtypes.Fee{GasLimit: gas}
+	if gas > 0 {
+		fee.Amount = append(fee.Amount, s.gasPrice.fee(gas))
+	} else {
+		bodyBytes, err := proto.Marshal(body)
+		if err != nil {
+			return unsignedSignal{}, err
+		}
+		if fee, err = s.estimateFee(ctx, bodyBytes, key, account.GetSequence()); err != nil {
+			return unsignedSignal{}, err
+		}
+	}
+	return unsignedSignal{
+		Tx:            &txtypes.Tx{Body: body, AuthInfo: &txtypes.AuthInfo{Fee: fee}},
+		ChainID:       s.chainID,
+		AccountNumber: account.GetAccountNumber(),
+		Sequence:      account.GetSequence(),
+	}, nil
+}
+
+// checkSignedSignal checks that a signed transaction carries exactly one
+// MsgSignalVersion for valoper and version, signed once by the
+// validator's operator key.
+func checkSignedSignal(tx *txtypes.Tx, valoper string, version uint64) (*txtypes.SignerInfo, error) {
+	msgs := tx.GetBody().GetMessages()
+	if len(msgs) != 1 {
+		return nil, fmt.Errorf("transaction has %d messages, want a single MsgSignalVersion", len(msgs))
+	}
+	var msg signaltypes.MsgSignalVersion
+	if err := msgs[0].UnmarshalTo(&msg); err != nil {
+		return nil, fmt.Errorf("transaction carries %s, not MsgSignalVersion", msgs[0].GetTypeUrl())
+	}
+	if msg.GetValidatorAddress() != valoper || msg.GetVersion() != version {
+		return nil, fmt.Errorf("transaction signals version %d for %s, want version %d for %s", msg.GetVersion(), msg.GetValidatorAddress(), version, valoper)
+	}
+
+	signers := tx.GetAuthInfo().GetSignerInfos()
+	if len(signers) != 1 || len(tx.GetSignatures()) != 1 || len(tx.GetSignatures()[0]) == 0 {
+		return nil, fmt.Errorf("transaction has %d signers and %d signatures, want one of each", len(signers), len(tx.GetSignatures()))
+	}
+	var pub secp256k1types.PubKey
+	if err := signers[0].GetPublicKey().UnmarshalTo(&pub); err != nil {
+		return nil, fmt.Errorf("transaction must be signed with a secp256k1 key: %w", err)
+	}
+	want, err := valoperAccount(valoper)
+	if err != nil {
+		return nil, err
+	}
+	if got := (&secp256k1.PubKey{Key: pub.GetKey()}).Address(); !bytes.Equal(got, want) {
+		signer, _ := bech32.ConvertAndEncode(accountPrefix, got)
+		return nil, fmt.Errorf("transaction is signed by %s, not the operator of %s", signer, valoper)
+	}
+	return signers[0], nil
+}
+
+// broadcastSignal checks a signed MsgSignalVersion transaction against the
+// expected validator and version and the signer's account, broadcasts it
+// and tracks the tally. SIGN_MODE_DIRECT signatures are verified before
+// broadcasting; other modes are left to the node.
+func broadcastSignal(ctx context.Context, s *txSigner, data []byte, valoper string, version uint64) (signalResult, error) {
+	var tx txtypes.Tx
+	if err := protojson.Unmarshal(data, &tx); err != nil {
+		return signalResult{}, fmt.Errorf("failed to parse signed transaction: %w", err)
+	}
+	signer, err := checkSignedSignal(&tx, valoper, version)
+	if err != nil {
+		return signalResult{}, err
+	}
+	addr, _ := valoperAccount(valoper)
+	account, err := s.account(ctx, signerKey{address: addr}.bech32(accountPrefix))
+	if err != nil {
+		return signalResult{}, err
+	}
+	if signer.GetSequence() != account.GetSequence() {
+		return signalResult{}, fmt.Errorf("transaction was signed for sequence %d but the account is at %d; generate and sign it again", signer.GetSequence(), account.GetSequence())
+	}
+
+	bodyBytes, err := proto.Marshal(tx.Body)
+	if err != nil {
+		return signalResult{}, err
+	}
+	authInfo, err := proto.Marshal(tx.AuthInfo)
+	if err != nil {
+		return signalResult{}, err
+	}
+	if signer.GetModeInfo().GetSingle().GetMode() == signingtypes.SignMode_SIGN_MODE_DIRECT {
+		signDoc, err := signDocBytes(bodyBytes, authInfo, s.chainID, account.GetAccountNumber())
+		if err != nil {
+			return signalResult{}, err
+		}
+		var pub secp256k1types.PubKey
+		signer.GetPublicKey().UnmarshalTo(&pub)
+		if !(&secp256k1.PubKey{Key: pub.GetKey()}).VerifySignature(signDoc, tx.Signatures[0]) {
+			return signalResult{}, fmt.Errorf("signature does not verify for chain %s and account number %d", s.chainID, account.GetAccountNumber())
+		}
+	}
+	raw, err := proto.Marshal(&txtypes.TxRaw{BodyBytes: bodyBytes, AuthInfoBytes: authInfo, Signatures: tx.Signatures})
+	if err != nil {
+		return signalResult{}, err
+	}
+
+	return trackSignal(ctx, &grpcBackend{conn: s.conn}, version, valoper, func() (*abcitypes.TxResponse, error) {
+		return s.broadcast(ctx, raw)
+	})
+}
+
+// dialSignal connects to the gRPC node the signal subcommands go through.
+func dialSignal(addr string) *grpc.ClientConn {
+	if addr == "" {
+		log.Fatal("signal: -grpc-addr must be provided")
+	}
+	parsedAddr, err := parseEndpointAddress(addr)
+	if err != nil {
+		log.Fatalf("Invalid address: %v", err)
+	}
+	if parsedAddr.transport != transportGRPC {
+		log.Fatal("signal: transactions can only be broadcast over gRPC")
+	}
+	conn, err := grpcClient(parsedAddr)
+	if err != nil {
+		log.Fatalf("Failed to connect to %s: %v", parsedAddr, err)
+	}
+	return conn
+}
+
+// runSignalGenerate implements signal generate: it writes an unsigned
+// MsgSignalVersion transaction to sign on an offline machine.
+func runSignalGenerate(args []string) {
+	fs := flag.NewFlagSet("signal generate", flag.ExitOnError)
+	addr := fs.String("grpc-addr", "", "gRPC address of the node to look up the account and simulate through")
+	validator := fs.String("validator", "", "Validator operator address to signal for")
+	version := fs.Uint64("version", 0, "App version to signal for (0 = current app version + 1)")
+	chainID := fs.String("chain-id", "", "Chain ID the transaction is for (default: the node's)")
+	gasPrices := fs.String("gas-prices", "0.002utia", "Gas price to pay the fee at, or the node's minimum gas price if higher")
+	gasAdjustment := fs.Float64("gas-adjustment", 1.3, "Multiplier applied to the simulated gas")
+	gas := fs.Uint64("gas", 0, "Gas limit (0 = simulate)")
+	output := fs.String("output", "", "File to write the unsigned transaction to (default: stdout)")
+	fs.Parse(args)
+
+	if *validator == "" {
+		log.Fatal("signal generate: -validator must be provided")
+	}
+	price, err := parseGasPrice(*gasPrices)
+	if err != nil {
+		log.Fatal(err)
+	}
+	conn := dialSignal(*addr)
+	defer conn.Close()
+	signer := &txSigner{conn: conn, chainID: *chainID, gasPrice: price, gasAdjustment: *gasAdjustment}
+
+	ctx := context.Background()
+	v, err := prepareSignal(ctx, signer, *version)
+	if err != nil {
+		log.Fatal(err)
+	}
+	unsigned, err := generateSignal(ctx, signer, *validator, v, *gas)
+	if err != nil {
+		log.Fatal(err)
+	}
+	data, err := txJSON.Marshal(unsigned.Tx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	data = append(data, '\n')
+	if *output == "" {
+		os.Stdout.Write(data)
+	} else if err := os.WriteFile(*output, data, 0o644); err != nil {
+		log.Fatal(err)
+	}
+
+	file := *output
+	if file == "" {
+		file = "unsigned.json"
+	}
+	log.Printf("Unsigned MsgSignalVersion for version %d from %s; sign it offline with:", v, *validator)
+	log.Printf("  celestia-appd tx sign %s --from <key> --offline --chain-id %s --account-number %d --sequence %d --sign-mode direct --output-document signed.json",
+		file, unsigned.ChainID, unsigned.AccountNumber, unsigned.Sequence)
+}
+
+// runSignalBroadcast implements signal broadcast: it checks a transaction
+// signed offline and broadcasts it.
+func runSignalBroadcast(args []string) {
+	fs := flag.NewFlagSet("signal broadcast", flag.ExitOnError)
+	addr := fs.String("grpc-addr", "", "gRPC address of the node to broadcast through")
+	file := fs.String("file", "", "Signed transaction JSON, as written by celestia-appd tx sign")
+	validator := fs.String("validator", "", "Validator operator address the transaction must signal for")
+	version := fs.Uint64("version", 0, "App version the transaction must signal for (0 = current app version + 1)")
+	chainID := fs.String("chain-id", "", "Chain ID the transaction was signed for (default: the node's)")
+	timeout := fs.Duration("timeout", time.Minute, "How long to wait for the transaction to be included")
+	fs.Parse(args)
+
+	if *file == "" || *validator == "" {
+		log.Fatal("signal broadcast: -file and -validator must be provided")
+	}
+	data, err := os.ReadFile(*file)
+	if err != nil {
+		log.Fatalf("Failed to read signed transaction: %v", err)
+	}
+	conn := dialSignal(*addr)
+	defer conn.Close()
+	signer := &txSigner{conn: conn, chainID: *chainID, timeout: *timeout}
+
+	ctx := context.Background()
+	v, err := prepareSignal(ctx, signer, *version)
+	if err != nil {
+		log.Fatal(err)
+	}
+	result, err := broadcastSignal(ctx, signer, data, *validator, v)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logSignalResult(result)
+}
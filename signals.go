@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	signaltypes "celestia-upgrade-monitor/celestia/signal/v1"
+
+	authztypes "cosmossdk.io/api/cosmos/authz/v1beta1"
+	txtypes "cosmossdk.io/api/cosmos/tx/v1beta1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+const (
+	msgSignalVersionURL = "/celestia.signal.v1.MsgSignalVersion"
+	msgTryUpgradeURL    = "/celestia.signal.v1.MsgTryUpgrade"
+
+	// Tokens per unit of consensus power (sdk.DefaultPowerReduction)
+	powerReduction = 1_000_000
+)
+
+// signalRecord describes the last version a validator signalled for.
+type signalRecord struct {
+	Validator string    `json:"validator"`
+	Moniker   string    `json:"moniker"`
+	Version   uint64    `json:"version"`
+	Power     int64     `json:"power"`
+	Height    int64     `json:"height"`
+	TxHash    string    `json:"tx_hash"`
+	Time      time.Time `json:"time"`
+}
+
+// signalRecords holds the latest signal seen from each validator.
+var signalRecords = struct {
+	sync.Mutex
+	byValidator map[string]signalRecord
+}{byValidator: map[string]signalRecord{}}
+
+// recentSignals returns the recorded signals, oldest first.
+func recentSignals() []signalRecord {
+	signalRecords.Lock()
+	defer signalRecords.Unlock()
+	records := make([]signalRecord, 0, len(signalRecords.byValidator))
+	for _, r := range signalRecords.byValidator {
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Height < records[j].Height })
+	return records
+}
+
+// subscribeSignalTxs registers subscriptions for MsgSignalVersion and
+// MsgTryUpgrade transactions.
+func subscribeSignalTxs(sub *cometSubscriber) {
+	sub.subscribe("signalversion", fmt.Sprintf("tm.event='Tx' AND message.action='%s'", msgSignalVersionURL), handleSignalTx)
+	sub.subscribe("tryupgrade", fmt.Sprintf("tm.event='Tx' AND message.action='%s'", msgTryUpgradeURL), handleSignalTx)
+	sub.subscribe("authzexec", fmt.Sprintf("tm.event='Tx' AND message.action='%s'", msgExecURL), handleSignalTx)
+}
+
+// handleSignalTx decodes a Tx event and reacts to the signal messages in it.
+func handleSignalTx(result rpcEventResult) {
+	var value struct {
+		TxResult struct {
+			Height int64  `json:"height,string"`
+			Tx     []byte `json:"tx"`
+			Result struct {
+				Code uint32 `json:"code"`
+			} `json:"result"`
+		} `json:"TxResult"`
+	}
+	if err := json.Unmarshal(result.Data.Value, &value); err != nil {
+		log.Printf("Failed to decode Tx event: %v", err)
+		return
+	}
+	if value.TxResult.Result.Code != 0 {
+		// Failed transactions don't change the tally
+		return
+	}
+	var txHash string
+	if hashes := result.Events["tx.hash"]; len(hashes) > 0 {
+		txHash = hashes[0]
+	}
+
+	msgs, err := decodeTxMessages(value.TxResult.Tx)
+	if err != nil {
+		log.Printf("Failed to decode transaction %s: %v", txHash, err)
+		return
+	}
+	height := value.TxResult.Height
+	for _, msg := range msgs {
+		switch m := msg.(type) {
+		case *signaltypes.MsgSignalVersion:
+			queueSignalWork(func() { onSignalVersion(m, height, txHash) })
+		case *signaltypes.MsgTryUpgrade:
+			queueSignalWork(func() { onTryUpgrade(m, height, txHash) })
+		}
+	}
+}
+
+// signalWork holds signals waiting for the queries they lead to, so that a
+// slow endpoint doesn't hold up the websocket read loop. One worker runs
+// them in order.
+var (
+	signalWork       = make(chan func(), 256)
+	signalWorkerOnce sync.Once
+)
+
+func queueSignalWork(work func()) {
+	signalWorkerOnce.Do(func() {
+		go func() {
+			for work := range signalWork {
+				work()
+			}
+		}()
+	})
+	select {
+	case signalWork <- work:
+	default:
+		// The next poll still picks up the tally
+		log.Printf("Signal queue is full, dropping a signal event")
+	}
+}
+
+// decodeTxMessages returns the signal messages contained in a raw tx,
+// including those executed through authz.
+func decodeTxMessages(txBytes []byte) ([]proto.Message, error) {
+	var raw txtypes.TxRaw
+	if err := proto.Unmarshal(txBytes, &raw); err != nil {
+		return nil, fmt.Errorf("failed to decode tx: %w", err)
+	}
+	var body txtypes.TxBody
+	if err := proto.Unmarshal(raw.BodyBytes, &body); err != nil {
+		return nil, fmt.Errorf("failed to decode tx body: %w", err)
+	}
+
+	return decodeSignalMessages(body.Messages)
+}
+
+func decodeSignalMessages(anys []*anypb.Any) ([]proto.Message, error) {
+	var msgs []proto.Message
+	for _, msgAny := range anys {
+		var msg proto.Message
+		switch msgAny.TypeUrl {
+		case msgExecURL:
+			var exec authztypes.MsgExec
+			if err := proto.Unmarshal(msgAny.Value, &exec); err != nil {
+				return nil, fmt.Errorf("failed to decode %s: %w", msgAny.TypeUrl, err)
+			}
+			inner, err := decodeSignalMessages(exec.Msgs)
+			if err != nil {
+				return nil, err
+			}
+			msgs = append(msgs, inner...)
+			continue
+		case msgSignalVersionURL:
+			msg = &signaltypes.MsgSignalVersion{}
+		case msgTryUpgradeURL:
+			msg = &signaltypes.MsgTryUpgrade{}
+		default:
+			continue
+		}
+		if err := proto.Unmarshal(msgAny.Value, msg); err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", msgAny.TypeUrl, err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+func onSignalVersion(msg *signaltypes.MsgSignalVersion, height int64, txHash string) {
+	record := signalRecord{
+		Validator: msg.ValidatorAddress,
+		Moniker:   msg.ValidatorAddress,
+		Version:   msg.Version,
+		Height:    height,
+		TxHash:    txHash,
+		Time:      time.Now(),
+	}
+	err := queryEndpoints(func(b backend) (string, error) {
+		moniker, power, err := getValidatorPower(b, msg.ValidatorAddress)
+		if err == nil {
+			record.Moniker = moniker
+			record.Power = power
+		}
+		return "", err
+	})
+	if err != nil {
+		log.Printf("Failed to look up validator %s: %v", msg.ValidatorAddress, err)
+	}
+	signalRecords.Lock()
+	previous, seen := signalRecords.byValidator[msg.ValidatorAddress]
+	signalRecords.byValidator[msg.ValidatorAddress] = record
+	signalRecords.Unlock()
+
+	// Refresh the tally straight away rather than waiting for the next poll.
+	// The signal is reported either way, without the tally if it failed.
+	change, tallyPercent, targetVersion := "tally unavailable", "unknown", "unknown"
+	if resp, err := updatePromMetrics(); err != nil {
+		log.Printf("Failed to refresh the tally after a signal from %s: %v", msg.ValidatorAddress, err)
+		requestPoll()
+	} else {
+		if selfCheck != nil && selfCheck.isOwnValidator(msg.ValidatorAddress) {
+			selfCheck.update(resp)
+		}
+		change = signalChange(previous, seen, record, resp.TallyData)
+		tallyPercent = strconv.FormatFloat(resp.TallyData.VotingPercent*100, 'f', 2, 64)
+		targetVersion = strconv.FormatUint(resp.TallyData.Version, 10)
+	}
+	emitEvent(Event{
+		Kind:    "validator_signalled",
+		Message: fmt.Sprintf("validator %s signalled version %d (%s)", record.Moniker, msg.Version, change),
+		Height:  height,
+		Fields: map[string]string{
+			"validator":      msg.ValidatorAddress,
+			"moniker":        record.Moniker,
+			"version":        strconv.FormatUint(msg.Version, 10),
+			"power":          strconv.FormatInt(record.Power, 10),
+			"tally_percent":  tallyPercent,
+			"tx_hash":        txHash,
+			"target_version": targetVersion,
+		},
+	})
+}
+
+// signalChange describes how a signal moved power between versions,
+// compared with the validator's previous signal if one was seen.
+func signalChange(previous signalRecord, seen bool, record signalRecord, tally TallyResponse) string {
+	if seen && previous.Version == record.Version {
+		return "again, no change in power"
+	}
+	var share float64
+	if tally.TotalVotingPower > 0 {
+		share = float64(record.Power) / float64(tally.TotalVotingPower) * 100
+	}
+	change := fmt.Sprintf("+%.2f%% power", share)
+	if record.Version != tally.Version {
+		change = fmt.Sprintf("+%.2f%% power for version %d, not the target %d", share, record.Version, tally.Version)
+	}
+	if seen {
+		change += fmt.Sprintf(", moved from version %d", previous.Version)
+	}
+	return change
+}
+
+func onTryUpgrade(msg *signaltypes.MsgTryUpgrade, height int64, txHash string) {
+	resp, err := updatePromMetrics()
+	if err != nil {
+		// Leave it to the poll loop to notice the scheduled upgrade
+		log.Printf("Failed to check for a scheduled upgrade after TryUpgrade from %s: %v", msg.Signer, err)
+		requestPoll()
+		return
+	}
+	upgrade := resp.UpgradeData.Upgrade
+	if upgrade.UpgradeHeight == 0 {
+		log.Printf("TryUpgrade from %s at height %d did not schedule an upgrade", msg.Signer, height)
+		return
+	}
+	emitEvent(Event{
+		Kind:    "upgrade_scheduled",
+		Message: fmt.Sprintf("upgrade to version %d scheduled at height %d", upgrade.AppVersion, upgrade.UpgradeHeight),
+		Height:  height,
+		Fields: map[string]string{
+			"signer":         msg.Signer,
+			"app_version":    strconv.Itoa(upgrade.AppVersion),
+			"upgrade_height": strconv.FormatInt(upgrade.UpgradeHeight, 10),
+			"tx_hash":        txHash,
+		},
+	})
+	requestPoll()
+}
+
+// getValidatorPower returns the moniker and consensus power of a validator.
+func getValidatorPower(b backend, valoper string) (string, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	validator, err := b.Validator(ctx, valoper)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to get validator: %w", err)
+	}
+	return validator.GetDescription().GetMoniker(), tokensToPower(validator.GetTokens()), nil
+}
+
+// tokensToPower converts a token amount into consensus power.
+func tokensToPower(tokens string) int64 {
+	amount, ok := new(big.Int).SetString(tokens, 10)
+	if !ok {
+		return 0
+	}
+	return amount.Quo(amount, big.NewInt(powerReduction)).Int64()
+}
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestSignalChange(t *testing.T) {
+	tally := TallyResponse{Version: 2, TotalVotingPower: 110}
+	record := func(version uint64) signalRecord {
+		return signalRecord{Version: version, Power: 10}
+	}
+	tests := []struct {
+		name     string
+		previous signalRecord
+		seen     bool
+		record   signalRecord
+		want     string
+	}{
+		{"first signal", signalRecord{}, false, record(2), "+9.09% power"},
+		{"same version again", record(2), true, record(2), "again, no change in power"},
+		{"another version", record(2), true, record(3), "+9.09% power for version 3, not the target 2, moved from version 2"},
+		{"back to the target", record(3), true, record(2), "+9.09% power, moved from version 3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := signalChange(tt.previous, tt.seen, tt.record, tally); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"celestia-upgrade-monitor/fake"
+)
+
+// scenario describes a simulated upgrade. Times are offsets from the start
+// of the simulation in chain time, before acceleration.
+type scenario struct {
+	ChainID       string   `json:"chain_id"`
+	StartHeight   int64    `json:"start_height"`
+	AppVersion    uint64   `json:"app_version"`
+	TargetVersion uint64   `json:"target_version"`
+	BlockTime     duration `json:"block_time"`
+	Speed         float64  `json:"speed"`
+
+	// UpgradeDelay is how many blocks after a successful TryUpgrade the
+	// chain upgrades
+	UpgradeDelay int64               `json:"upgrade_delay"`
+	Validators   []scenarioValidator `json:"validators"`
+	TryUpgradeAt *duration           `json:"try_upgrade_at,omitempty"`
+	Stall        *scenarioStall      `json:"stall,omitempty"`
+}
+
+type scenarioValidator struct {
+	Name     string    `json:"name"`
+	Power    int64     `json:"power"`
+	SignalAt *duration `json:"signal_at,omitempty"`
+}
+
+// scenarioStall stops block production at a height for a while.
+type scenarioStall struct {
+	AtHeight int64    `json:"at_height"`
+	Duration duration `json:"duration"`
+}
+
+// duration is a time.Duration read from a JSON string such as "90m".
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+func loadScenario(path string) (scenario, error) {
+	sc := scenario{
+		ChainID:      "simnet-1",
+		StartHeight:  1,
+		AppVersion:   1,
+		BlockTime:    duration(6 * time.Second),
+		Speed:        60,
+		UpgradeDelay: 100,
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return sc, fmt.Errorf("failed to read scenario: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&sc); err != nil {
+		return sc, fmt.Errorf("failed to parse scenario %s: %w", path, err)
+	}
+	if sc.TargetVersion == 0 {
+		sc.TargetVersion = sc.AppVersion + 1
+	}
+	if len(sc.Validators) == 0 {
+		return sc, fmt.Errorf("scenario %s has no validators", path)
+	}
+	if sc.Speed <= 0 || sc.BlockTime <= 0 {
+		return sc, fmt.Errorf("scenario %s: speed and block_time must be positive", path)
+	}
+	return sc, nil
+}
+
+// simulation drives a fake node through a scenario.
+type simulation struct {
+	sc   scenario
+	node *fake.Server
+}
+
+// scale converts a chain time duration to wall time.
+func (sim *simulation) scale(d time.Duration) time.Duration {
+	return time.Duration(float64(d) / sim.sc.Speed)
+}
+
+func simValoper(i int) string {
+	return fmt.Sprintf("celestiavaloper1sim%03d", i)
+}
+
+// newSimulation sets up a fake node at the start of a scenario.
+func newSimulation(sc scenario) *simulation {
+	sim := &simulation{sc: sc, node: fake.New(sc.ChainID)}
+	sim.node.SetHeight(sc.StartHeight)
+	sim.node.SetAppVersion(sc.AppVersion)
+	sim.node.SetBlockTime(time.Duration(sc.BlockTime))
+	sim.node.SetUpgradeDelay(sc.UpgradeDelay)
+	for i, v := range sc.Validators {
+		sim.node.SetValidator(fake.Validator{Address: simValoper(i), Moniker: v.Name, Power: v.Power})
+	}
+	return sim
+}
+
+// run produces blocks and replays the scenario's transactions until ctx is
+// cancelled.
+func (sim *simulation) run(ctx context.Context) {
+	blockTime := time.Duration(sim.sc.BlockTime)
+	type signal struct {
+		at      time.Duration
+		valoper string
+		name    string
+	}
+	var signals []signal
+	for i, v := range sim.sc.Validators {
+		if v.SignalAt != nil {
+			signals = append(signals, signal{time.Duration(*v.SignalAt), simValoper(i), v.Name})
+		}
+	}
+	sort.Slice(signals, func(i, j int) bool { return signals[i].at < signals[j].at })
+
+	start := sim.node.Height()
+	tried, stalled, done := false, false, false
+	for ctx.Err() == nil {
+		height := sim.node.Height()
+		elapsed := time.Duration(height-start) * blockTime
+
+		for len(signals) > 0 && signals[0].at <= elapsed {
+			log.Printf("[simulate] %s signals version %d at height %d", signals[0].name, sim.sc.TargetVersion, height)
+			if err := sim.node.SignalVersion(signals[0].valoper, sim.sc.TargetVersion); err != nil {
+				log.Printf("[simulate] failed to publish signal: %v", err)
+			}
+			signals = signals[1:]
+		}
+		if !tried && sim.sc.TryUpgradeAt != nil && elapsed >= time.Duration(*sim.sc.TryUpgradeAt) {
+			tried = true
+			scheduled, err := sim.node.TryUpgrade(simValoper(0))
+			if err != nil {
+				log.Printf("[simulate] failed to publish TryUpgrade: %v", err)
+			}
+			if scheduled {
+				log.Printf("[simulate] TryUpgrade at height %d scheduled the upgrade for height %d", height, height+sim.sc.UpgradeDelay)
+			} else {
+				log.Printf("[simulate] TryUpgrade at height %d did not reach the threshold", height)
+			}
+		}
+		if sim.sc.Stall != nil && !stalled && height >= sim.sc.Stall.AtHeight {
+			stalled = true
+			log.Printf("[simulate] chain stalls at height %d for %s", height, time.Duration(sim.sc.Stall.Duration))
+			if !sleepCtx(ctx, sim.scale(time.Duration(sim.sc.Stall.Duration))) {
+				return
+			}
+			log.Printf("[simulate] chain resumes")
+		}
+
+		if !sleepCtx(ctx, sim.scale(blockTime)) {
+			return
+		}
+		sim.node.AdvanceHeight(1)
+
+		if !done && sim.node.AppVersion() == sim.sc.TargetVersion {
+			done = true
+			log.Printf("[simulate] chain upgraded to version %d at height %d; scenario complete, blocks keep coming", sim.sc.TargetVersion, sim.node.Height())
+		}
+	}
+}
+
+// sleepCtx sleeps for d and reports whether ctx is still live.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// runSimulate implements the simulate subcommand. It serves a fake node
+// playing the scenario and runs the normal monitor against it; any flags
+// after the simulate flags are passed to the monitor.
+func runSimulate(args []string) {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	scenarioFile := fs.String("scenario", "", "Scenario file describing validators, signal times, TryUpgrade and stalls")
+	speed := fs.Float64("speed", 0, "Time acceleration factor (0 = use the scenario's)")
+	fs.Parse(args)
+
+	if *scenarioFile == "" {
+		log.Fatal("simulate: -scenario must be provided")
+	}
+	sc, err := loadScenario(*scenarioFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *speed > 0 {
+		sc.Speed = *speed
+	}
+	sim := newSimulation(sc)
+
+	grpcAddr, _, err := sim.node.Listen("127.0.0.1:0")
+	if err != nil {
+		log.Fatal(err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/websocket", sim.node.WebsocketHandler())
+	go http.Serve(lis, mux)
+
+	log.Printf("[simulate] %s: %d validators, %gx speed, fake node at %s", sc.ChainID, len(sc.Validators), sc.Speed, grpcAddr)
+	go sim.run(context.Background())
+
+	// Poll intervals and block times shrink with the simulation so that the
+	// monitor behaves as it would over the real timescale
+	monitorArgs := []string{
+		"-grpc-addr", grpcAddr,
+		"-rpc-addr", "http://" + lis.Addr().String(),
+		"-block-time", sim.scale(time.Duration(sc.BlockTime)).String(),
+		"-poll-idle", sim.scale(30 * time.Minute).String(),
+		"-poll-signalling", sim.scale(5 * time.Minute).String(),
+		"-poll-scheduled", sim.scale(time.Minute).String(),
+		"-poll-min", sim.scale(time.Second).String(),
+		"-poll-max", sim.scale(time.Hour).String(),
+		"-ready-max-age", sim.scale(time.Hour).String(),
+		"-target-version", strconv.FormatUint(sc.TargetVersion, 10),
+	}
+	runMonitor(append(monitorArgs, fs.Args()...))
+}
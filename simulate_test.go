@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadScenario(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scenario.json")
+	load := func(content string) (scenario, error) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return loadScenario(path)
+	}
+
+	sc, err := load(`{"app_version": 3, "validators": [{"name": "a", "power": 10, "signal_at": "90m"}], "stall": {"at_height": 50, "duration": "2m"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.ChainID != "simnet-1" || sc.StartHeight != 1 || sc.TargetVersion != 4 || sc.Speed != 60 || sc.UpgradeDelay != 100 || time.Duration(sc.BlockTime) != 6*time.Second {
+		t.Errorf("defaults not applied: %+v", sc)
+	}
+	if v := sc.Validators[0]; v.SignalAt == nil || time.Duration(*v.SignalAt) != 90*time.Minute {
+		t.Errorf("got validator %+v, want a signal at 90m", v)
+	}
+	if sc.Stall == nil || sc.Stall.AtHeight != 50 || time.Duration(sc.Stall.Duration) != 2*time.Minute || sc.TryUpgradeAt != nil {
+		t.Errorf("got stall %+v and try_upgrade_at %v", sc.Stall, sc.TryUpgradeAt)
+	}
+
+	for _, tt := range []struct{ content, want string }{
+		{`{"validators": []}`, "no validators"},
+		{`{"speed": 0, "validators": [{"name": "a", "power": 1}]}`, "must be positive"},
+		{`{"block_time": "-6s", "validators": [{"name": "a", "power": 1}]}`, "must be positive"},
+		{`{"block_time": "6 seconds", "validators": [{"name": "a", "power": 1}]}`, "failed to parse"},
+		{`{"validator": [{"name": "a", "power": 1}]}`, "unknown field"},
+	} {
+		if _, err := load(tt.content); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tt.content, err, tt.want)
+		}
+	}
+	if _, err := loadScenario(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("want an error for a missing scenario")
+	}
+}
+
+func TestSimulation(t *testing.T) {
+	one := func(d time.Duration) *duration {
+		v := duration(d)
+		return &v
+	}
+	// Blocks every 50ms, signals at blocks 2 and 4, TryUpgrade at block 6,
+	// the upgrade 10 blocks later and a 1.5s stall after it, past the
+	// once a second halt check
+	sc := scenario{
+		ChainID:       testChainID,
+		StartHeight:   100,
+		AppVersion:    1,
+		TargetVersion: 2,
+		BlockTime:     duration(6 * time.Second),
+		Speed:         120,
+		UpgradeDelay:  10,
+		Validators: []scenarioValidator{
+			{Name: "big", Power: 60, SignalAt: one(12 * time.Second)},
+			{Name: "medium", Power: 30, SignalAt: one(24 * time.Second)},
+			{Name: "small", Power: 10},
+		},
+		TryUpgradeAt: one(36 * time.Second),
+		Stall:        &scenarioStall{AtHeight: 125, Duration: duration(3 * time.Minute)},
+	}
+	sim := newSimulation(sc)
+	addr, stop, err := sim.node.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(stop)
+	parsed, err := parseEndpointAddress(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useEndpoints(t, parsed)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	srv := httptest.NewServer(sim.node.WebsocketHandler())
+	t.Cleanup(srv.Close)
+	sub, err := newCometSubscriber(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	connected := make(chan struct{}, 1)
+	sub.onConnect = func(ok bool) {
+		if ok {
+			connected <- struct{}{}
+		}
+	}
+	w := newBlockWatcher(sim.scale(time.Duration(sc.BlockTime)), 5)
+	w.watch(ctx, sub)
+	go sub.run(ctx)
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("websocket didn't connect")
+	}
+	before := len(recentEvents())
+	go sim.run(ctx)
+
+	// Poll as the monitor would, recording each lifecycle state
+	states := []lifecycleState{}
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		data, err := queryUpgrade(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.setUpgradeHeight(data.UpgradeData.Upgrade.UpgradeHeight)
+		if state := lifecycle(data, 5); len(states) == 0 || states[len(states)-1] != state {
+			states = append(states, state)
+		}
+		if data.Height > sc.Stall.AtHeight+2 {
+			break
+		}
+	}
+	cancel()
+
+	want := []lifecycleState{stateIdle, stateSignalling, stateScheduled, stateFinal}
+	if len(states) < len(want) || !slices.Equal(states[:len(want)], want) {
+		t.Errorf("got lifecycle %v, want it to start %v", states, want)
+	}
+	if sim.node.AppVersion() != 2 {
+		t.Errorf("chain is on app version %d, want 2", sim.node.AppVersion())
+	}
+	var kinds []string
+	for _, e := range recentEvents()[before:] {
+		kinds = append(kinds, e.Kind)
+	}
+	for _, kind := range []string{"upgrade_height_reached", "app_version_switched", "chain_halted", "chain_resumed"} {
+		if !slices.Contains(kinds, kind) {
+			t.Errorf("no %s event in %v", kind, kinds)
+		}
+	}
+	if i, j := slices.Index(kinds, "chain_halted"), slices.Index(kinds, "chain_resumed"); i > j {
+		t.Errorf("chain resumed before it halted: %v", kinds)
+	}
+}
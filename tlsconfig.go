@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+func parseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported TLS min_version %q (use 1.2 or 1.3)", version)
+	}
+}
+
+// reloadingTLS loads the CA bundle and client certificate of a tlsConfig
+// and reloads them whenever the files change on disk.
+type reloadingTLS struct {
+	cfg tlsConfig
+	// serverName is the name the server certificate must be valid for
+	serverName string
+
+	mu       sync.Mutex
+	roots    *x509.CertPool
+	caMod    time.Time
+	cert     *tls.Certificate
+	certMod  time.Time
+	keyMod   time.Time
+	lastLoad error
+}
+
+// clientTLSConfig builds the tls.Config for an endpoint.
+func clientTLSConfig(cfg tlsConfig, hostname string) (*tls.Config, error) {
+	minVersion, err := parseTLSVersion(cfg.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	serverName := hostname
+	if cfg.ServerName != "" {
+		serverName = cfg.ServerName
+	}
+	tlsCfg := &tls.Config{
+		ServerName: serverName,
+		MinVersion: minVersion,
+	}
+
+	r := &reloadingTLS{cfg: cfg, serverName: serverName}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	if cfg.CertFile != "" {
+		tlsCfg.GetClientCertificate = r.clientCertificate
+	}
+
+	switch {
+	case cfg.InsecureSkipVerify:
+		log.Printf("WARNING: TLS certificate verification is disabled for %s", hostname)
+		tlsCfg.InsecureSkipVerify = true
+	case cfg.CAFile != "":
+		// Verify against the current CA bundle ourselves so that it can be
+		// swapped without rebuilding the connection
+		tlsCfg.InsecureSkipVerify = true
+		tlsCfg.VerifyConnection = r.verifyConnection
+	}
+	return tlsCfg, nil
+}
+
+// reload re-reads any file whose modification time changed.
+func (r *reloadingTLS) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cfg.CAFile != "" {
+		mod, err := modTime(r.cfg.CAFile)
+		if err != nil {
+			return err
+		}
+		if !mod.Equal(r.caMod) {
+			pem, err := os.ReadFile(r.cfg.CAFile)
+			if err != nil {
+				return fmt.Errorf("failed to read CA file: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return fmt.Errorf("no certificates found in CA file %s", r.cfg.CAFile)
+			}
+			if !r.caMod.IsZero() {
+				log.Printf("Reloaded CA bundle %s", r.cfg.CAFile)
+			}
+			r.roots, r.caMod = pool, mod
+		}
+	}
+
+	if r.cfg.CertFile != "" {
+		certMod, err := modTime(r.cfg.CertFile)
+		if err != nil {
+			return err
+		}
+		keyMod, err := modTime(r.cfg.KeyFile)
+		if err != nil {
+			return err
+		}
+		if !certMod.Equal(r.certMod) || !keyMod.Equal(r.keyMod) {
+			cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+			if err != nil {
+				return fmt.Errorf("failed to load client certificate: %w", err)
+			}
+			if !r.certMod.IsZero() {
+				log.Printf("Reloaded client certificate %s", r.cfg.CertFile)
+			}
+			r.cert, r.certMod, r.keyMod = &cert, certMod, keyMod
+		}
+	}
+	return nil
+}
+
+// reloadQuietly reloads on a handshake, keeping the previous files if the
+// new ones can't be loaded (e.g. while they are being rewritten).
+func (r *reloadingTLS) reloadQuietly() {
+	err := r.reload()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil && (r.lastLoad == nil || r.lastLoad.Error() != err.Error()) {
+		log.Printf("Failed to reload TLS files, keeping previous ones: %v", err)
+	}
+	r.lastLoad = err
+}
+
+func (r *reloadingTLS) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.reloadQuietly()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cert, nil
+}
+
+func (r *reloadingTLS) verifyConnection(cs tls.ConnectionState) error {
+	r.reloadQuietly()
+	r.mu.Lock()
+	roots := r.roots
+	r.mu.Unlock()
+
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("server presented no certificate")
+	}
+	// Not cs.ServerName, which is empty when connecting by IP address as no
+	// SNI is sent
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       r.serverName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+func modTime(path string) (time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	return info.ModTime(), nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseTLSVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    uint16
+	}{
+		{"", tls.VersionTLS12},
+		{"1.2", tls.VersionTLS12},
+		{"1.3", tls.VersionTLS13},
+	}
+	for _, tt := range tests {
+		if got, err := parseTLSVersion(tt.version); err != nil || got != tt.want {
+			t.Errorf("%q: got %x, %v, want %x", tt.version, got, err, tt.want)
+		}
+	}
+	for _, version := range []string{"1.1", "tls1.3"} {
+		if _, err := parseTLSVersion(version); err == nil {
+			t.Errorf("%q: want an error", version)
+		}
+	}
+}
+
+// testCA is a private CA issuing certificates for tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a certificate for dnsName signed by the CA, with its PEM
+// encoded certificate and key.
+func (ca *testCA) issue(t *testing.T, dnsName string, usage x509.ExtKeyUsage) (tls.Certificate, []byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: dnsName},
+		DNSNames:     []string{dnsName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, certPEM, keyPEM
+}
+
+// writeFile writes data to path with a modification time past any earlier
+// write, so a reload notices it.
+func writeFile(t *testing.T, path string, data []byte, mod time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mod, mod); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTLSServer starts an HTTPS server presenting cert. With clientCA set it
+// requires a client certificate issued by it.
+func newTLSServer(t *testing.T, cert tls.Certificate, clientCA *testCA, maxVersion uint16) string {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{cert}, MaxVersion: maxVersion}
+	if clientCA != nil {
+		pool := x509.NewCertPool()
+		pool.AddCert(clientCA.cert)
+		srv.TLS.ClientCAs = pool
+		srv.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv.Listener.Addr().String()
+}
+
+// tlsGet makes a request to addr over a fresh connection using cfg.
+func tlsGet(addr string, cfg *tls.Config) error {
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: cfg, DisableKeepAlives: true},
+		Timeout:   5 * time.Second,
+	}
+	resp, err := client.Get("https://" + addr)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func TestClientTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, "private CA")
+	other := newTestCA(t, "unknown CA")
+	caFile := filepath.Join(dir, "ca.pem")
+	otherFile := filepath.Join(dir, "other.pem")
+	start := time.Now().Add(-time.Minute)
+	writeFile(t, caFile, ca.pem, start)
+	writeFile(t, otherFile, other.pem, start)
+
+	// The node's certificate names a host, but it's reached by IP address
+	serverCert, _, _ := ca.issue(t, "grpc.node.internal", x509.ExtKeyUsageServerAuth)
+	addr := newTLSServer(t, serverCert, nil, 0)
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	connect := func(cfg tlsConfig, addr string) error {
+		t.Helper()
+		tlsCfg, err := clientTLSConfig(cfg, host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tlsGet(addr, tlsCfg)
+	}
+
+	if err := connect(tlsConfig{CAFile: caFile, ServerName: "grpc.node.internal"}, addr); err != nil {
+		t.Errorf("private CA with a server name override: %v", err)
+	}
+	if err := connect(tlsConfig{CAFile: caFile}, addr); err == nil {
+		t.Error("certificate for another name accepted without a server name override")
+	}
+	if err := connect(tlsConfig{CAFile: otherFile, ServerName: "grpc.node.internal"}, addr); err == nil {
+		t.Error("certificate from an unknown CA accepted")
+	}
+	if err := connect(tlsConfig{ServerName: "grpc.node.internal"}, addr); err == nil {
+		t.Error("certificate from a private CA accepted by the system roots")
+	}
+	if err := connect(tlsConfig{InsecureSkipVerify: true}, addr); err != nil {
+		t.Errorf("insecure_skip_verify: %v", err)
+	}
+
+	// min_version refuses a server that only speaks TLS 1.2
+	tls12 := newTLSServer(t, serverCert, nil, tls.VersionTLS12)
+	if err := connect(tlsConfig{CAFile: caFile, ServerName: "grpc.node.internal"}, tls12); err != nil {
+		t.Errorf("TLS 1.2 server with the default min_version: %v", err)
+	}
+	if err := connect(tlsConfig{CAFile: caFile, ServerName: "grpc.node.internal", MinVersion: "1.3"}, tls12); err == nil {
+		t.Error("TLS 1.2 server accepted with min_version 1.3")
+	}
+	if _, err := clientTLSConfig(tlsConfig{MinVersion: "1.0"}, host); err == nil {
+		t.Error("min_version 1.0 accepted")
+	}
+
+	// mTLS sends the client certificate, and picks up a rotated one
+	mtls := newTLSServer(t, serverCert, ca, 0)
+	_, certPEM, keyPEM := ca.issue(t, "monitor", x509.ExtKeyUsageClientAuth)
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client-key.pem")
+	writeFile(t, certFile, certPEM, start)
+	writeFile(t, keyFile, keyPEM, start)
+	mtlsCfg := tlsConfig{CAFile: caFile, ServerName: "grpc.node.internal", CertFile: certFile, KeyFile: keyFile}
+	client, err := clientTLSConfig(mtlsCfg, host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tlsGet(mtls, client); err != nil {
+		t.Errorf("mTLS with a client certificate: %v", err)
+	}
+	if err := connect(tlsConfig{CAFile: caFile, ServerName: "grpc.node.internal"}, mtls); err == nil {
+		t.Error("mTLS server accepted a client without a certificate")
+	}
+	_, certPEM, keyPEM = other.issue(t, "monitor", x509.ExtKeyUsageClientAuth)
+	writeFile(t, certFile, certPEM, start.Add(time.Second))
+	writeFile(t, keyFile, keyPEM, start.Add(time.Second))
+	if err := tlsGet(mtls, client); err == nil {
+		t.Error("rotated client certificate from an unknown CA not picked up")
+	}
+
+	// A rotated CA bundle is picked up without rebuilding the config
+	rotated := newTestCA(t, "rotated CA")
+	rotatedCert, _, _ := rotated.issue(t, "grpc.node.internal", x509.ExtKeyUsageServerAuth)
+	rotatedAddr := newTLSServer(t, rotatedCert, nil, 0)
+	server, err := clientTLSConfig(tlsConfig{CAFile: caFile, ServerName: "grpc.node.internal"}, host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tlsGet(rotatedAddr, server); err == nil {
+		t.Fatal("certificate from the rotated CA accepted before the rotation")
+	}
+	writeFile(t, caFile, rotated.pem, start.Add(time.Second))
+	if err := tlsGet(rotatedAddr, server); err != nil {
+		t.Errorf("certificate from the rotated CA after the rotation: %v", err)
+	}
+	if err := tlsGet(addr, server); err == nil {
+		t.Error("certificate from the old CA accepted after the rotation")
+	}
+	// A half written bundle keeps the previous one
+	writeFile(t, caFile, []byte("-----BEGIN CERT"), start.Add(2*time.Second))
+	if err := tlsGet(rotatedAddr, server); err != nil {
+		t.Errorf("previous CA bundle not kept while the file is broken: %v", err)
+	}
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	authtypes "cosmossdk.io/api/cosmos/auth/v1beta1"
+	abcitypes "cosmossdk.io/api/cosmos/base/abci/v1beta1"
+	nodetypes "cosmossdk.io/api/cosmos/base/node/v1beta1"
+	basetypes "cosmossdk.io/api/cosmos/base/v1beta1"
+	signingtypes "cosmossdk.io/api/cosmos/tx/signing/v1beta1"
+	txtypes "cosmossdk.io/api/cosmos/tx/v1beta1"
+	vestingtypes "cosmossdk.io/api/cosmos/vesting/v1beta1"
+	"github.com/cosmos/cosmos-sdk/codec"
+	codectypes "github.com/cosmos/cosmos-sdk/codec/types"
+	cryptocodec "github.com/cosmos/cosmos-sdk/crypto/codec"
+	"github.com/cosmos/cosmos-sdk/crypto/keyring"
+	"github.com/cosmos/cosmos-sdk/types/bech32"
+	"github.com/cosmos/cosmos-sdk/types/tx/signing"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// Bech32 prefixes of celestia account and validator operator addresses
+const (
+	accountPrefix = "celestia"
+	valoperPrefix = "celestiavaloper"
+)
+
+// keyringCodec returns the codec keyring records are stored with.
+func keyringCodec() codec.Codec {
+	registry := codectypes.NewInterfaceRegistry()
+	cryptocodec.RegisterInterfaces(registry)
+	return codec.NewProtoCodec(registry)
+}
+
+// openKeyring opens a celestia-appd keyring in home. Only the file and
+// test backends are supported; the file backend prompts for its
+// passphrase on stdin.
+func openKeyring(backend, home string) (keyring.Keyring, error) {
+	if backend != keyring.BackendFile && backend != keyring.BackendTest {
+		return nil, fmt.Errorf("unsupported keyring backend %q (use file or test)", backend)
+	}
+	kr, err := keyring.New("celestia-appd", backend, home, os.Stdin, keyringCodec())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s keyring in %s: %w", backend, home, err)
+	}
+	return kr, nil
+}
+
+// errFeeTooHigh is returned when a transaction would cost more than the
+// signer's fee cap.
+var errFeeTooHigh = errors.New("fee too high")
+
+// parseCoin parses an amount such as 200000utia.
+func parseCoin(s string) (uint64, string, error) {
+	m := coinPattern.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, "", fmt.Errorf("invalid amount %q (e.g., 200000utia)", s)
+	}
+	amount, err := strconv.ParseUint(m[1], 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid amount %q: %w", s, err)
+	}
+	return amount, m[2], nil
+}
+
+var coinPattern = regexp.MustCompile(`^([0-9]+)([a-zA-Z][a-zA-Z0-9/]*)$`)
+
+// gasPrice is a price per unit of gas such as 0.002utia.
+type gasPrice struct {
+	amount float64
+	denom  string
+}
+
+var gasPricePattern = regexp.MustCompile(`^([0-9]*\.?[0-9]+)([a-zA-Z][a-zA-Z0-9/]*)$`)
+
+func parseGasPrice(s string) (gasPrice, error) {
+	m := gasPricePattern.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return gasPrice{}, fmt.Errorf("invalid gas price %q (e.g., 0.002utia)", s)
+	}
+	amount, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return gasPrice{}, fmt.Errorf("invalid gas price %q: %w", s, err)
+	}
+	return gasPrice{amount: amount, denom: m[2]}, nil
+}
+
+// fee returns the fee for gas units, rounded up.
+func (p gasPrice) fee(gas uint64) *basetypes.Coin {
+	return &basetypes.Coin{
+		Denom:  p.denom,
+		Amount: strconv.FormatUint(uint64(math.Ceil(float64(gas)*p.amount)), 10),
+	}
+}
+
+// txSigner signs transactions with a key from a keyring and broadcasts
+// them through a node's gRPC tx service.
+type txSigner struct {
+	conn          *grpc.ClientConn
+	keyring       keyring.Keyring
+	from          string
+	chainID       string
+	gasPrice      gasPrice
+	gasAdjustment float64
+
+	// maxFee refuses transactions whose fee would be higher, in the gas
+	// price's denom; 0 means no cap
+	maxFee uint64
+
+	// timeout bounds the wait for a broadcast transaction to be included
+	timeout time.Duration
+}
+
+// signerKey is the signing key's address and public key.
+type signerKey struct {
+	address []byte
+	pubKey  *anypb.Any
+}
+
+// bech32 returns the key's address with prefix, e.g. valoperPrefix for the
+// operator address of a validator whose operator key it is.
+func (k signerKey) bech32(prefix string) string {
+	addr, _ := bech32.ConvertAndEncode(prefix, k.address)
+	return addr
+}
+
+func (s *txSigner) key() (signerKey, error) {
+	record, err := s.keyring.Key(s.from)
+	if err != nil {
+		return signerKey{}, fmt.Errorf("failed to load key %s: %w", s.from, err)
+	}
+	addr, err := record.GetAddress()
+	if err != nil {
+		return signerKey{}, err
+	}
+	pub, err := record.GetPubKey()
+	if err != nil {
+		return signerKey{}, err
+	}
+	pubAny, err := codectypes.NewAnyWithValue(pub)
+	if err != nil {
+		return signerKey{}, err
+	}
+	return signerKey{address: addr, pubKey: &anypb.Any{TypeUrl: pubAny.TypeUrl, Value: pubAny.Value}}, nil
+}
+
+// account returns the account number and sequence of address.
+func (s *txSigner) account(ctx context.Context, address string) (accountI, error) {
+	resp, err := authtypes.NewQueryClient(s.conn).Account(ctx, &authtypes.QueryAccountRequest{Address: address})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query account %s: %w", address, err)
+	}
+	// Resolved through the registry of the API types, which holds every
+	// account type of the auth and vesting modules
+	msg, err := resp.GetAccount().UnmarshalNew()
+	if err != nil {
+		return nil, fmt.Errorf("account %s has an unknown type %s: %w", address, resp.GetAccount().GetTypeUrl(), err)
+	}
+	var base *authtypes.BaseAccount
+	switch account := msg.(type) {
+	case *authtypes.BaseAccount:
+		base = account
+	case interface{ GetBaseAccount() *authtypes.BaseAccount }:
+		base = account.GetBaseAccount()
+	case interface {
+		GetBaseVestingAccount() *vestingtypes.BaseVestingAccount
+	}:
+		base = account.GetBaseVestingAccount().GetBaseAccount()
+	}
+	if base == nil {
+		return nil, fmt.Errorf("account %s of type %s has no account number or sequence", address, resp.GetAccount().GetTypeUrl())
+	}
+	return base, nil
+}
+
+// accountI is the part of the SDK's AccountI that signing needs. Base,
+// module and vesting accounts all have it through their base account.
+type accountI interface {
+	GetAccountNumber() uint64
+	GetSequence() uint64
+}
+
+// newAny packs msg the way Cosmos SDK transactions expect, with a type URL
+// of just "/" and the message name.
+func newAny(msg proto.Message) (*anypb.Any, error) {
+	value, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return &anypb.Any{TypeUrl: "/" + string(msg.ProtoReflect().Descriptor().FullName()), Value: value}, nil
+}
+
+func authInfoBytes(key signerKey, sequence uint64, fee *txtypes.Fee) ([]byte, error) {
+	return proto.Marshal(&txtypes.AuthInfo{
+		SignerInfos: []*txtypes.SignerInfo{{
+			PublicKey: key.pubKey,
+			ModeInfo: &txtypes.ModeInfo{Sum: &txtypes.ModeInfo_Single_{
+				Single: &txtypes.ModeInfo_Single{Mode: signingtypes.SignMode_SIGN_MODE_DIRECT},
+			}},
+			Sequence: sequence,
+		}},
+		Fee: fee,
+	})
+}
+
+// newTxBody packs msgs into a transaction body.
+func newTxBody(msgs ...proto.Message) (*txtypes.TxBody, error) {
+	body := &txtypes.TxBody{}
+	for _, msg := range msgs {
+		packed, err := newAny(msg)
+		if err != nil {
+			return nil, err
+		}
+		body.Messages = append(body.Messages, packed)
+	}
+	return body, nil
+}
+
+// estimateFee simulates a transaction with an empty signature and returns
+// the fee for the gas it uses. The key's public key may be nil if the
+// account already has one on chain.
+func (s *txSigner) estimateFee(ctx context.Context, bodyBytes []byte, key signerKey, sequence uint64) (*txtypes.Fee, error) {
+	simAuthInfo, err := authInfoBytes(key, sequence, &txtypes.Fee{})
+	if err != nil {
+		return nil, err
+	}
+	simTx, err := proto.Marshal(&txtypes.TxRaw{BodyBytes: bodyBytes, AuthInfoBytes: simAuthInfo, Signatures: [][]byte{{}}})
+	if err != nil {
+		return nil, err
+	}
+	sim, err := txtypes.NewServiceClient(s.conn).Simulate(ctx, &txtypes.SimulateRequest{TxBytes: simTx})
+	if err != nil {
+		return nil, fmt.Errorf("failed to simulate transaction: %w", err)
+	}
+	gas := uint64(math.Ceil(float64(sim.GetGasInfo().GetGasUsed()) * s.gasAdjustment))
+	price := s.price(ctx)
+	fee := &txtypes.Fee{Amount: []*basetypes.Coin{price.fee(gas)}, GasLimit: gas}
+	if amount, _ := strconv.ParseUint(fee.Amount[0].Amount, 10, 64); s.maxFee > 0 && amount > s.maxFee {
+		return nil, fmt.Errorf("%w: %d%s for %d gas at %g%s is over the %d%s cap", errFeeTooHigh, amount, price.denom, gas, price.amount, price.denom, s.maxFee, price.denom)
+	}
+	log.Printf("Simulated transaction uses %d gas; paying %s%s for %d", sim.GetGasInfo().GetGasUsed(), fee.Amount[0].Amount, fee.Amount[0].Denom, gas)
+	return fee, nil
+}
+
+// price returns the gas price to pay: the configured one, or the node's
+// minimum gas price if that is higher, since the node would refuse the
+// transaction otherwise. Nodes that don't report one get the configured
+// price.
+func (s *txSigner) price(ctx context.Context) gasPrice {
+	resp, err := nodetypes.NewServiceClient(s.conn).Config(ctx, &nodetypes.ConfigRequest{})
+	if err != nil {
+		log.Printf("Failed to query the node's minimum gas price, paying %g%s: %v", s.gasPrice.amount, s.gasPrice.denom, err)
+		return s.gasPrice
+	}
+	minimum, err := minGasPrice(resp.GetMinimumGasPrice(), s.gasPrice.denom)
+	if err != nil {
+		log.Printf("Node reported an invalid minimum gas price, paying %g%s: %v", s.gasPrice.amount, s.gasPrice.denom, err)
+		return s.gasPrice
+	}
+	if minimum.amount > s.gasPrice.amount {
+		log.Printf("Node's minimum gas price %g%s is above -gas-prices; paying it", minimum.amount, minimum.denom)
+		return minimum
+	}
+	return s.gasPrice
+}
+
+// minGasPrice picks the price in denom out of a node's minimum gas prices,
+// such as 0.002000000000000000utia,0.1uother. It is zero if the node sets
+// none for denom.
+func minGasPrice(prices, denom string) (gasPrice, error) {
+	for _, p := range strings.Split(prices, ",") {
+		if strings.TrimSpace(p) == "" {
+			continue
+		}
+		price, err := parseGasPrice(p)
+		if err != nil {
+			return gasPrice{}, err
+		}
+		if price.denom == denom {
+			return price, nil
+		}
+	}
+	return gasPrice{denom: denom}, nil
+}
+
+// submit simulates msgs for gas, signs them into a transaction, broadcasts
+// it and waits for it to be included in a block.
+func (s *txSigner) submit(ctx context.Context, msgs ...proto.Message) (*abcitypes.TxResponse, error) {
+	key, err := s.key()
+	if err != nil {
+		return nil, err
+	}
+	account, err := s.account(ctx, key.bech32(accountPrefix))
+	if err != nil {
+		return nil, err
+	}
+	body, err := newTxBody(msgs...)
+	if err != nil {
+		return nil, err
+	}
+	bodyBytes, err := proto.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	fee, err := s.estimateFee(ctx, bodyBytes, key, account.GetSequence())
+	if err != nil {
+		return nil, err
+	}
+
+	authInfo, err := authInfoBytes(key, account.GetSequence(), fee)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := s.sign(bodyBytes, authInfo, account.GetAccountNumber())
+	if err != nil {
+		return nil, err
+	}
+	tx, err := proto.Marshal(&txtypes.TxRaw{BodyBytes: bodyBytes, AuthInfoBytes: authInfo, Signatures: [][]byte{signature}})
+	if err != nil {
+		return nil, err
+	}
+	return s.broadcast(ctx, tx)
+}
+
+// sign signs a transaction in SIGN_MODE_DIRECT.
+func (s *txSigner) sign(bodyBytes, authInfo []byte, accountNumber uint64) ([]byte, error) {
+	signDoc, err := signDocBytes(bodyBytes, authInfo, s.chainID, accountNumber)
+	if err != nil {
+		return nil, err
+	}
+	signature, _, err := s.keyring.Sign(s.from, signDoc, signing.SignMode_SIGN_MODE_DIRECT)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign with %s: %w", s.from, err)
+	}
+	return signature, nil
+}
+
+// signDocBytes returns the bytes signed in SIGN_MODE_DIRECT.
+func signDocBytes(bodyBytes, authInfo []byte, chainID string, accountNumber uint64) ([]byte, error) {
+	return proto.MarshalOptions{Deterministic: true}.Marshal(&txtypes.SignDoc{
+		BodyBytes:     bodyBytes,
+		AuthInfoBytes: authInfo,
+		ChainId:       chainID,
+		AccountNumber: accountNumber,
+	})
+}
+
+// broadcast sends a signed transaction and waits for it to be included in
+// a block. Transactions rejected by CheckTx or failing in the block are
+// returned with an error.
+func (s *txSigner) broadcast(ctx context.Context, tx []byte) (*abcitypes.TxResponse, error) {
+	resp, err := txtypes.NewServiceClient(s.conn).BroadcastTx(ctx, &txtypes.BroadcastTxRequest{
+		TxBytes: tx,
+		Mode:    txtypes.BroadcastMode_BROADCAST_MODE_SYNC,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to broadcast transaction: %w", err)
+	}
+	if code := resp.GetTxResponse().GetCode(); code != 0 {
+		return resp.GetTxResponse(), fmt.Errorf("transaction rejected with code %d: %s", code, resp.GetTxResponse().GetRawLog())
+	}
+	hash := resp.GetTxResponse().GetTxhash()
+	if hash == "" {
+		sum := sha256.Sum256(tx)
+		hash = strings.ToUpper(hex.EncodeToString(sum[:]))
+	}
+	log.Printf("Broadcast transaction %s, waiting for it to be included", hash)
+	return s.waitForTx(ctx, hash)
+}
+
+// waitForTx polls for a transaction until it is included or the timeout
+// passes.
+func (s *txSigner) waitForTx(ctx context.Context, hash string) (*abcitypes.TxResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	client := txtypes.NewServiceClient(s.conn)
+	var lastErr error
+	for {
+		// Not found until included, so any error just means try again
+		resp, err := client.GetTx(ctx, &txtypes.GetTxRequest{Hash: hash})
+		if err == nil {
+			result := resp.GetTxResponse()
+			if result.GetCode() != 0 {
+				return result, fmt.Errorf("transaction %s failed with code %d: %s", hash, result.GetCode(), result.GetRawLog())
+			}
+			return result, nil
+		}
+		lastErr = err
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("transaction %s not included within %s: %w", hash, s.timeout, lastErr)
+		}
+	}
+}
@@ -1,11 +1,14 @@
 package main
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
 
 var (
-	GrpcServerAddress      string
-	GrpcUseTLS             bool
 	HttpServerPort         string
+	TargetVersion          uint64
 	RequiredThresholdPower float64 = 0.80
 )
 
@@ -46,11 +49,219 @@ var (
 			Help: "Threshold percent signalled for the upgrade",
 		},
 	)
+	tallyVotingPower = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "celestia_tally_voting_power",
+			Help: "Voting power that has signalled for the target version",
+		},
+	)
+	tallyVotingPercent = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "celestia_tally_voting_percent",
+			Help: "Fraction of the total voting power that has signalled for the target version",
+		},
+	)
+	chainHeight = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "celestia_chain_height",
+			Help: "Latest block height seen on the CometBFT websocket",
+		},
+	)
+	chainAppVersion = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "celestia_chain_app_version",
+			Help: "App version in the latest block header seen on the CometBFT websocket",
+		},
+	)
+	chainHalted = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "celestia_chain_halted",
+			Help: "1 if no block has been seen within the halt threshold, 0 otherwise",
+		},
+	)
+	websocketConnected = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "celestia_rpc_websocket_connected",
+			Help: "1 if the CometBFT websocket subscription is connected, 0 otherwise",
+		},
+	)
+	eventsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "celestia_monitor_events_total",
+			Help: "Number of upgrade events emitted, by kind and severity",
+		},
+		[]string{"kind", "severity"},
+	)
+	lastSuccessfulPoll = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "celestia_monitor_last_successful_poll_timestamp_seconds",
+			Help: "Unix time of the last successful poll",
+		},
+	)
+	pollDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "celestia_monitor_poll_duration_seconds",
+			Help:    "Time taken by each poll, by result",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"result"},
+	)
+	rpcErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "celestia_monitor_rpc_errors_total",
+			Help: "Failed upstream RPCs, by method and gRPC status code",
+		},
+		[]string{"method", "code"},
+	)
+	rpcDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "celestia_monitor_rpc_duration_seconds",
+			Help:    "Latency of upstream RPCs, by endpoint and method",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"endpoint", "method"},
+	)
+	upgradeVerified = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "celestia_upgrade_verified",
+			Help: "1 if the latest verified poll matched the light client headers and Merkle proofs, 0 otherwise",
+		},
+	)
+	tryUpgradeSubmissions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "celestia_monitor_try_upgrade_submissions_total",
+			Help: "Automatic MsgTryUpgrade submissions, by result (submitted, failed, skipped)",
+		},
+		[]string{"result"},
+	)
+	authzGrantExpiry = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "celestia_authz_grant_expiry_timestamp_seconds",
+			Help: "Unix time a validator's signal grant to a grantee expires, 0 if it never does",
+		},
+		[]string{"validator", "grantee"},
+	)
+	authzGrantExpiring = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "celestia_authz_grant_expiring",
+			Help: "1 if a validator's signal grant to a grantee is missing or expires within -authz-warn-before, 0 otherwise",
+		},
+		[]string{"validator", "grantee"},
+	)
+	ownValidatorSignalled = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "celestia_own_validator_signalled",
+			Help: "1 if one of our validators has signalled the target version, 0 otherwise",
+		},
+		[]string{"valoper"},
+	)
+	ownValidatorAlertLevel = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "celestia_own_validator_alert_level",
+			Help: "Number of -self-alert-at milestones the network has reached while one of our validators hasn't signalled",
+		},
+		[]string{"valoper"},
+	)
+	nodeReady = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "celestia_node_ready",
+			Help: "1 if one of our nodes runs a release that supports the target app version, 0 otherwise",
+		},
+		[]string{"node"},
+	)
+	nodeInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "celestia_node_info",
+			Help: "Always 1, labelled with the release and git commit one of our nodes runs",
+		},
+		[]string{"node", "version", "git_commit"},
+	)
+	fleetNodeCheck = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "celestia_fleet_node_check",
+			Help: "1 if a fleet node passes a readiness check (reachable, synced, chain_id, upgrade), 0 otherwise",
+		},
+		[]string{"name", "role", "check"},
+	)
+	fleetNodeHeight = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "celestia_fleet_node_height",
+			Help: "Latest block height of a fleet node",
+		},
+		[]string{"name", "role"},
+	)
+	fleetNodeLag = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "celestia_fleet_node_lag_blocks",
+			Help: "Blocks a fleet node is behind the highest height seen",
+		},
+		[]string{"name", "role"},
+	)
+	fleetNodeAppVersion = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "celestia_fleet_node_app_version",
+			Help: "App version in a fleet node's latest block header",
+		},
+		[]string{"name", "role"},
+	)
+	cosmovisorCheck = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "celestia_cosmovisor_check",
+			Help: "1 if a cosmovisor layout check (binary_present, binary_version, checksum, current_link) passes, 0 if it fails, absent when skipped",
+		},
+		[]string{"check"},
+	)
+	cosmovisorUpgradeInfoHeight = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "celestia_cosmovisor_upgrade_info_height",
+			Help: "Height in the latest data/upgrade-info.json the node wrote when halting for an upgrade",
+		},
+	)
+	nodeLogMatches = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "celestia_node_log_matches_total",
+			Help: "Upgrade related node log lines seen, by match (upgrade_needed, halt, app_version_switch, panic, consensus_failure)",
+		},
+		[]string{"match"},
+	)
+	readinessCheck = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "celestia_readiness_check",
+			Help: "Result of a pre-upgrade checklist item: 0 pass, 1 warn, 2 fail, absent when skipped",
+		},
+		[]string{"check"},
+	)
+	readinessSummary = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "celestia_readiness_checks",
+			Help: "Number of pre-upgrade checklist items by status (pass, warn, fail, skip)",
+		},
+		[]string{"status"},
+	)
+	snapshotAgeSeconds = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "celestia_monitor_snapshot_age_seconds",
+			Help: "Age of the latest successful poll in seconds, -1 before the first one",
+		},
+		snapshotAge,
+	)
+	pollInterval = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "celestia_monitor_poll_interval_seconds",
+			Help: "Current interval between polls of the signal service",
+		},
+	)
 )
 
 type UpgradeData struct {
+	ChainID     string          `json:"chain_id"`
+	Height      int64           `json:"height"`
+	BlockTime   time.Time       `json:"block_time"`
+	AppVersion  uint64          `json:"app_version"`
 	UpgradeData UpgradeResponse `json:"upgrade_data"`
 	TallyData   TallyResponse   `json:"tally_data"`
+	Verified    bool            `json:"verified"`
+	VerifyError string          `json:"verify_error,omitempty"`
 }
 
 type UpgradeResponse struct {
@@ -63,6 +274,9 @@ type Upgrade struct {
 }
 
 type TallyResponse struct {
+	Version          uint64  `json:"version"`
+	VotingPower      int64   `json:"voting_power"`
+	VotingPercent    float64 `json:"voting_percent"`
 	TotalVotingPower int64   `json:"total_voting_power"`
 	ThresholdPower   int64   `json:"threshold_power"`
 	ThresholdPercent float64 `json:"threshold_percent"`
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	signaltypes "celestia-upgrade-monitor/celestia/signal/v1"
+
+	dbm "github.com/cometbft/cometbft-db"
+	cmtlog "github.com/cometbft/cometbft/libs/log"
+	"github.com/cometbft/cometbft/light"
+	dbs "github.com/cometbft/cometbft/light/store/db"
+	cmttypes "github.com/cometbft/cometbft/types"
+	ics23 "github.com/cosmos/ics23/go"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// Store keys read in verified mode, as laid out by celestia-app's signal
+// module and the SDK's staking module.
+var (
+	signalUpgradeKey       = []byte{0x00}
+	stakingLastPowerKey    = []byte{0x11}
+	stakingLastTotalKey    = []byte{0x12}
+	stakingByConsAddrKey   = []byte{0x22}
+	verifyConcurrency      = 8
+	verifyNextBlockTimeout = 30 * time.Second
+)
+
+// lightClient verifies headers; it is a *light.Client outside of tests.
+type lightClient interface {
+	VerifyLightBlockAtHeight(ctx context.Context, height int64, now time.Time) (*cmttypes.LightBlock, error)
+}
+
+// stateVerifier checks query responses against Merkle proofs of the
+// application state, anchored to headers verified by a CometBFT light
+// client.
+type stateVerifier struct {
+	chainID string
+	light   lightClient
+	rpc     *cometBackend
+}
+
+// verifier is set when running in verified mode.
+var verifier *stateVerifier
+
+// newStateVerifier starts a light client from the trusted height and hash,
+// using primary for proofs and headers and cross-checking the headers with
+// the witnesses.
+func newStateVerifier(ctx context.Context, primary string, witnesses []string, trustedHeight int64, trustedHash []byte, trustPeriod time.Duration) (*stateVerifier, error) {
+	primaryURL, err := rpcHTTPURL(primary)
+	if err != nil {
+		return nil, err
+	}
+	witnessURLs := make([]string, 0, len(witnesses))
+	for _, w := range witnesses {
+		u, err := rpcHTTPURL(w)
+		if err != nil {
+			return nil, err
+		}
+		witnessURLs = append(witnessURLs, u)
+	}
+
+	address, err := parseEndpointAddress(transportComet + "+" + primaryURL)
+	if err != nil {
+		return nil, err
+	}
+	rpc, err := newCometBackend(address)
+	if err != nil {
+		return nil, err
+	}
+	latest, err := rpc.Block(ctx, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chain ID from %s: %w", address, err)
+	}
+
+	client, err := light.NewHTTPClient(ctx, latest.ChainID,
+		light.TrustOptions{Period: trustPeriod, Height: trustedHeight, Hash: trustedHash},
+		primaryURL, witnessURLs,
+		dbs.New(dbm.NewMemDB(), latest.ChainID),
+		light.Logger(cmtlog.NewNopLogger()),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start light client: %w", err)
+	}
+	return &stateVerifier{chainID: latest.ChainID, light: client, rpc: rpc}, nil
+}
+
+// lightBlock returns the verified header at height, waiting for it to be
+// produced if needed.
+func (v *stateVerifier) lightBlock(ctx context.Context, height int64) (*cmttypes.LightBlock, error) {
+	ctx, cancel := context.WithTimeout(ctx, verifyNextBlockTimeout)
+	defer cancel()
+	for {
+		lb, err := v.light.VerifyLightBlockAtHeight(ctx, height, time.Now())
+		if err == nil {
+			return lb, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to verify header %d: %w", height, err)
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// proveKey reads key from a module store at height and checks its proof
+// against appHash, which must come from the header at height+1. It returns
+// nil for a key proven to be absent.
+func (v *stateVerifier) proveKey(ctx context.Context, store string, key []byte, height int64, appHash []byte) ([]byte, error) {
+	resp, err := v.rpc.abciQuery(ctx, "/store/"+store+"/key", key, height, true)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Height != height {
+		return nil, fmt.Errorf("proof served at height %d, want %d", resp.Height, height)
+	}
+	if resp.ProofOps == nil || len(resp.ProofOps.Ops) != 2 {
+		return nil, errors.New("expected a store proof and a multistore proof")
+	}
+	storeOp, rootOp := resp.ProofOps.Ops[0], resp.ProofOps.Ops[1]
+	if storeOp.Type != "ics23:iavl" || !bytes.Equal(storeOp.Key, key) {
+		return nil, fmt.Errorf("unexpected store proof %s for key %X", storeOp.Type, storeOp.Key)
+	}
+	if rootOp.Type != "ics23:simple" || string(rootOp.Key) != store {
+		return nil, fmt.Errorf("unexpected multistore proof %s for store %q", rootOp.Type, rootOp.Key)
+	}
+
+	var storeProof, rootProof ics23.CommitmentProof
+	if err := storeProof.Unmarshal(storeOp.Data); err != nil {
+		return nil, fmt.Errorf("invalid store proof: %w", err)
+	}
+	if err := rootProof.Unmarshal(rootOp.Data); err != nil {
+		return nil, fmt.Errorf("invalid multistore proof: %w", err)
+	}
+
+	storeRoot, err := storeProof.Calculate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid store proof: %w", err)
+	}
+	if len(resp.Value) > 0 {
+		if !ics23.VerifyMembership(ics23.IavlSpec, storeRoot, &storeProof, key, resp.Value) {
+			return nil, fmt.Errorf("store proof for key %X does not verify", key)
+		}
+	} else if !ics23.VerifyNonMembership(ics23.IavlSpec, storeRoot, &storeProof, key) {
+		return nil, fmt.Errorf("absence proof for key %X does not verify", key)
+	}
+
+	root, err := rootProof.Calculate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid multistore proof: %w", err)
+	}
+	if !ics23.VerifyMembership(ics23.TendermintSpec, root, &rootProof, []byte(store), storeRoot) {
+		return nil, fmt.Errorf("multistore proof for %s does not verify", store)
+	}
+	if !bytes.Equal(root, appHash) {
+		return nil, fmt.Errorf("proof root %X does not match app hash %X", []byte(root), appHash)
+	}
+	if len(resp.Value) == 0 {
+		return nil, nil
+	}
+	return resp.Value, nil
+}
+
+// check verifies every field of data that comes from the chain: the block,
+// the scheduled upgrade and the tally's voting power. The threshold is
+// derived from the total by the node and isn't checked separately.
+func (v *stateVerifier) check(ctx context.Context, data UpgradeData) error {
+	if data.ChainID != v.chainID {
+		return fmt.Errorf("response is for chain %q, light client follows %q", data.ChainID, v.chainID)
+	}
+	header, err := v.lightBlock(ctx, data.Height)
+	if err != nil {
+		return err
+	}
+	if header.Version.App != data.AppVersion || !header.Time.Equal(data.BlockTime) {
+		return fmt.Errorf("block %d does not match the verified header", data.Height)
+	}
+	// The state after block H is committed to in the app hash of H+1
+	next, err := v.lightBlock(ctx, data.Height+1)
+	if err != nil {
+		return err
+	}
+	appHash := next.AppHash
+
+	value, err := v.proveKey(ctx, "signal", signalUpgradeKey, data.Height, appHash)
+	if err != nil {
+		return fmt.Errorf("upgrade: %w", err)
+	}
+	var upgrade signaltypes.Upgrade
+	if err := proto.Unmarshal(value, &upgrade); err != nil {
+		return fmt.Errorf("upgrade: %w", err)
+	}
+	if uint64(data.UpgradeData.Upgrade.AppVersion) != upgrade.GetAppVersion() || data.UpgradeData.Upgrade.UpgradeHeight != upgrade.GetUpgradeHeight() {
+		return fmt.Errorf("upgrade does not match the proven state (version %d at height %d)", upgrade.GetAppVersion(), upgrade.GetUpgradeHeight())
+	}
+
+	value, err = v.proveKey(ctx, "staking", stakingLastTotalKey, data.Height, appHash)
+	if err != nil {
+		return fmt.Errorf("total power: %w", err)
+	}
+	total, err := decodeIntProto(value)
+	if err != nil {
+		return fmt.Errorf("total power: %w", err)
+	}
+	if total != data.TallyData.TotalVotingPower {
+		return fmt.Errorf("total voting power %d does not match the proven %d", data.TallyData.TotalVotingPower, total)
+	}
+
+	set, err := v.powerSet(ctx, next)
+	if err != nil {
+		return err
+	}
+	power, err := v.signalledPower(ctx, set, data.TallyData.Version, data.Height, appHash)
+	if err != nil {
+		return err
+	}
+	if power != data.TallyData.VotingPower {
+		return fmt.Errorf("voting power %d does not match the proven %d", data.TallyData.VotingPower, power)
+	}
+	return nil
+}
+
+// powerSet returns the validator set whose power the staking state after
+// the block before next holds. Validator updates from a block's EndBlock
+// take effect two blocks later, so that is neither next's set nor the one
+// before it but the set for next.Height+1, which next commits to as its
+// next validators.
+func (v *stateVerifier) powerSet(ctx context.Context, next *cmttypes.LightBlock) (*cmttypes.ValidatorSet, error) {
+	vals, err := v.rpc.validators(ctx, next.Height+1)
+	if err != nil {
+		return nil, err
+	}
+	// Summing the power panics past the maximum
+	var total int64
+	for _, val := range vals {
+		if val.VotingPower < 0 || val.VotingPower > cmttypes.MaxTotalVotingPower-total {
+			return nil, fmt.Errorf("validator set %d has too much voting power", next.Height+1)
+		}
+		total += val.VotingPower
+	}
+	set, err := cmttypes.ValidatorSetFromExistingValidators(vals)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(set.Hash(), next.NextValidatorsHash) {
+		return nil, fmt.Errorf("validator set %d does not match the verified header", next.Height+1)
+	}
+	return set, nil
+}
+
+// signalledPower sums the proven power of the validators in set that have
+// signalled for version. Validators outside the active set have no power so
+// don't count towards the tally.
+func (v *stateVerifier) signalledPower(ctx context.Context, set *cmttypes.ValidatorSet, version uint64, height int64, appHash []byte) (int64, error) {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		total    int64
+		firstErr error
+	)
+	sem := make(chan struct{}, verifyConcurrency)
+	for _, val := range set.Validators {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(consAddr []byte) {
+			defer func() { <-sem; wg.Done() }()
+			power, err := v.validatorSignal(ctx, consAddr, version, height, appHash)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("validator %X: %w", consAddr, err)
+			}
+			total += power
+		}(val.Address)
+	}
+	wg.Wait()
+	return total, firstErr
+}
+
+// validatorSignal returns the proven power of a validator if it signalled
+// for version, and 0 otherwise.
+func (v *stateVerifier) validatorSignal(ctx context.Context, consAddr []byte, version uint64, height int64, appHash []byte) (int64, error) {
+	valoper, err := v.proveKey(ctx, "staking", lengthPrefixedKey(stakingByConsAddrKey, consAddr), height, appHash)
+	if err != nil {
+		return 0, err
+	}
+	if valoper == nil {
+		return 0, errors.New("no operator address in state")
+	}
+	signal, err := v.proveKey(ctx, "signal", valoper, height, appHash)
+	if err != nil {
+		return 0, err
+	}
+	if len(signal) != 8 || binary.BigEndian.Uint64(signal) != version {
+		return 0, nil
+	}
+	value, err := v.proveKey(ctx, "staking", lengthPrefixedKey(stakingLastPowerKey, valoper), height, appHash)
+	if err != nil || value == nil {
+		return 0, err
+	}
+	var power wrapperspb.Int64Value
+	if err := proto.Unmarshal(value, &power); err != nil {
+		return 0, err
+	}
+	return power.GetValue(), nil
+}
+
+// lengthPrefixedKey builds an SDK store key of a prefix and an address.
+func lengthPrefixedKey(prefix, addr []byte) []byte {
+	key := append([]byte{}, prefix...)
+	key = append(key, byte(len(addr)))
+	return append(key, addr...)
+}
+
+// decodeIntProto decodes an sdk.IntProto, which holds an integer as its
+// decimal string.
+func decodeIntProto(b []byte) (int64, error) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+		b = b[n:]
+		if num == 1 && typ == protowire.BytesType {
+			value, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return 0, protowire.ParseError(n)
+			}
+			i, ok := new(big.Int).SetString(string(value), 10)
+			if !ok || !i.IsInt64() {
+				return 0, fmt.Errorf("invalid integer %q", value)
+			}
+			return i.Int64(), nil
+		}
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return 0, nil
+}
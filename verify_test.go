@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	signaltypes "celestia-upgrade-monitor/celestia/signal/v1"
+	"celestia-upgrade-monitor/fake"
+
+	"github.com/cometbft/cometbft/crypto/ed25519"
+	cmtjson "github.com/cometbft/cometbft/libs/json"
+	cmtversion "github.com/cometbft/cometbft/proto/tendermint/version"
+	coretypes "github.com/cometbft/cometbft/rpc/core/types"
+	cmttypes "github.com/cometbft/cometbft/types"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// validators RPC from fixed sets, for the verifier tests.
+type provenNode struct {
+	state  *fake.State
+	height int64
+	sets   map[int64][]*cmttypes.Validator
+
+	mu sync.Mutex
+	// tamper, when set, changes query responses before they are sent
+	tamper func(store string, resp *abciQueryResponse)
+}
+
+func (n *provenNode) setTamper(tamper func(store string, resp *abciQueryResponse)) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.tamper = tamper
+}
+
+func (n *provenNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req rpcRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var result any
+	switch req.Method {
+	case "abci_query":
+		path, _ := req.Params["path"].(string)
+		store := strings.TrimSuffix(strings.TrimPrefix(path, "/store/"), "/key")
+		data, _ := req.Params["data"].(string)
+		key, _ := hex.DecodeString(data)
+		value, ops, err := n.state.Prove(store, key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp := abciQueryResponse{Key: key, Value: value, Height: n.height, ProofOps: &proofOps{}}
+		for _, op := range ops {
+			resp.ProofOps.Ops = append(resp.ProofOps.Ops, proofOp{Type: op.Type, Key: op.Key, Data: op.Data})
+		}
+		n.mu.Lock()
+		if n.tamper != nil {
+			n.tamper(store, &resp)
+		}
+		n.mu.Unlock()
+		result = map[string]any{"response": resp}
+	case "validators":
+		heightParam, _ := req.Params["height"].(string)
+		height, _ := strconv.ParseInt(heightParam, 10, 64)
+		vals := n.sets[height]
+		raw, err := cmtjson.Marshal(coretypes.ResultValidators{BlockHeight: height, Validators: vals, Count: len(vals), Total: len(vals)})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		result = json.RawMessage(raw)
+	}
+	json.NewEncoder(w).Encode(map[string]any{"jsonrpc": "2.0", "id": req.ID, "result": result})
+}
+
+// stubLight serves fixed light blocks as if they were verified.
+type stubLight map[int64]*cmttypes.LightBlock
+
+func (l stubLight) VerifyLightBlockAtHeight(_ context.Context, height int64, _ time.Time) (*cmttypes.LightBlock, error) {
+	if lb, ok := l[height]; ok {
+		return lb, nil
+	}
+	return nil, fmt.Errorf("no light block at height %d", height)
+}
+
+// intProto encodes n as an sdk.IntProto.
+func intProto(n string) []byte {
+	return protowire.AppendString(protowire.AppendTag(nil, 1, protowire.BytesType), n)
+}
+
+// newProvenNode sets up a verifier against a node at height 100 whose
+// state has validators of power 40 and 30 signalling for version 2, 20 for
+// version 1 and 10 not signalling, and one of power 15 signalling for
+// version 2 that is bonded in block 100 so only joins the set at 102. It
+// returns the verifier and the poll result the state proves.
+func newProvenNode(t *testing.T) (*stateVerifier, *provenNode, UpgradeData) {
+	t.Helper()
+	const height = 100
+	state := fake.NewState()
+	node := &provenNode{state: state, height: height, sets: map[int64][]*cmttypes.Validator{}}
+
+	var active, bonded []*cmttypes.Validator
+	for i, v := range []struct {
+		power   int64
+		version uint64
+	}{{40, 2}, {30, 2}, {20, 1}, {10, 0}, {15, 2}} {
+		val := cmttypes.NewValidator(ed25519.GenPrivKeyFromSecret([]byte{byte(i)}).PubKey(), v.power)
+		valoper := bytes.Repeat([]byte{byte(i + 1)}, 20)
+		state.Set("staking", lengthPrefixedKey(stakingByConsAddrKey, val.Address), valoper)
+		power, err := proto.Marshal(wrapperspb.Int64(v.power))
+		if err != nil {
+			t.Fatal(err)
+		}
+		state.Set("staking", lengthPrefixedKey(stakingLastPowerKey, valoper), power)
+		if v.version > 0 {
+			state.Set("signal", valoper, binary.BigEndian.AppendUint64(nil, v.version))
+		}
+		if i < 4 {
+			active = append(active, val.Copy())
+		}
+		bonded = append(bonded, val)
+	}
+	state.Set("staking", stakingLastTotalKey, intProto("115"))
+	upgrade, err := proto.Marshal(&signaltypes.Upgrade{AppVersion: 2, UpgradeHeight: 250})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Set("signal", signalUpgradeKey, upgrade)
+	node.sets[height+2] = bonded
+
+	srv := httptest.NewServer(node)
+	t.Cleanup(srv.Close)
+	address, err := parseEndpointAddress(transportComet + "+" + srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpc, err := newCometBackend(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blockTime := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	activeSet := cmttypes.NewValidatorSet(active)
+	bondedSet := cmttypes.NewValidatorSet(bonded)
+	header := func(h int64, appHash, nextVals []byte) *cmttypes.LightBlock {
+		return &cmttypes.LightBlock{
+			SignedHeader: &cmttypes.SignedHeader{Header: &cmttypes.Header{
+				Version:            cmtversion.Consensus{App: 1},
+				ChainID:            testChainID,
+				Height:             h,
+				Time:               blockTime.Add(time.Duration(h-height) * 6 * time.Second),
+				AppHash:            appHash,
+				NextValidatorsHash: nextVals,
+			}},
+			ValidatorSet: activeSet,
+		}
+	}
+	v := &stateVerifier{
+		chainID: testChainID,
+		light: stubLight{
+			height:     header(height, nil, activeSet.Hash()),
+			height + 1: header(height+1, state.AppHash(), bondedSet.Hash()),
+		},
+		rpc: rpc,
+	}
+	data := UpgradeData{
+		ChainID:     testChainID,
+		Height:      height,
+		BlockTime:   blockTime,
+		AppVersion:  1,
+		UpgradeData: UpgradeResponse{Upgrade: Upgrade{AppVersion: 2, UpgradeHeight: 250}},
+		TallyData:   TallyResponse{Version: 2, VotingPower: 85, TotalVotingPower: 115},
+	}
+	return v, node, data
+}
+
+func TestStateVerifier(t *testing.T) {
+	v, node, data := newProvenNode(t)
+	ctx := context.Background()
+	if err := v.check(ctx, data); err != nil {
+		t.Fatalf("honest response didn't verify: %v", err)
+	}
+
+	for name, change := range map[string]func(d *UpgradeData){
+		"app version":   func(d *UpgradeData) { d.AppVersion = 2 },
+		"block time":    func(d *UpgradeData) { d.BlockTime = d.BlockTime.Add(time.Second) },
+		"chain":         func(d *UpgradeData) { d.ChainID = "other" },
+		"upgrade":       func(d *UpgradeData) { d.UpgradeData.Upgrade.UpgradeHeight = 300 },
+		"total power":   func(d *UpgradeData) { d.TallyData.TotalVotingPower = 100 },
+		"voting power":  func(d *UpgradeData) { d.TallyData.VotingPower = 90 },
+		"tally version": func(d *UpgradeData) { d.TallyData.Version = 1 },
+		// Without the validator bonded in block 100
+		"power of block 101's set": func(d *UpgradeData) { d.TallyData.VotingPower = 70 },
+	} {
+		changed := data
+		change(&changed)
+		if err := v.check(ctx, changed); err == nil {
+			t.Errorf("response with a different %s verified", name)
+		}
+	}
+
+	// A node that leaves a validator out of the set
+	bonded := node.sets[102]
+	node.sets[102] = bonded[:4]
+	if err := v.check(ctx, data); err == nil || !strings.Contains(err.Error(), "validator set 102") {
+		t.Errorf("validator set without the newly bonded validator: got %v", err)
+	}
+	node.sets[102] = bonded
+
+	// A node that hides a signal must not lower the proven power
+	node.setTamper(func(store string, resp *abciQueryResponse) {
+		if store == "signal" && len(resp.Value) == 8 && resp.Key[0] == 1 {
+			resp.Value = binary.BigEndian.AppendUint64(nil, 1)
+		}
+	})
+	power, err := v.signalledPower(ctx, cmttypes.NewValidatorSet(bonded), 2, data.Height, v.light.(stubLight)[101].AppHash)
+	if err == nil {
+		t.Errorf("tampered signal verified with power %d", power)
+	}
+}
+
+func TestProveKey(t *testing.T) {
+	v, node, data := newProvenNode(t)
+	ctx := context.Background()
+	appHash := v.light.(stubLight)[data.Height+1].AppHash
+	absentKey := lengthPrefixedKey(stakingLastPowerKey, bytes.Repeat([]byte{9}, 20))
+
+	value, err := v.proveKey(ctx, "staking", stakingLastTotalKey, data.Height, appHash)
+	if err != nil || !bytes.Equal(value, intProto("115")) {
+		t.Fatalf("proveKey = %x, %v", value, err)
+	}
+	if value, err := v.proveKey(ctx, "staking", absentKey, data.Height, appHash); err != nil || value != nil {
+		t.Fatalf("proveKey of an absent key = %x, %v", value, err)
+	}
+	if value, err := v.proveKey(ctx, "signal", []byte{0xff}, data.Height, appHash); err != nil || value != nil {
+		t.Fatalf("proveKey of a key after the last = %x, %v", value, err)
+	}
+
+	other := fake.NewState()
+	other.Set("staking", stakingLastTotalKey, intProto("999"))
+	otherValue, otherOps, err := other.Prove("staking", stakingLastTotalKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name   string
+		key    []byte
+		tamper func(resp *abciQueryResponse)
+	}{
+		{"wrong height", stakingLastTotalKey, func(r *abciQueryResponse) { r.Height-- }},
+		{"no proof", stakingLastTotalKey, func(r *abciQueryResponse) { r.ProofOps = nil }},
+		{"no multistore proof", stakingLastTotalKey, func(r *abciQueryResponse) { r.ProofOps.Ops = r.ProofOps.Ops[:1] }},
+		{"wrong proof type", stakingLastTotalKey, func(r *abciQueryResponse) { r.ProofOps.Ops[0].Type = "ics23:simple" }},
+		{"proof of another key", stakingLastTotalKey, func(r *abciQueryResponse) { r.ProofOps.Ops[0].Key = absentKey }},
+		{"proof of another store", stakingLastTotalKey, func(r *abciQueryResponse) { r.ProofOps.Ops[1].Key = []byte("signal") }},
+		{"garbled store proof", stakingLastTotalKey, func(r *abciQueryResponse) { r.ProofOps.Ops[0].Data = []byte{0xff, 0xff} }},
+		{"garbled multistore proof", stakingLastTotalKey, func(r *abciQueryResponse) { r.ProofOps.Ops[1].Data = []byte{0xff, 0xff} }},
+		{"changed value", stakingLastTotalKey, func(r *abciQueryResponse) { r.Value = intProto("116") }},
+		{"hidden value", stakingLastTotalKey, func(r *abciQueryResponse) { r.Value = nil }},
+		{"invented value", absentKey, func(r *abciQueryResponse) { r.Value = intProto("1") }},
+		{"proof from another state", stakingLastTotalKey, func(r *abciQueryResponse) {
+			r.Value = otherValue
+			r.ProofOps.Ops = []proofOp{{otherOps[0].Type, otherOps[0].Key, otherOps[0].Data}, {otherOps[1].Type, otherOps[1].Key, otherOps[1].Data}}
+		}},
+		{"store root from another state", stakingLastTotalKey, func(r *abciQueryResponse) {
+			r.ProofOps.Ops[1] = proofOp{otherOps[1].Type, otherOps[1].Key, otherOps[1].Data}
+		}},
+	} {
+		node.setTamper(func(_ string, resp *abciQueryResponse) { tt.tamper(resp) })
+		if value, err := v.proveKey(ctx, "staking", tt.key, data.Height, appHash); err == nil {
+			t.Errorf("%s: proveKey = %x, want an error", tt.name, value)
+		}
+	}
+	node.setTamper(nil)
+	if _, err := v.proveKey(ctx, "staking", stakingLastTotalKey, data.Height, []byte("another app hash")); err == nil {
+		t.Error("proof verified against another app hash")
+	}
+}
+
+func TestVerifyEncoding(t *testing.T) {
+	prefix := make([]byte, 1, 8)
+	prefix[0] = 0x22
+	a := lengthPrefixedKey(prefix, []byte{1, 2, 3})
+	b := lengthPrefixedKey(prefix, []byte{4})
+	if !bytes.Equal(a, []byte{0x22, 3, 1, 2, 3}) || !bytes.Equal(b, []byte{0x22, 1, 4}) {
+		t.Errorf("lengthPrefixedKey = %x and %x", a, b)
+	}
+
+	unknownField := protowire.AppendVarint(protowire.AppendTag(nil, 2, protowire.VarintType), 7)
+	for _, tt := range []struct {
+		in   []byte
+		want int64
+		ok   bool
+	}{
+		{intProto("115"), 115, true},
+		{intProto("-5"), -5, true},
+		{append(unknownField, intProto("42")...), 42, true},
+		{nil, 0, true},
+		{intProto("abc"), 0, false},
+		{intProto("9223372036854775808"), 0, false},
+		{intProto("115")[:3], 0, false},
+		{[]byte{0x0a}, 0, false},
+	} {
+		got, err := decodeIntProto(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("decodeIntProto(%x) = %d, %v", tt.in, got, err)
+		}
+	}
+}